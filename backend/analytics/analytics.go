@@ -0,0 +1,264 @@
+package analytics
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+)
+
+const (
+	// CompletedScroll is the depth at which a visit counts as a full read
+	CompletedScroll = 90
+	// Engagement pings report time since the previous ping; anything larger is
+	// a backgrounded tab or a forged request.
+	maxReadDelta = 5 * 60
+)
+
+// Day formats t as the bucket key used by PostVisit and PostDailyStat
+func Day(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+var salt struct {
+	sync.Mutex
+	day   string
+	value []byte
+}
+
+// currentSalt returns today's salt, creating it on first use. The salt is kept
+// in the database so that restarts during the day don't double count visitors;
+// previous days' salts are deleted as soon as the day rolls over.
+func currentSalt() ([]byte, string, error) {
+	salt.Lock()
+	defer salt.Unlock()
+
+	today := Day(time.Now())
+	if salt.day == today {
+		return salt.value, today, nil
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", err
+	}
+	row := models.AnalyticsSalt{Day: today, Salt: hex.EncodeToString(random)}
+	if err := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+		return nil, "", err
+	}
+	// Another instance may have created the salt first
+	if err := database.DB.First(&row, "day = ?", today).Error; err != nil {
+		return nil, "", err
+	}
+	database.DB.Where("day < ?", today).Delete(&models.AnalyticsSalt{})
+
+	value, err := hex.DecodeString(row.Salt)
+	if err != nil {
+		return nil, "", err
+	}
+	salt.day, salt.value = today, value
+	return value, today, nil
+}
+
+// visitorHash identifies a visitor for the current day without storing their IP
+func visitorHash(ip, userAgent string) (string, string, error) {
+	key, day, err := currentSalt()
+	if err != nil {
+		return "", "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ip))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userAgent))
+	return hex.EncodeToString(mac.Sum(nil)), day, nil
+}
+
+// RecordView counts a page view of a post
+func RecordView(postID uint, ip, userAgent string) error {
+	hash, day, err := visitorHash(ip, userAgent)
+	if err != nil {
+		return err
+	}
+	visit := models.PostVisit{PostID: postID, Day: day, VisitorHash: hash, Views: 1, UpdatedAt: time.Now()}
+	return database.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "post_id"}, {Name: "day"}, {Name: "visitor_hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"views":      gorm.Expr("post_visits.views + 1"),
+			"updated_at": time.Now(),
+		}),
+	}).Create(&visit).Error
+}
+
+// RecordEngagement adds reading time and the scroll depth reached so far
+func RecordEngagement(postID uint, ip, userAgent string, scroll, seconds int) error {
+	hash, day, err := visitorHash(ip, userAgent)
+	if err != nil {
+		return err
+	}
+	scroll = clamp(scroll, 0, 100)
+	seconds = clamp(seconds, 0, maxReadDelta)
+
+	visit := models.PostVisit{PostID: postID, Day: day, VisitorHash: hash, MaxScroll: scroll, ReadSeconds: seconds, UpdatedAt: time.Now()}
+	return database.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "post_id"}, {Name: "day"}, {Name: "visitor_hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"max_scroll":   gorm.Expr("GREATEST(post_visits.max_scroll, ?)", scroll),
+			"read_seconds": gorm.Expr("post_visits.read_seconds + ?", seconds),
+			"updated_at":   time.Now(),
+		}),
+	}).Create(&visit).Error
+}
+
+// Rollup folds every finished day of PostVisit rows into PostDailyStat and
+// deletes them, so per-visitor hashes never outlive their day by much. The
+// rows are deleted and counted in one statement: every instance runs
+// rollups, and a concurrent run waits on the deleted rows and then finds
+// them gone, rather than adding the same visits a second time.
+func Rollup() error {
+	return database.DB.Exec(`
+		WITH finished AS (
+			DELETE FROM post_visits WHERE day < ?
+			RETURNING post_id, day, views, max_scroll, read_seconds
+		)
+		INSERT INTO post_daily_stats (post_id, day, views, unique_visitors, scroll_total, read_seconds, completed_reads, updated_at)
+		SELECT post_id, day, SUM(views), COUNT(*), SUM(max_scroll), SUM(read_seconds),
+			SUM(CASE WHEN max_scroll >= ? THEN 1 ELSE 0 END), NOW()
+		FROM finished
+		GROUP BY post_id, day
+		ON CONFLICT (post_id, day) DO UPDATE SET
+			views = post_daily_stats.views + excluded.views,
+			unique_visitors = post_daily_stats.unique_visitors + excluded.unique_visitors,
+			scroll_total = post_daily_stats.scroll_total + excluded.scroll_total,
+			read_seconds = post_daily_stats.read_seconds + excluded.read_seconds,
+			completed_reads = post_daily_stats.completed_reads + excluded.completed_reads,
+			updated_at = excluded.updated_at`, Day(time.Now()), CompletedScroll).Error
+}
+
+// RunRollups calls Rollup on startup and then every interval
+func RunRollups(interval time.Duration) {
+	for {
+		if err := Rollup(); err != nil {
+			log.Printf("analytics rollup failed: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// PostMetrics - Aggregated metrics for one post over a period
+type PostMetrics struct {
+	PostID         uint    `json:"post_id"`
+	Views          int     `json:"views"`
+	UniqueVisitors int     `json:"unique_visitors"`
+	AvgScroll      float64 `json:"avg_scroll"`
+	AvgReadSeconds float64 `json:"avg_read_seconds"`
+	CompletionRate float64 `json:"completion_rate"`
+}
+
+// DailyMetrics - Site wide totals for one day
+type DailyMetrics struct {
+	Day            string `json:"day"`
+	Views          int    `json:"views"`
+	UniqueVisitors int    `json:"unique_visitors"`
+}
+
+type totals struct {
+	PostID         uint
+	Day            string
+	Views          int
+	UniqueVisitors int
+	ScrollTotal    int
+	ReadSeconds    int
+	CompletedReads int
+}
+
+// Since gathers rolled up and live metrics from the given day onwards
+func Since(from string) (map[uint]*PostMetrics, []DailyMetrics, error) {
+	var rows []totals
+	err := database.DB.Model(&models.PostDailyStat{}).
+		Select("post_id, day, views, unique_visitors, scroll_total, read_seconds, completed_reads").
+		Where("day >= ?", from).Scan(&rows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Today (and any day not rolled up yet) straight from the visit rows
+	var live []totals
+	err = database.DB.Model(&models.PostVisit{}).
+		Select(`post_id, day, SUM(views) AS views, COUNT(*) AS unique_visitors, SUM(max_scroll) AS scroll_total,
+			SUM(read_seconds) AS read_seconds, SUM(CASE WHEN max_scroll >= ? THEN 1 ELSE 0 END) AS completed_reads`, CompletedScroll).
+		Where("day >= ?", from).Group("post_id, day").Scan(&live).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	rows = append(rows, live...)
+
+	perPost := map[uint]*totals{}
+	perDay := map[string]*DailyMetrics{}
+	for _, row := range rows {
+		s, ok := perPost[row.PostID]
+		if !ok {
+			s = &totals{}
+			perPost[row.PostID] = s
+		}
+		s.Views += row.Views
+		s.UniqueVisitors += row.UniqueVisitors
+		s.ScrollTotal += row.ScrollTotal
+		s.ReadSeconds += row.ReadSeconds
+		s.CompletedReads += row.CompletedReads
+
+		d, ok := perDay[row.Day]
+		if !ok {
+			d = &DailyMetrics{Day: row.Day}
+			perDay[row.Day] = d
+		}
+		d.Views += row.Views
+		d.UniqueVisitors += row.UniqueVisitors
+	}
+
+	metrics := make(map[uint]*PostMetrics, len(perPost))
+	for id, s := range perPost {
+		m := &PostMetrics{PostID: id, Views: s.Views, UniqueVisitors: s.UniqueVisitors}
+		if s.UniqueVisitors > 0 {
+			n := float64(s.UniqueVisitors)
+			m.AvgScroll = round1(float64(s.ScrollTotal) / n)
+			m.AvgReadSeconds = round1(float64(s.ReadSeconds) / n)
+			m.CompletionRate = round1(100 * float64(s.CompletedReads) / n)
+		}
+		metrics[id] = m
+	}
+
+	daily := make([]DailyMetrics, 0, len(perDay))
+	for day := from; day <= Day(time.Now()); {
+		if d, ok := perDay[day]; ok {
+			daily = append(daily, *d)
+		} else {
+			daily = append(daily, DailyMetrics{Day: day})
+		}
+		t, _ := time.Parse("2006-01-02", day)
+		day = Day(t.AddDate(0, 0, 1))
+	}
+	return metrics, daily, nil
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func round1(f float64) float64 {
+	return float64(int(f*10+0.5)) / 10
+}
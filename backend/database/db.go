@@ -12,12 +12,19 @@ import (
 
 var DB *gorm.DB
 
+// SearchDocuments is the tsvector each searchable table is indexed on. Queries
+// must repeat the exact expression for Postgres to use the index.
+var SearchDocuments = map[string]string{
+	"blog_posts": `to_tsvector('simple', coalesce(title, '') || ' ' || coalesce(excerpt, '') || ' ' || coalesce(content, '') || ' ' || coalesce(pdf_text, ''))`,
+	"resources":  `to_tsvector('simple', coalesce(title, '') || ' ' || coalesce(description, '') || ' ' || coalesce(pdf_text, ''))`,
+}
+
 func Init(dsn string) {
 	var err error
 	var counts int64
 
 	for counts < 5 {
-		DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 		if err == nil {
 			break
 		}
@@ -45,9 +52,80 @@ func Init(dsn string) {
 		&models.Badge{},
 		&models.Comment{},
 		&models.AuditLog{},
+		&models.PostVisit{},
+		&models.PostDailyStat{},
+		&models.AnalyticsSalt{},
+		&models.CuratedSlot{},
+		&models.EditLock{},
+		&models.Redirect{},
+		&models.PreviewToken{},
+		&models.SpamModel{},
+		&models.CommentUnsubscribe{},
+		&models.UsedFormToken{},
+		&models.OutgoingEmail{},
+		&models.ModerationRule{},
+		&models.SubscriberEvent{},
+		&models.Campaign{},
+		&models.CampaignRecipient{},
+		&models.EmailTemplate{},
+		&models.EmailTemplateVersion{},
+		&models.ImportRun{},
 	)
 	if err != nil {
 		log.Fatalf("failed to migrate database: %v", err)
 	}
+
+	// Comments once belonged to blog posts only, through post_id
+	if DB.Migrator().HasColumn(&models.Comment{}, "post_id") {
+		err := DB.Exec(`UPDATE comments SET target_type = 'blog', target_id = post_id WHERE post_id IS NOT NULL AND (target_id IS NULL OR target_id = 0)`).Error
+		if err == nil {
+			err = DB.Migrator().DropColumn(&models.Comment{}, "post_id")
+		}
+		if err != nil {
+			log.Fatalf("failed to migrate comment targets: %v", err)
+		}
+	}
+
+	// Subscribers from before double opt-in were active unless switched off
+	DB.Exec(`UPDATE subscribers SET status = 'unsubscribed' WHERE is_active = false AND status = 'active'`)
+	// and had confirmed by signing up, so they are never purged as unconfirmed.
+	// Nor are those who have been past pending and were put back by signing up again.
+	DB.Exec(`UPDATE subscribers SET confirmed_at = created_at WHERE confirmed_at IS NULL AND (status <> 'pending' OR EXISTS (
+		SELECT 1 FROM subscriber_events e WHERE e.subscriber_id = subscribers.id AND e.from_status IN ('active', 'paused', 'unsubscribed')))`)
+
+	// Jobs and resources were created before they had slugs; give existing rows one
+	// so they can be linked from the sitemap.
+	for _, table := range []string{"jobs", "resources"} {
+		DB.Exec(`UPDATE ` + table + ` SET slug = trim(both '-' from lower(regexp_replace(title, '[^a-zA-Z0-9]+', '-', 'g'))) || '-' || id WHERE slug IS NULL OR slug = ''`)
+	}
+
+	// Full text indexes for the search parameter on the blog and resource lists
+	for table, document := range SearchDocuments {
+		DB.Exec(`CREATE INDEX IF NOT EXISTS idx_` + table + `_search ON ` + table + ` USING GIN ((` + document + `))`)
+	}
+	// Comment counts were added after comments; fill them in for every post
+	if err := RefreshCommentStats(); err != nil {
+		log.Printf("failed to count comments: %v", err)
+	}
 	log.Println("Database migration completed successfully.")
 }
+
+// RefreshCommentStats recounts the approved comments and last comment time of
+// the given posts, or of every post when none are given, in one statement.
+// Call it whenever comments are created, deleted or change status.
+func RefreshCommentStats(postIDs ...uint) error {
+	query := `UPDATE blog_posts SET
+		comment_count = COALESCE(stats.approved, 0),
+		last_comment_at = stats.last_at
+	FROM blog_posts p LEFT JOIN (
+		SELECT target_id, count(*) AS approved, max(created_at) AS last_at
+		FROM comments
+		WHERE target_type = 'blog' AND status = 'approved' AND deleted_at IS NULL
+		GROUP BY target_id
+	) stats ON stats.target_id = p.id
+	WHERE blog_posts.id = p.id`
+	if len(postIDs) == 0 {
+		return DB.Exec(query).Error
+	}
+	return DB.Exec(query+` AND p.id IN ?`, postIDs).Error
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"yiaga-backend/analytics"
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+)
+
+// RecordBeacon accepts navigator.sendBeacon pings from post pages:
+//
+//	{"post_id": 12, "event": "view"}
+//	{"slug": "my-post", "event": "engagement", "scroll": 65, "seconds": 15}
+//
+// sendBeacon posts as text/plain, so the body is decoded regardless of
+// Content-Type. Pings for unknown or unpublished posts are dropped, and
+// failures to record are logged rather than reported to the client.
+func RecordBeacon(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PostID  uint   `json:"post_id"`
+		Slug    string `json:"slug"`
+		Event   string `json:"event"`
+		Scroll  int    `json:"scroll"`
+		Seconds int    `json:"seconds"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4<<10)).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Link unfurlers and search engines are not readers
+	if IsCrawler(r.UserAgent()) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Only published posts are counted, whichever way they are named
+	query := database.DB.Scopes(publishedPosts).Select("id")
+	switch {
+	case input.PostID != 0:
+		query = query.Where("id = ?", input.PostID)
+	case input.Slug != "":
+		query = query.Where("slug = ?", input.Slug)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var post models.BlogPost
+	if err := query.First(&post).Error; err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ip := clientIP(r)
+	var err error
+	switch input.Event {
+	case "view":
+		err = analytics.RecordView(post.ID, ip, r.UserAgent())
+	case "engagement":
+		err = analytics.RecordEngagement(post.ID, ip, r.UserAgent(), input.Scroll, input.Seconds)
+	default:
+		http.Error(w, "event must be view or engagement", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("beacon %s for post %d: %v", input.Event, post.ID, err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetContentAnalytics reports top posts and reading behaviour:
+// GET /dashboard/content?days=30&limit=10
+func GetContentAnalytics(w http.ResponseWriter, r *http.Request) {
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days <= 0 || days > 365 {
+		days = 30
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+
+	from := analytics.Day(time.Now().AddDate(0, 0, -(days - 1)))
+	metrics, daily, err := analytics.Since(from)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ranked := make([]*analytics.PostMetrics, 0, len(metrics))
+	var totalViews, totalVisitors int
+	for _, m := range metrics {
+		ranked = append(ranked, m)
+		totalViews += m.Views
+		totalVisitors += m.UniqueVisitors
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Views == ranked[j].Views {
+			return ranked[i].PostID < ranked[j].PostID
+		}
+		return ranked[i].Views > ranked[j].Views
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	ids := make([]uint, len(ranked))
+	for i, m := range ranked {
+		ids[i] = m.PostID
+	}
+	var posts []models.BlogPost
+	if len(ids) > 0 {
+		database.DB.Where("id IN ?", ids).Find(&posts)
+	}
+	byID := make(map[uint]models.BlogPost, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+
+	type topPost struct {
+		*analytics.PostMetrics
+		Title                string  `json:"title"`
+		Slug                 string  `json:"slug"`
+		Type                 string  `json:"type"`
+		EstimatedReadSeconds int     `json:"estimated_read_seconds"`
+		ReadRatio            float64 `json:"read_ratio"` // Average read time over estimated read time
+	}
+	top := make([]topPost, 0, len(ranked))
+	for _, m := range ranked {
+		p := byID[m.PostID]
+		t := topPost{PostMetrics: m, Title: p.Title, Slug: p.Slug, Type: p.Type, EstimatedReadSeconds: estimatedReadSeconds(p.Content)}
+		if t.EstimatedReadSeconds > 0 {
+			t.ReadRatio = float64(int(100*m.AvgReadSeconds/float64(t.EstimatedReadSeconds))) / 100
+		}
+		top = append(top, t)
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"from":            from,
+		"days":            days,
+		"total_views":     totalViews,
+		"unique_visitors": totalVisitors,
+		"top_posts":       top,
+		"daily":           daily,
+	})
+}
+
+// estimatedReadSeconds assumes 200 words a minute
+func estimatedReadSeconds(content string) int {
+	words := len(strings.Fields(plainText(content, len(content))))
+	return words * 60 / 200
+}
+
+// clientIP is the address of the reader. X-Forwarded-For is only believed
+// when the request came through a proxy listed in TRUSTED_PROXIES (comma
+// separated IPs or CIDRs), and then only the hops those proxies appended: the
+// rightmost entry that is not itself a trusted proxy. Anything further left
+// was sent by the client and could be anything.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	trusted := trustedProxies()
+	if !trusted.contains(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		if !trusted.contains(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+type proxyList []*net.IPNet
+
+func (l proxyList) contains(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var trustedProxyCache struct {
+	sync.Mutex
+	raw  string
+	list proxyList
+}
+
+// trustedProxies parses TRUSTED_PROXIES, reusing the result while it is unchanged
+func trustedProxies() proxyList {
+	raw := os.Getenv("TRUSTED_PROXIES")
+	trustedProxyCache.Lock()
+	defer trustedProxyCache.Unlock()
+	if raw == trustedProxyCache.raw {
+		return trustedProxyCache.list
+	}
+
+	var list proxyList
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("TRUSTED_PROXIES: ignoring %q: %v", entry, err)
+			continue
+		}
+		list = append(list, n)
+	}
+	trustedProxyCache.raw, trustedProxyCache.list = raw, list
+	return list
+}
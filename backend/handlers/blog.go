@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -11,13 +10,18 @@ import (
 
 	"yiaga-backend/database"
 	"yiaga-backend/models"
+	"yiaga-backend/pdftext"
+	"yiaga-backend/sanitize"
 )
 
 // Public Helpers
 func GetBlogs(w http.ResponseWriter, r *http.Request) {
 	var posts []models.BlogPost
 	// Filter by type if provided (blog vs news) or any other filters
-	query := database.DB.Model(&models.BlogPost{}).Order("published_at desc")
+	query := database.DB.Model(&models.BlogPost{})
+	if !isEditor(r) {
+		query = query.Scopes(publishedPosts)
+	}
 
 	typeParam := r.URL.Query().Get("type")
 	if typeParam != "" {
@@ -29,11 +33,19 @@ func GetBlogs(w http.ResponseWriter, r *http.Request) {
 		query = query.Where("category = ?", category)
 	}
 
-	result := query.Find(&posts)
+	// Full text search over the post and its attached PDF
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		query = query.Scopes(searchScope("blog_posts", q))
+	}
+
+	result := query.Order("published_at desc").Find(&posts)
 	if result.Error != nil {
 		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
 		return
 	}
+	for i := range posts {
+		localize(w, r, &posts[i])
+	}
 	respondJSON(w, posts)
 }
 
@@ -45,6 +57,14 @@ func GetBlogBySlug(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
+	// Drafts and scheduled posts are visible to editors and preview links only
+	published := post.Status == statusPublished && !post.PublishedAt.After(time.Now())
+	if !published && !isEditor(r) && !previewAllowed(w, r, "blog", post.ID) {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	localize(w, r, &post)
+	w.Header().Set("ETag", localizedTag(w, post.Version))
 	respondJSON(w, post)
 }
 
@@ -55,15 +75,25 @@ func CreateBlogPost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	// Generate slug if empty
-	if post.Slug == "" {
-		post.Slug = strings.ToLower(strings.ReplaceAll(post.Title, " ", "-")) + "-" + fmt.Sprintf("%d", time.Now().Unix())
+	if !validPublishStatus(post.Status) {
+		http.Error(w, "status must be draft or published", http.StatusBadRequest)
+		return
 	}
+	post.Content = sanitize.RichText(post.Content)
+	sanitizeTranslatedContent(post.Translations)
 
-	if err := database.DB.Create(&post).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	post.PdfText, post.PdfPages = "", 0
+	if post.PublishedAt.IsZero() {
+		post.PublishedAt = time.Now()
+	}
+
+	// Generate slug if empty, otherwise check the editor's choice
+	if err := createWithSlug(&models.BlogPost{}, &post, &post.Slug, post.Title); err != nil {
+		respondSlugError(w, err)
 		return
 	}
+	queuePDF("post", post.ID, post.PdfUrl)
+	invalidateRelated()
 	respondJSON(w, post)
 }
 
@@ -74,6 +104,14 @@ func UpdateBlogPost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
+	expected, ok := ifMatchVersion(w, r, post.Version)
+	if !ok {
+		return
+	}
+	if expected != post.Version {
+		respondStale(w, post, post.Version)
+		return
+	}
 
 	var input models.BlogPost
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -81,18 +119,60 @@ func UpdateBlogPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if input.Slug != "" && input.Slug != post.Slug {
+		slug, err := assignSlug(&models.BlogPost{}, input.Slug, input.Title, post.ID)
+		if err != nil {
+			respondSlugError(w, err)
+			return
+		}
+		post.Slug = slug
+	}
+
 	// Update fields
 	post.Title = input.Title
-	post.Content = input.Content
+	post.Content = sanitize.RichText(input.Content)
 	post.Excerpt = input.Excerpt
 	post.Image = input.Image
 	post.Category = input.Category
 	post.Author = input.Author
+	post.IsFeatured = input.IsFeatured
+	if input.Status != "" {
+		if !validPublishStatus(input.Status) {
+			http.Error(w, "status must be draft or published", http.StatusBadRequest)
+			return
+		}
+		post.Status = input.Status
+	}
+	pdfChanged := input.PdfUrl != post.PdfUrl
+	if pdfChanged {
+		// Extracted again once the post is saved
+		post.PdfUrl = input.PdfUrl
+		post.PdfText, post.PdfPages = "", 0
+	} else if strings.TrimSpace(post.Excerpt) == "" && post.PdfText != "" {
+		post.Excerpt = pdftext.Excerpt(post.PdfText, excerptLength)
+	}
+	if input.Translations != nil {
+		sanitizeTranslatedContent(input.Translations)
+		post.Translations = input.Translations
+	}
 
-	if err := database.DB.Save(&post).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	saved, err := saveVersioned(&post, &post.Version, expected)
+	if err != nil {
+		respondSlugError(w, slugConflict(err))
 		return
 	}
+	if !saved {
+		// Lost a race with another save since the post was loaded
+		var current models.BlogPost
+		database.DB.First(&current, post.ID)
+		respondStale(w, current, current.Version)
+		return
+	}
+	if pdfChanged {
+		queuePDF("post", post.ID, post.PdfUrl)
+	}
+	invalidateRelated()
+	w.Header().Set("ETag", versionTag(post.Version))
 	respondJSON(w, post)
 }
 
@@ -102,5 +182,6 @@ func DeleteBlogPost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	invalidateRelated()
 	respondJSON(w, map[string]string{"message": "Deleted successfully"})
 }
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"yiaga-backend/database"
+	"yiaga-backend/mail"
+	"yiaga-backend/models"
+	"yiaga-backend/newsletter"
+)
+
+// Newsletter campaigns are written and scheduled here and delivered by
+// newsletter.RunCampaigns. Bodies are templates executed with
+// newsletter.Message, e.g. {{.PreferencesURL}}.
+
+type campaignView struct {
+	models.Campaign
+	Recipients map[string]int64 `json:"recipients"` // Count by recipient status
+}
+
+func campaignViews(campaigns []models.Campaign) ([]campaignView, error) {
+	ids := make([]uint, len(campaigns))
+	for i, c := range campaigns {
+		ids[i] = c.ID
+	}
+	stats, err := newsletter.Stats(ids...)
+	views := make([]campaignView, len(campaigns))
+	for i, c := range campaigns {
+		views[i] = campaignView{Campaign: c, Recipients: stats[c.ID]}
+	}
+	return views, err
+}
+
+// findCampaign loads the campaign named in the URL, answering 404 if missing
+func findCampaign(w http.ResponseWriter, r *http.Request) (models.Campaign, bool) {
+	var c models.Campaign
+	if err := database.DB.First(&c, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Campaign not found", http.StatusNotFound)
+		return c, false
+	}
+	return c, true
+}
+
+// GetCampaigns lists campaigns, newest first; filter with ?status=
+func GetCampaigns(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Order("created_at desc")
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var campaigns []models.Campaign
+	if err := query.Find(&campaigns).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	views, err := campaignViews(campaigns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, views)
+}
+
+func GetCampaign(w http.ResponseWriter, r *http.Request) {
+	c, ok := findCampaign(w, r)
+	if !ok {
+		return
+	}
+	views, err := campaignViews([]models.Campaign{c})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, views[0])
+}
+
+// campaignInput is what admins edit; status and timings change through the
+// schedule and cancel endpoints only
+type campaignInput struct {
+	Name    string   `json:"name"`
+	Subject string   `json:"subject"`
+	HTML    string   `json:"html"`
+	Text    string   `json:"text"`
+	Topics  []string `json:"topics"`
+}
+
+func (in campaignInput) applyTo(c *models.Campaign) {
+	c.Name, c.Subject, c.HTML, c.Text, c.Topics = in.Name, in.Subject, in.HTML, in.Text, in.Topics
+}
+
+func CreateCampaign(w http.ResponseWriter, r *http.Request) {
+	var input campaignInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c := models.Campaign{Status: newsletter.CampaignDraft}
+	input.applyTo(&c)
+	if err := newsletter.ParseCampaign(&c); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.CreatedBy, _ = currentEditor(r)
+	if err := database.DB.Create(&c).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, c)
+}
+
+// UpdateCampaign edits a draft or a campaign that has not started sending
+func UpdateCampaign(w http.ResponseWriter, r *http.Request) {
+	c, ok := findCampaign(w, r)
+	if !ok {
+		return
+	}
+	if c.Status != newsletter.CampaignDraft && c.Status != newsletter.CampaignScheduled {
+		http.Error(w, "Only draft or scheduled campaigns can be edited", http.StatusConflict)
+		return
+	}
+	var input campaignInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	input.applyTo(&c)
+	if err := newsletter.ParseCampaign(&c); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The worker may have started it since it was loaded
+	result := database.DB.Model(&c).Where("status IN ?", []string{newsletter.CampaignDraft, newsletter.CampaignScheduled}).
+		Select("name", "subject", "html", "text", "topics").Updates(&c)
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "The campaign has started sending", http.StatusConflict)
+		return
+	}
+	respondJSON(w, c)
+}
+
+// DeleteCampaign removes a campaign that is not being sent
+func DeleteCampaign(w http.ResponseWriter, r *http.Request) {
+	result := database.DB.Where("status <> ?", newsletter.CampaignSending).
+		Delete(&models.Campaign{}, chi.URLParam(r, "id"))
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Campaign not found or still sending", http.StatusConflict)
+		return
+	}
+	respondJSON(w, map[string]string{"message": "Deleted"})
+}
+
+// maxTestRecipients caps the addresses of one test send
+const maxTestRecipients = 10
+
+// TestCampaign sends a campaign straight away to a few addresses, marked as a
+// test: {"emails": ["editor@yiaga.org"]}. Links in it carry no token.
+func TestCampaign(w http.ResponseWriter, r *http.Request) {
+	c, ok := findCampaign(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Emails []string `json:"emails"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(input.Emails) == 0 || len(input.Emails) > maxTestRecipients {
+		http.Error(w, "Give between 1 and "+strconv.Itoa(maxTestRecipients)+" emails", http.StatusBadRequest)
+		return
+	}
+	if !mail.Configured() {
+		http.Error(w, mail.ErrNotConfigured.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	c.PreferencesPage, c.UnsubscribeURL = preferencesPage(), unsubscribeEndpoint()
+	c.Subject = "[Test] " + c.Subject
+	results := map[string]string{}
+	for _, email := range input.Emails {
+		email = strings.TrimSpace(email)
+		if !validEmail(email) {
+			results[email] = "invalid address"
+			continue
+		}
+		m, err := newsletter.Render(c, models.Subscriber{Email: email})
+		if err == nil {
+			err = mail.Send(m)
+		}
+		if err != nil {
+			results[email] = err.Error()
+			continue
+		}
+		results[email] = "sent"
+	}
+	respondJSON(w, map[string]interface{}{"results": results})
+}
+
+// ScheduleCampaign queues a draft for delivery: {"send_at": "2026-11-02T09:00:00+01:00"},
+// or now when send_at is left out. A scheduled campaign can be moved.
+func ScheduleCampaign(w http.ResponseWriter, r *http.Request) {
+	c, ok := findCampaign(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		SendAt *time.Time `json:"send_at"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	at := time.Now()
+	if input.SendAt != nil && input.SendAt.After(at) {
+		at = *input.SendAt
+	}
+
+	c.Status, c.ScheduledAt = newsletter.CampaignScheduled, &at
+	c.PreferencesPage, c.UnsubscribeURL = preferencesPage(), unsubscribeEndpoint()
+	result := database.DB.Model(&c).Where("status IN ?", []string{newsletter.CampaignDraft, newsletter.CampaignScheduled}).
+		Select("status", "scheduled_at", "preferences_page", "unsubscribe_url").Updates(&c)
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Only draft or scheduled campaigns can be scheduled", http.StatusConflict)
+		return
+	}
+	respondJSON(w, c)
+}
+
+// CancelCampaign stops a scheduled or sending campaign
+func CancelCampaign(w http.ResponseWriter, r *http.Request) {
+	c, ok := findCampaign(w, r)
+	if !ok {
+		return
+	}
+	if err := newsletter.Cancel(c.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	respondJSON(w, map[string]string{"message": "Cancelled"})
+}
+
+// RetryCampaign queues a campaign's failed recipients again
+func RetryCampaign(w http.ResponseWriter, r *http.Request) {
+	c, ok := findCampaign(w, r)
+	if !ok {
+		return
+	}
+	n, err := newsletter.RetryFailed(c.ID)
+	if errors.Is(err, newsletter.ErrNotRetryable) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, map[string]interface{}{"queued": n})
+}
+
+// GetCampaignRecipients lists delivery to each recipient, 500 at a time:
+// ?status=queued|sending|sent|failed|skipped&offset=
+func GetCampaignRecipients(w http.ResponseWriter, r *http.Request) {
+	c, ok := findCampaign(w, r)
+	if !ok {
+		return
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	query := database.DB.Where("campaign_id = ?", c.ID).Order("id").Offset(offset).Limit(500)
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var recipients []models.CampaignRecipient
+	if err := query.Find(&recipients).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, recipients)
+}
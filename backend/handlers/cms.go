@@ -7,12 +7,15 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"yiaga-backend/analytics"
 	"yiaga-backend/database"
 	"yiaga-backend/models"
+	"yiaga-backend/newsletter"
 )
 
 // --- Dashboard ---
@@ -25,35 +28,38 @@ func GetDashboardStats(w http.ResponseWriter, r *http.Request) {
 	database.DB.Model(&models.BlogPost{}).Where("type = ?", "news").Count(&newsCount)
 	database.DB.Model(&models.Subscriber{}).Where("is_active = ?", true).Count(&subscriberCount)
 
+	// Post views over the last week; see GetContentAnalytics for the breakdown
+	var weeklyViews int
+	if metrics, _, err := analytics.Since(analytics.Day(time.Now().AddDate(0, 0, -6))); err == nil {
+		for _, m := range metrics {
+			weeklyViews += m.Views
+		}
+	}
+
 	respondJSON(w, map[string]interface{}{
-		"users":       userCount,
-		"blogs":       blogCount,
-		"news":        newsCount,
-		"subscribers": subscriberCount,
+		"users":        userCount,
+		"blogs":        blogCount,
+		"news":         newsCount,
+		"subscribers":  subscriberCount,
+		"views_7_days": weeklyViews,
 	})
 }
 
 // --- Analytics ---
 
 func GetSubscriberAnalytics(w http.ResponseWriter, r *http.Request) {
-	var activeCount, inactiveCount int64
+	var activeCount, inactiveCount, pendingCount int64
 	database.DB.Model(&models.Subscriber{}).Where("is_active = ?", true).Count(&activeCount)
-	database.DB.Model(&models.Subscriber{}).Where("is_active = ?", false).Count(&inactiveCount)
+	database.DB.Model(&models.Subscriber{}).Where("status = ?", newsletter.StatusUnsubscribed).Count(&inactiveCount)
+	database.DB.Model(&models.Subscriber{}).Where("status = ?", newsletter.StatusPending).Count(&pendingCount)
 
-	// Weekly Subscription Report (New subscribers in last 7 days)
+	// Weekly Subscription Report (New confirmed subscribers in last 7 days)
 	var newThisWeek int64
 	oneWeekAgo := time.Now().AddDate(0, 0, -7)
-	database.DB.Model(&models.Subscriber{}).Where("created_at >= ?", oneWeekAgo).Count(&newThisWeek)
+	database.DB.Model(&models.Subscriber{}).Where("is_active = ? AND subscribed_at >= ?", true, oneWeekAgo).Count(&newThisWeek)
 
 	// Specific Topics Breakdown
-	targetTopics := []string{
-		"Monthly Newsletter",
-		"Weekly Election News Update (The Ballot)",
-		"GenZ Blog Series",
-		"Research, Reports, Policy Briefs & Knowledge Products",
-		"Press Releases, Stories & Democracy Updates",
-		"Opportunities: Events Webinars & Open Calls",
-	}
+	targetTopics := newsletter.Topics
 
 	var subscribers []models.Subscriber
 	database.DB.Find(&subscribers)
@@ -77,11 +83,24 @@ func GetSubscriberAnalytics(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Churn over ?days= (default 30), from the subscription change log
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days <= 0 || days > 365 {
+		days = 30
+	}
+	churn, err := newsletter.Churn(days)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	respondJSON(w, map[string]interface{}{
 		"total_active":       activeCount,
 		"total_unsubscribed": inactiveCount,
+		"total_pending":      pendingCount,
 		"new_this_week":      newThisWeek,
 		"topic_breakdown":    topicsMap,
+		"churn":              churn,
 	})
 }
 
@@ -116,7 +135,8 @@ func HandleFileUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondJSON(w, map[string]string{
+	// A PDF is indexed once it is linked from a post or resource and saved
+	respondJSON(w, map[string]interface{}{
 		"url":      fmt.Sprintf("/src/assets/%s", filename),
 		"filename": filename,
 	})
@@ -132,6 +152,7 @@ func GetHeroContent(w http.ResponseWriter, r *http.Request) {
 		respondJSON(w, models.HeroContent{Page: page})
 		return
 	}
+	localize(w, r, &content)
 	respondJSON(w, content)
 }
 
@@ -146,6 +167,10 @@ func UpdateHeroContent(w http.ResponseWriter, r *http.Request) {
 	var existing models.HeroContent
 	if err := database.DB.Where("page = ?", content.Page).First(&existing).Error; err == nil {
 		content.ID = existing.ID
+		content.CreatedAt = existing.CreatedAt
+		if content.Translations == nil {
+			content.Translations = existing.Translations
+		}
 	}
 
 	if err := database.DB.Save(&content).Error; err != nil {
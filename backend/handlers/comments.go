@@ -2,26 +2,44 @@ package handlers
 
 import (
 	"encoding/json"
+	"io"
+	"log"
 	"net/http"
+	"net/mail"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 
 	"yiaga-backend/database"
+	"yiaga-backend/i18n"
 	"yiaga-backend/middleware"
 	"yiaga-backend/models"
+	"yiaga-backend/sanitize"
 )
 
 func GetComments(w http.ResponseWriter, r *http.Request) {
 	var comments []models.Comment
-	query := database.DB.Model(&models.Comment{}).Order("created_at desc")
+	query := database.DB.Model(&models.Comment{})
 
-	postID := r.URL.Query().Get("post_id")
-	if postID != "" {
-		// Public fetch for a post - only RETURN APPROVED comments
-		query = query.Where("post_id = ? AND status = ?", postID, "approved")
+	targetType, targetID, public, err := targetFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if public {
+		// Public fetch for a post, initiative or resource
+		// (?target_type=&target_id=, or ?post_id=) - the approved comments as
+		// a threaded tree
+		thread, err := commentThread(targetType, targetID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, thread)
+		return
 	} else {
 		// Admin listing (all comments) - REQUIRE AUTH
 		authHeader := r.Header.Get("Authorization")
@@ -42,41 +60,165 @@ func GetComments(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Proceed with filters if any
-		status := r.URL.Query().Get("status")
-		if status != "" {
-			query = query.Where("status = ?", status)
+		// Proceed with filters if any: ?status=&post=&target_type=&target_id=&from=&to=&email=&q=, as
+		// accepted by BulkModerateComments
+		filter := commentFilterFromQuery(r)
+		query, err = filter.apply(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Work the moderation queue from the least likely spam; unscored
+		// comments come after the scored ones
+		if filter.Status == "pending" {
+			query = query.Order("spam_score asc nulls last")
 		}
 	}
+	query = query.Order("created_at desc")
 
 	query.Find(&comments)
 	respondJSON(w, comments)
 }
 
+// CreateComment takes a reader's comment for moderation. See spam.go for the
+// defences a submission has to get through.
 func CreateComment(w http.ResponseWriter, r *http.Request) {
-	var c models.Comment
-	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+	var input struct {
+		Content    string `json:"content"`
+		Author     string `json:"author"`
+		Email      string `json:"email"`
+		TargetType string `json:"target_type"` // blog (default), initiative or resource
+		TargetID   uint   `json:"target_id"`
+		PostID     uint   `json:"post_id"`   // Older form of a blog target
+		ParentID   uint   `json:"parent_id"` // Optional, to reply to an approved comment
+		Website    string `json:"website"`   // Honeypot, hidden from people
+		FormToken  string `json:"form_token"`
+		PowNonce   string `json:"pow_nonce"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&input); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	// Initial state
-	c.Status = "pending"
-	c.Date = time.Now().Format("Jan 2, 2006")
 
-	// Fetch post title if available (optional optimization)
-	var post models.BlogPost
-	if c.PostID != 0 {
-		database.DB.First(&post, c.PostID)
-		c.PostTitle = post.Title
+	if input.TargetID == 0 && input.PostID != 0 {
+		input.TargetType, input.TargetID = targetBlog, input.PostID
+	}
+	if input.TargetType == "" {
+		input.TargetType = targetBlog
+	}
+
+	c := models.Comment{
+		Content:    sanitize.Comment(input.Content),
+		Author:     strings.TrimSpace(input.Author),
+		Email:      strings.ToLower(strings.TrimSpace(input.Email)),
+		TargetType: input.TargetType,
+		TargetID:   input.TargetID,
+		Status:     "pending",
+		Date:       time.Now().Format("Jan 2, 2006"),
+		Locale:     i18n.Negotiate(r)[0],
+	}
+
+	// Bots get the same answer as people, so they learn nothing
+	if input.Website != "" {
+		respondJSON(w, c)
+		return
+	}
+
+	fields := map[string]string{}
+	switch {
+	case c.Author == "":
+		fields["author"] = "Please enter your name"
+	case utf8.RuneCountInString(c.Author) > 100:
+		fields["author"] = "Name must be 100 characters or fewer"
+	}
+	if !validEmail(c.Email) {
+		fields["email"] = "Please enter a valid email address"
+	}
+	switch {
+	case c.Content == "":
+		fields["content"] = "Comment cannot be empty"
+	case utf8.RuneCountInString(c.Content) > 5000:
+		fields["content"] = "Comment must be 5000 characters or fewer"
+	}
+	var target commentTarget
+	if c.TargetID == 0 {
+		fields["target_id"] = "target_id is required"
+	} else if found, err := findCommentTarget(c.TargetType, c.TargetID, true); err != nil {
+		fields["target_id"] = "Nothing to comment on was found"
+	} else {
+		target = found
+	}
+	if input.ParentID != 0 && fields["target_id"] == "" {
+		if parent, msg := replyParent(c.TargetType, c.TargetID, input.ParentID); parent == nil {
+			fields["parent_id"] = msg
+		} else {
+			c.ParentID = &parent.ID
+			c.Depth = parent.Depth + 1
+		}
+	}
+	if len(fields) > 0 {
+		respondFieldErrors(w, fields)
+		return
+	}
+	c.PostTitle = target.Title
+
+	c.IPHash = commenterIPHash(r)
+	limited, err := commentRateLimited(c.IPHash, c.Email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if limited {
+		w.Header().Set("Retry-After", "900")
+		http.Error(w, "You have commented a lot recently, please try again later", http.StatusTooManyRequests)
+		return
+	}
+	// Checked last, as a valid token is used up
+	msg, err := checkFormToken(input.FormToken, input.PowNonce, c.TargetType, c.TargetID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if msg != "" {
+		respondFieldErrors(w, map[string]string{"form_token": msg})
+		return
+	}
+
+	if err := applyCommentRules(&c); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// A rule rejection stands; the classifier only scores what is left
+	if c.Status != "rejected" {
+		scoreComment(&c)
 	}
 
 	if err := database.DB.Create(&c).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// The submitter sees every comment as awaiting moderation
+	c.Status, c.SpamScore, c.SpamReasons, c.AutoRejected = "pending", nil, nil, false
+	c.ModerationMatches = nil
 	respondJSON(w, c)
 }
 
+// validEmail accepts a bare, already lowercased address
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return email != "" && err == nil && addr.Address == email && len(email) <= 254
+}
+
+// respondFieldErrors reports validation failures keyed by JSON field name
+func respondFieldErrors(w http.ResponseWriter, fields map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "Please correct the highlighted fields",
+		"fields": fields,
+	})
+}
+
 func UpdateCommentStatus(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	var status struct {
@@ -87,15 +229,50 @@ func UpdateCommentStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := database.DB.Model(&models.Comment{}).Where("id = ?", id).Update("status", status.Status).Error; err != nil {
+	var c models.Comment
+	if err := database.DB.First(&c, id).Error; err != nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	wasApproved := c.Status == "approved"
+
+	// A moderator's decision replaces the classifier's, and becomes training data
+	update := map[string]interface{}{"status": status.Status, "auto_rejected": false}
+	if err := database.DB.Model(&c).Updates(update).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	refreshCommentStats(c)
+	if status.Status == "approved" && !wasApproved {
+		notifyApproved(r, c)
+	}
 	respondJSON(w, map[string]string{"message": "Status updated"})
 }
 
 func DeleteComment(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	database.DB.Delete(&models.Comment{}, id)
+	var c models.Comment
+	if err := database.DB.Select("id", "target_type", "target_id").First(&c, id).Error; err == nil {
+		database.DB.Delete(&c)
+		refreshCommentStats(c)
+	}
 	respondJSON(w, map[string]string{"message": "Deleted"})
 }
+
+// refreshCommentStats updates the comment counts shown on the posts the
+// comments belong to. The change to the comments has already been made, so a
+// failure is only logged.
+func refreshCommentStats(comments ...models.Comment) {
+	var postIDs []uint
+	for _, c := range comments {
+		if c.TargetType == targetBlog {
+			postIDs = append(postIDs, c.TargetID)
+		}
+	}
+	if len(postIDs) == 0 {
+		return
+	}
+	if err := database.RefreshCommentStats(postIDs...); err != nil {
+		log.Printf("failed to count comments of posts %v: %v", postIDs, err)
+	}
+}
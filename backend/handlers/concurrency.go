@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm/clause"
+
+	"yiaga-backend/database"
+	"yiaga-backend/i18n"
+	"yiaga-backend/middleware"
+	"yiaga-backend/models"
+)
+
+// lockableModels maps the {type} URL values accepted by the edit lock routes
+var lockableModels = map[string]func() interface{}{
+	"blog":       func() interface{} { return &models.BlogPost{} },
+	"initiative": func() interface{} { return &models.Initiative{} },
+}
+
+// versionTag is the ETag for a content version as the CMS reads and writes
+// it, in the default language
+func versionTag(version int) string {
+	return fmt.Sprintf(`"v%d"`, version)
+}
+
+// localizedTag is the ETag for a content version rendered by localize, which
+// differs by language, e.g. "v4-fr". Writes only compare the version.
+func localizedTag(w http.ResponseWriter, version int) string {
+	if lang := w.Header().Get("Content-Language"); lang != "" && lang != i18n.Default {
+		return fmt.Sprintf(`"v%d-%s"`, version, lang)
+	}
+	return versionTag(version)
+}
+
+// ifMatchVersion reads the version an editor last saw from If-Match. A
+// missing header is answered with 428 so clients cannot skip the check by
+// accident; "*" deliberately overwrites whatever is current.
+func ifMatchVersion(w http.ResponseWriter, r *http.Request, current int) (int, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		http.Error(w, "If-Match header with the version being edited is required", http.StatusPreconditionRequired)
+		return 0, false
+	}
+	if header == "*" {
+		return current, true
+	}
+	tag, _, _ := strings.Cut(strings.Trim(header, `"`), "-")
+	version, err := strconv.Atoi(strings.TrimPrefix(tag, "v"))
+	if err != nil {
+		http.Error(w, "If-Match must be an ETag returned by this API", http.StatusBadRequest)
+		return 0, false
+	}
+	return version, true
+}
+
+// saveVersioned writes item only if its row is still at version expected, and
+// bumps version. It reports false when another save got there first.
+func saveVersioned(item interface{}, version *int, expected int) (bool, error) {
+	*version = expected + 1
+	result := database.DB.Model(item).Where("version = ?", expected).
+		Select("*").Omit("created_at").Updates(item)
+	if result.Error != nil || result.RowsAffected == 0 {
+		*version = expected
+		return false, result.Error
+	}
+	return true, nil
+}
+
+// respondStale answers a write made against an old version with 412 and the
+// record as it now stands, so the editor can merge their changes into it
+func respondStale(w http.ResponseWriter, current interface{}, version int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionTag(version))
+	w.WriteHeader(http.StatusPreconditionFailed)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":           "This record was changed by someone else since you loaded it",
+		"current_version": version,
+		"current":         current,
+	})
+}
+
+// --- Soft edit locks ---
+
+// editLockTTL is how long a lock lives without a refresh from the editor
+func editLockTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("EDIT_LOCK_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 2 * time.Minute
+}
+
+// lockTarget validates the {type}/{id} of a lock route
+func lockTarget(w http.ResponseWriter, r *http.Request) (string, uint, bool) {
+	contentType := chi.URLParam(r, "type")
+	newModel, ok := lockableModels[contentType]
+	if !ok {
+		http.Error(w, "type must be blog or initiative", http.StatusBadRequest)
+		return "", 0, false
+	}
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return "", 0, false
+	}
+	if err := database.DB.Select("id").First(newModel(), id).Error; err != nil {
+		http.Error(w, "Content not found", http.StatusNotFound)
+		return "", 0, false
+	}
+	return contentType, uint(id), true
+}
+
+// currentEditor identifies the signed in user for lock ownership
+func currentEditor(r *http.Request) (uint, string) {
+	claims := middleware.ClaimsFromContext(r.Context())
+	if claims == nil {
+		return 0, ""
+	}
+	id, _ := strconv.ParseUint(claims.UserID, 10, 64)
+	var user models.User
+	database.DB.Select("id", "username").First(&user, id)
+	return uint(id), user.Username
+}
+
+// activeLock returns the unexpired lock on a record, if any
+func activeLock(contentType string, id uint) *models.EditLock {
+	var lock models.EditLock
+	err := database.DB.Where("content_type = ? AND content_id = ? AND expires_at > ?", contentType, id, time.Now()).
+		First(&lock).Error
+	if err != nil {
+		return nil
+	}
+	return &lock
+}
+
+// GetEditLock reports who, if anyone, is editing a record
+func GetEditLock(w http.ResponseWriter, r *http.Request) {
+	contentType, id, ok := lockTarget(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, map[string]interface{}{"lock": activeLock(contentType, id)})
+}
+
+// TakeEditLock claims or refreshes the lock on a record. Editors call it when
+// opening a record and periodically while it stays open. Another editor's
+// live lock is answered with 409 unless ?force=true takes it over.
+func TakeEditLock(w http.ResponseWriter, r *http.Request) {
+	contentType, id, ok := lockTarget(w, r)
+	if !ok {
+		return
+	}
+	userID, username := currentEditor(r)
+
+	if held := activeLock(contentType, id); held != nil && held.UserID != userID && r.URL.Query().Get("force") != "true" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": held.Username + " is editing this right now",
+			"lock":  held,
+		})
+		return
+	}
+
+	lock := models.EditLock{
+		ContentType: contentType,
+		ContentID:   id,
+		UserID:      userID,
+		Username:    username,
+		ExpiresAt:   time.Now().Add(editLockTTL()),
+	}
+	err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "content_type"}, {Name: "content_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "username", "expires_at", "updated_at", "deleted_at"}),
+	}).Create(&lock).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, map[string]interface{}{"lock": lock})
+}
+
+// ReleaseEditLock drops the caller's lock when they close the editor
+func ReleaseEditLock(w http.ResponseWriter, r *http.Request) {
+	contentType, id, ok := lockTarget(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := currentEditor(r)
+	query := database.DB.Unscoped().Where("content_type = ? AND content_id = ?", contentType, id)
+	if r.URL.Query().Get("force") != "true" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.Delete(&models.EditLock{}).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
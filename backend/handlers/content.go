@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -21,9 +22,28 @@ func GetAnnouncements(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
 		return
 	}
+	for i := range announcements {
+		localize(w, r, &announcements[i])
+	}
 	respondJSON(w, announcements)
 }
 
+// GetAnnouncement serves one announcement; drafts need an editor token or a
+// ?preview= link
+func GetAnnouncement(w http.ResponseWriter, r *http.Request) {
+	var announcement models.Announcement
+	if err := database.DB.First(&announcement, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Announcement not found", http.StatusNotFound)
+		return
+	}
+	if announcement.Status != statusPublished && !isEditor(r) && !previewAllowed(w, r, "announcement", announcement.ID) {
+		http.Error(w, "Announcement not found", http.StatusNotFound)
+		return
+	}
+	localize(w, r, &announcement)
+	respondJSON(w, announcement)
+}
+
 func CreateAnnouncement(w http.ResponseWriter, r *http.Request) {
 	var announcement models.Announcement
 	if err := json.NewDecoder(r.Body).Decode(&announcement); err != nil {
@@ -52,14 +72,19 @@ func DeleteAnnouncement(w http.ResponseWriter, r *http.Request) {
 
 func GetResources(w http.ResponseWriter, r *http.Request) {
 	var resources []models.Resource
-	query := database.DB.Model(&models.Resource{}).Order("published_at desc")
+	query := database.DB.Model(&models.Resource{})
 
 	category := r.URL.Query().Get("category")
 	if category != "" && category != "All" {
 		query = query.Where("category = ?", category)
 	}
 
-	result := query.Find(&resources)
+	// Full text search over the resource and its PDF
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		query = query.Scopes(searchScope("resources", q))
+	}
+
+	result := query.Order("published_at desc").Find(&resources)
 	if result.Error != nil {
 		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
 		return
@@ -74,10 +99,13 @@ func CreateResource(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	res.PublishedAt = time.Now()
-	if err := database.DB.Create(&res).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	res.PdfText, res.PdfPages = "", 0
+	if err := createWithSlug(&models.Resource{}, &res, &res.Slug, res.Title); err != nil {
+		respondSlugError(w, err)
 		return
 	}
+	queuePDF("resource", res.ID, res.FileUrl)
+	invalidateRelated()
 	respondJSON(w, res)
 }
 
@@ -87,6 +115,7 @@ func DeleteResource(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	invalidateRelated()
 	respondJSON(w, map[string]string{"message": "Deleted successfully"})
 }
 
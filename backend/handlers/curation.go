@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+)
+
+var slotKeyPattern = regexp.MustCompile(`^[a-z0-9]+(\.[a-z0-9_-]+)+$`)
+
+// slotFills are the automatic sources a slot can use to top up its pinned items
+var slotFills = map[string]struct {
+	Type  string
+	Scope func(*gorm.DB) *gorm.DB
+}{
+	"latest_posts": {"post", func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(publishedPosts).Order("published_at desc")
+	}},
+	"latest_blog": {"post", func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(publishedPosts).Where("type = ?", "blog").Order("published_at desc")
+	}},
+	"latest_news": {"post", func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(publishedPosts).Where("type = ?", "news").Order("published_at desc")
+	}},
+	"featured_posts": {"post", func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(publishedPosts).Where("is_featured = ?", true).Order("published_at desc")
+	}},
+	"latest_initiatives": {"initiative", func(db *gorm.DB) *gorm.DB { return db.Scopes(publishedInitiatives).Order("created_at desc") }},
+	"latest_resources":   {"resource", func(db *gorm.DB) *gorm.DB { return db.Order("published_at desc") }},
+}
+
+type slotEntry struct {
+	Type      string      `json:"type"`
+	ID        uint        `json:"id"`
+	Pinned    bool        `json:"pinned"` // False for items added by the slot's fill
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+	Item      interface{} `json:"item"`
+}
+
+// GetSlot serves a curated slot with its references resolved, skipping
+// expired or deleted items and topping up from the slot's fill
+func GetSlot(w http.ResponseWriter, r *http.Request) {
+	var slot models.CuratedSlot
+	if err := database.DB.Where("key = ?", chi.URLParam(r, "key")).First(&slot).Error; err != nil {
+		http.Error(w, "Slot not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	var live []models.SlotItem
+	for _, item := range slot.Items {
+		if item.ExpiresAt == nil || item.ExpiresAt.After(now) {
+			live = append(live, item)
+		}
+	}
+
+	entries, err := resolveSlotItems(w, r, live)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range entries {
+		entries[i].Pinned = true
+	}
+
+	if fill, ok := slotFills[slot.Fill]; ok && (slot.MaxItems == 0 || len(entries) < slot.MaxItems) {
+		var exclude []uint
+		for _, e := range entries {
+			if e.Type == fill.Type {
+				exclude = append(exclude, e.ID)
+			}
+		}
+		extra, err := fillSlot(w, r, fill.Type, fill.Scope, exclude, slot.MaxItems-len(entries))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entries = append(entries, extra...)
+	}
+	if slot.MaxItems > 0 && len(entries) > slot.MaxItems {
+		entries = entries[:slot.MaxItems]
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"key":   slot.Key,
+		"label": slot.Label,
+		"items": entries,
+	})
+}
+
+// resolveSlotItems loads the referenced records with one query per type
+func resolveSlotItems(w http.ResponseWriter, r *http.Request, items []models.SlotItem) ([]slotEntry, error) {
+	ids := map[string][]uint{}
+	for _, item := range items {
+		ids[item.Type] = append(ids[item.Type], item.ID)
+	}
+
+	found := map[string]interface{}{}
+	if len(ids["post"]) > 0 {
+		var posts []models.BlogPost
+		if err := database.DB.Scopes(publishedPosts).Where("id IN ?", ids["post"]).Find(&posts).Error; err != nil {
+			return nil, err
+		}
+		for i := range posts {
+			localize(w, r, &posts[i])
+			found[fmt.Sprintf("post:%d", posts[i].ID)] = posts[i]
+		}
+	}
+	if len(ids["initiative"]) > 0 {
+		var initiatives []models.Initiative
+		if err := database.DB.Scopes(publishedInitiatives).Where("id IN ?", ids["initiative"]).Find(&initiatives).Error; err != nil {
+			return nil, err
+		}
+		for i := range initiatives {
+			localize(w, r, &initiatives[i])
+			found[fmt.Sprintf("initiative:%d", initiatives[i].ID)] = initiatives[i]
+		}
+	}
+	if len(ids["resource"]) > 0 {
+		var resources []models.Resource
+		if err := database.DB.Where("id IN ?", ids["resource"]).Find(&resources).Error; err != nil {
+			return nil, err
+		}
+		for _, res := range resources {
+			found[fmt.Sprintf("resource:%d", res.ID)] = res
+		}
+	}
+
+	entries := []slotEntry{}
+	for _, item := range items {
+		if record, ok := found[fmt.Sprintf("%s:%d", item.Type, item.ID)]; ok {
+			entries = append(entries, slotEntry{Type: item.Type, ID: item.ID, ExpiresAt: item.ExpiresAt, Item: record})
+		}
+	}
+	return entries, nil
+}
+
+func fillSlot(w http.ResponseWriter, r *http.Request, kind string, scope func(*gorm.DB) *gorm.DB, exclude []uint, limit int) ([]slotEntry, error) {
+	var model interface{}
+	switch kind {
+	case "post":
+		model = &models.BlogPost{}
+	case "initiative":
+		model = &models.Initiative{}
+	default:
+		model = &models.Resource{}
+	}
+
+	query := scope(database.DB.Model(model))
+	if len(exclude) > 0 {
+		query = query.Where("id NOT IN ?", exclude)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	} else {
+		query = query.Limit(20)
+	}
+
+	var ids []uint
+	if err := query.Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	items := make([]models.SlotItem, len(ids))
+	for i, id := range ids {
+		items[i] = models.SlotItem{Type: kind, ID: id}
+	}
+	return resolveSlotItems(w, r, items)
+}
+
+// --- Admin ---
+
+// GetSlots lists every slot with its raw, unresolved items
+func GetSlots(w http.ResponseWriter, r *http.Request) {
+	var slots []models.CuratedSlot
+	if err := database.DB.Order("key").Find(&slots).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, slots)
+}
+
+// SaveSlot creates or replaces a slot. The items array is stored in the order
+// given, so reordering is a matter of sending the list back rearranged.
+func SaveSlot(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	if !slotKeyPattern.MatchString(key) {
+		http.Error(w, "Slot keys look like section.name, e.g. home.featured", http.StatusBadRequest)
+		return
+	}
+
+	var input models.CuratedSlot
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if input.MaxItems < 0 || (input.MaxItems > 0 && len(input.Items) > input.MaxItems) {
+		http.Error(w, fmt.Sprintf("Slot holds at most %d items", input.MaxItems), http.StatusBadRequest)
+		return
+	}
+	if _, ok := slotFills[input.Fill]; input.Fill != "" && !ok {
+		http.Error(w, "Unknown fill "+input.Fill, http.StatusBadRequest)
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, item := range input.Items {
+		ref := fmt.Sprintf("%s:%d", item.Type, item.ID)
+		if seen[ref] {
+			http.Error(w, "Duplicate item "+ref, http.StatusBadRequest)
+			return
+		}
+		seen[ref] = true
+
+		var model interface{}
+		switch item.Type {
+		case "post":
+			model = &models.BlogPost{}
+		case "initiative":
+			model = &models.Initiative{}
+		case "resource":
+			model = &models.Resource{}
+		default:
+			http.Error(w, "Item type must be post, initiative or resource", http.StatusBadRequest)
+			return
+		}
+		var count int64
+		if err := database.DB.Model(model).Where("id = ?", item.ID).Count(&count).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if count == 0 {
+			http.Error(w, "Referenced item not found: "+ref, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Upsert based on key. A deleted slot still holds its key in the unique
+	// index, so it is brought back rather than inserted again.
+	var slot models.CuratedSlot
+	err := database.DB.Unscoped().Where("key = ?", key).First(&slot).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slot.DeletedAt = gorm.DeletedAt{}
+	slot.Key = key
+	slot.Label = input.Label
+	slot.MaxItems = input.MaxItems
+	slot.Fill = input.Fill
+	slot.Items = input.Items
+	if slot.Items == nil {
+		slot.Items = []models.SlotItem{}
+	}
+
+	if err := database.DB.Unscoped().Save(&slot).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, slot)
+}
+
+func DeleteSlot(w http.ResponseWriter, r *http.Request) {
+	if err := database.DB.Where("key = ?", chi.URLParam(r, "key")).Delete(&models.CuratedSlot{}).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, map[string]string{"message": "Deleted successfully"})
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/mailtmpl"
+	"yiaga-backend/models"
+)
+
+// Email templates are edited like posts: saves need If-Match with the version
+// being edited, and every save is kept as a version that can be restored.
+
+// GetEmailTemplates lists templates; filter with ?kind= and ?locale=
+func GetEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Order("kind, name, locale")
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if locale := r.URL.Query().Get("locale"); locale != "" {
+		query = query.Where("locale = ?", locale)
+	}
+	var templates []models.EmailTemplate
+	if err := query.Find(&templates).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, templates)
+}
+
+// GetEmailTemplateTypes lists the emails the site sends, with the sample data
+// their templates are previewed with
+func GetEmailTemplateTypes(w http.ResponseWriter, r *http.Request) {
+	type entry struct {
+		Name string `json:"name"`
+		mailtmpl.Type
+	}
+	types := []entry{}
+	for _, name := range mailtmpl.TypeNames() {
+		types = append(types, entry{name, mailtmpl.Types[name]})
+	}
+	respondJSON(w, types)
+}
+
+func findEmailTemplate(w http.ResponseWriter, r *http.Request) (models.EmailTemplate, bool) {
+	var t models.EmailTemplate
+	if err := database.DB.First(&t, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return t, false
+	}
+	return t, true
+}
+
+func GetEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := findEmailTemplate(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("ETag", versionTag(t.Version))
+	respondJSON(w, t)
+}
+
+// CreateEmailTemplate adds a layout, a partial, or an email in another locale
+func CreateEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	var input models.EmailTemplate
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t := models.EmailTemplate{
+		Name: input.Name, Kind: input.Kind, Locale: input.Locale,
+		Layout: input.Layout, Subject: input.Subject, HTML: input.HTML, Text: input.Text,
+		Version: 1,
+	}
+	if err := mailtmpl.Check(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t.UpdatedBy, _ = currentEditor(r)
+	if err := database.DB.Create(&t).Error; errors.Is(err, gorm.ErrDuplicatedKey) {
+		http.Error(w, "A template with this name and locale already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := mailtmpl.Snapshot(t); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", versionTag(t.Version))
+	respondJSON(w, t)
+}
+
+// saveEmailTemplate applies an edit to the template in the URL as a new
+// version, guarded by If-Match
+func saveEmailTemplate(w http.ResponseWriter, r *http.Request, t models.EmailTemplate, edit models.EmailTemplate) {
+	expected, ok := ifMatchVersion(w, r, t.Version)
+	if !ok {
+		return
+	}
+	if expected != t.Version {
+		respondStale(w, t, t.Version)
+		return
+	}
+	// Name, kind and locale identify a template and cannot change
+	t.Layout, t.Subject, t.HTML, t.Text = edit.Layout, edit.Subject, edit.HTML, edit.Text
+	if err := mailtmpl.Check(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t.UpdatedBy, _ = currentEditor(r)
+	saved, err := saveVersioned(&t, &t.Version, expected)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !saved {
+		var current models.EmailTemplate
+		database.DB.First(&current, t.ID)
+		respondStale(w, current, current.Version)
+		return
+	}
+	if err := mailtmpl.Snapshot(t); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", versionTag(t.Version))
+	respondJSON(w, t)
+}
+
+func UpdateEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := findEmailTemplate(w, r)
+	if !ok {
+		return
+	}
+	var input models.EmailTemplate
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	saveEmailTemplate(w, r, t, input)
+}
+
+// DeleteEmailTemplate removes a template and its versions. Deleting a built
+// in English template puts the shipped version back in use until it is
+// seeded again on restart.
+func DeleteEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := findEmailTemplate(w, r)
+	if !ok {
+		return
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("template_id = ?", t.ID).Delete(&models.EmailTemplateVersion{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&t).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, map[string]string{"message": "Deleted"})
+}
+
+// GetEmailTemplateVersions lists a template's saved versions, newest first
+func GetEmailTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	t, ok := findEmailTemplate(w, r)
+	if !ok {
+		return
+	}
+	var versions []models.EmailTemplateVersion
+	if err := database.DB.Where("template_id = ?", t.ID).Order("version desc").Find(&versions).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, versions)
+}
+
+// RestoreEmailTemplateVersion saves an old version's content as the newest
+// version. Like any save it needs If-Match.
+func RestoreEmailTemplateVersion(w http.ResponseWriter, r *http.Request) {
+	t, ok := findEmailTemplate(w, r)
+	if !ok {
+		return
+	}
+	var old models.EmailTemplateVersion
+	if err := database.DB.Where("template_id = ? AND version = ?", t.ID, chi.URLParam(r, "version")).First(&old).Error; err != nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+	saveEmailTemplate(w, r, t, models.EmailTemplate{Layout: old.Layout, Subject: old.Subject, HTML: old.HTML, Text: old.Text})
+}
+
+// PreviewEmailTemplate renders an unsaved template with sample data:
+// {"kind": "email", "name": "comment.reply", "locale": "fr", "subject": "...", "html": "..."}.
+// A layout or partial is shown inside the email given as "email".
+func PreviewEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		models.EmailTemplate
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t := input.EmailTemplate
+	if err := mailtmpl.Check(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rendered, err := mailtmpl.Preview(t, input.Email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	respondJSON(w, rendered)
+}
+
+// PreviewSavedEmailTemplate renders a saved template with sample data:
+// ?email= as for PreviewEmailTemplate, and ?format=html or text to get just
+// that part, e.g. for an iframe
+func PreviewSavedEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := findEmailTemplate(w, r)
+	if !ok {
+		return
+	}
+	rendered, err := mailtmpl.Preview(t, r.URL.Query().Get("email"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Query().Get("format") {
+	case "html":
+		// Templates are admin written HTML served from the API's origin, so
+		// the page gets no scripts, forms or same origin access
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write([]byte(rendered.HTML))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write([]byte(rendered.Text))
+	default:
+		respondJSON(w, rendered)
+	}
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/feeds"
+	"yiaga-backend/models"
+)
+
+const feedItemLimit = 50
+
+var feedContentTypes = map[string]string{
+	"rss":  "application/rss+xml; charset=utf-8",
+	"atom": "application/atom+xml; charset=utf-8",
+	"json": "application/feed+json; charset=utf-8",
+}
+
+// GetTypeFeed serves /feeds/{type}/{format} where type is blog, news or all
+func GetTypeFeed(w http.ResponseWriter, r *http.Request) {
+	postType := chi.URLParam(r, "type")
+	query := database.DB.Model(&models.BlogPost{})
+
+	var title, link string
+	switch postType {
+	case "blog":
+		title, link = "Yiaga Africa Blog", siteURL()+"/blog"
+		query = query.Where("type = ?", "blog")
+	case "news":
+		title, link = "Yiaga Africa News", siteURL()+"/news"
+		query = query.Where("type = ?", "news")
+	case "all":
+		title, link = "Yiaga Africa", siteURL()
+	default:
+		http.Error(w, "Unknown feed type", http.StatusNotFound)
+		return
+	}
+
+	serveFeed(w, r, query, feeds.Feed{
+		Title:       title,
+		Description: "Latest stories, analysis and updates from Yiaga Africa",
+		Link:        link,
+	}, "/feeds/"+postType)
+}
+
+// GetCategoryFeed serves /feeds/category/{category}/{format}
+func GetCategoryFeed(w http.ResponseWriter, r *http.Request) {
+	category, _ := url.PathUnescape(chi.URLParam(r, "category"))
+	query := database.DB.Model(&models.BlogPost{}).Where("category = ?", category)
+
+	serveFeed(w, r, query, feeds.Feed{
+		Title:       "Yiaga Africa: " + category,
+		Description: "Posts filed under " + category,
+		Link:        siteURL() + "/blog?category=" + url.QueryEscape(category),
+	}, "/feeds/category/"+url.PathEscape(category))
+}
+
+// GetTagFeed serves /feeds/tag/{tag}/{format}
+func GetTagFeed(w http.ResponseWriter, r *http.Request) {
+	tag, _ := url.PathUnescape(chi.URLParam(r, "tag"))
+	// Tags are stored as a JSON array in a text column
+	tagJSON, _ := json.Marshal([]string{tag})
+	query := database.DB.Model(&models.BlogPost{}).Where("CAST(tags AS jsonb) @> ?", string(tagJSON))
+
+	serveFeed(w, r, query, feeds.Feed{
+		Title:       "Yiaga Africa: #" + tag,
+		Description: "Posts tagged " + tag,
+		Link:        siteURL() + "/blog?tag=" + url.QueryEscape(tag),
+	}, "/feeds/tag/"+url.PathEscape(tag))
+}
+
+func serveFeed(w http.ResponseWriter, r *http.Request, query *gorm.DB, feed feeds.Feed, path string) {
+	format := chi.URLParam(r, "format")
+	contentType, ok := feedContentTypes[format]
+	if !ok {
+		http.Error(w, "Unknown feed format", http.StatusNotFound)
+		return
+	}
+
+	var posts []models.BlogPost
+	err := query.Scopes(publishedPosts).
+		Order("published_at desc").
+		Limit(feedItemLimit).
+		Find(&posts).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Validators are derived from what the feed contains so that any edit,
+	// addition or removal produces a new ETag.
+	h := sha1.New()
+	fmt.Fprintf(h, "%s|%s", path, format)
+	var lastModified time.Time
+	for _, p := range posts {
+		fmt.Fprintf(h, "|%d:%d", p.ID, p.UpdatedAt.UnixNano())
+		if p.UpdatedAt.After(lastModified) {
+			lastModified = p.UpdatedAt
+		}
+	}
+	etag := fmt.Sprintf(`"%x"`, h.Sum(nil))
+
+	w.Header().Set("Cache-Control", "public, max-age=900")
+	if notModified(w, r, etag, lastModified) {
+		return
+	}
+
+	feed.ID = feedTagURI(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), path)
+	feed.FeedURL = apiURL(r, path+"/"+format)
+	feed.Language = "en"
+	feed.Image = absoluteURL("/logo.png")
+	feed.Updated = lastModified
+	if feed.Updated.IsZero() {
+		feed.Updated = time.Now()
+	}
+	for _, p := range posts {
+		feed.Items = append(feed.Items, feedItem(p))
+	}
+
+	var body []byte
+	switch format {
+	case "rss":
+		body, err = feed.RSS()
+	case "atom":
+		body, err = feed.Atom()
+	default:
+		body, err = feed.JSON()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+func feedItem(p models.BlogPost) feeds.Item {
+	item := feeds.Item{
+		// The GUID is keyed on the ID and creation date rather than the slug so
+		// that renaming a post does not make readers see it twice.
+		ID:        feedTagURI(p.CreatedAt, fmt.Sprintf("/posts/%d", p.ID)),
+		Title:     p.Title,
+		Link:      postURL(p),
+		Summary:   p.Excerpt,
+		Content:   p.Content,
+		Author:    p.Author,
+		Image:     absoluteURL(p.Image),
+		Published: p.PublishedAt,
+		Updated:   p.UpdatedAt,
+	}
+	if p.Category != "" {
+		item.Categories = append(item.Categories, p.Category)
+	}
+	item.Categories = append(item.Categories, p.Tags...)
+	if p.PdfUrl != "" {
+		item.Enclosure = &feeds.Enclosure{URL: absoluteURL(p.PdfUrl), Type: "application/pdf"}
+	}
+	return item
+}
+
+// postURL is the public page for a blog post or news item
+func postURL(p models.BlogPost) string {
+	if p.Type == "news" {
+		return siteURL() + "/news/" + p.Slug
+	}
+	return siteURL() + "/blog/" + p.Slug
+}
+
+// feedTagURI builds an RFC 4151 tag URI, which stays valid if the site moves
+func feedTagURI(date time.Time, specific string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(siteURL(), "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+	host = strings.SplitN(host, ":", 2)[0]
+	return fmt.Sprintf("tag:%s,%s:%s", host, date.UTC().Format("2006-01-02"), specific)
+}
+
+// apiURL rebuilds the absolute URL of an /api path on this server
+func apiURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/api" + path
+}
@@ -2,11 +2,20 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"gorm.io/gorm"
+
 	"yiaga-backend/database"
+	"yiaga-backend/i18n"
+	"yiaga-backend/mail"
+	"yiaga-backend/mailtmpl"
 	"yiaga-backend/models"
+	"yiaga-backend/newsletter"
 )
 
 func SubmitContact(w http.ResponseWriter, r *http.Request) {
@@ -17,6 +26,11 @@ func SubmitContact(w http.ResponseWriter, r *http.Request) {
 	}
 	// Initial status
 	message.Status = "new"
+	message.ModerationMatches = nil
+	if err := applyContactRules(&message); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	result := database.DB.Create(&message)
 	if result.Error != nil {
@@ -26,21 +40,139 @@ func SubmitContact(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, map[string]string{"message": "Contact form submitted successfully"})
 }
 
+// SubscribeNewsletter signs an address up pending confirmation and emails it
+// a confirmation link; see ConfirmSubscription. Signing up again resends the
+// link. An address that confirmed before and has since paused or
+// unsubscribed keeps its status until the new link is followed. Every
+// outcome gets the same answer, so the form does not reveal who is subscribed.
 func SubscribeNewsletter(w http.ResponseWriter, r *http.Request) {
-	var sub models.Subscriber
-	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+	var input struct {
+		Email         string   `json:"email"`
+		Subscriptions []string `json:"subscriptions"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<10)).Decode(&input); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	email := strings.ToLower(strings.TrimSpace(input.Email))
+	fields := map[string]string{}
+	if !validEmail(email) {
+		fields["email"] = "Please enter a valid email address"
+	}
+	topics, err := newsletter.CheckTopics(input.Subscriptions)
+	if err != nil {
+		fields["subscriptions"] = err.Error()
+	}
+	if len(fields) > 0 {
+		respondFieldErrors(w, fields)
+		return
+	}
 
-	sub.IsActive = true
-	sub.SubscribedAt = time.Now()
+	var sub models.Subscriber
+	err = database.DB.Unscoped().Where("lower(email) = ?", email).First(&sub).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		sub = models.Subscriber{Email: email, Subscriptions: topics, Status: newsletter.StatusPending}
+		if err := database.DB.Create(&sub).Error; errors.Is(err, gorm.ErrDuplicatedKey) {
+			// The same address, submitted twice at once
+			respondJSON(w, map[string]string{"message": subscribeMessage})
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		newsletter.Record(database.DB, sub, "", "form")
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case sub.Status == newsletter.StatusActive && !sub.DeletedAt.Valid:
+		// Already receiving newsletters; topics are changed in the preference centre
+		respondJSON(w, map[string]string{"message": subscribeMessage})
+		return
+	case sub.ConfirmedAt != nil:
+		// Paused, unsubscribed or removed after confirming: the record stays
+		// as it is, bar the topics, until the address confirms again
+		if sub.DeletedAt.Valid && sub.Status == newsletter.StatusActive {
+			sub.Status, sub.IsActive = newsletter.StatusUnsubscribed, false
+		}
+		sub.DeletedAt = gorm.DeletedAt{}
+		if len(topics) > 0 {
+			sub.Subscriptions = topics
+		}
+		err := database.DB.Unscoped().Model(&sub).
+			Select("status", "is_active", "deleted_at", "subscriptions").Updates(&sub).Error
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if sub.ConfirmationSentAt != nil && time.Since(*sub.ConfirmationSentAt) < newsletter.ResendInterval {
+			respondJSON(w, map[string]string{"message": subscribeMessage})
+			return
+		}
+	default:
+		// Pending or removed without ever confirming: pending, confirmed afresh
+		from := sub.Status
+		sub.Status, sub.IsActive, sub.PausedUntil, sub.DeletedAt = newsletter.StatusPending, false, nil, gorm.DeletedAt{}
+		if len(topics) > 0 {
+			sub.Subscriptions = topics
+		}
+		err := database.DB.Unscoped().Model(&sub).
+			Select("status", "is_active", "paused_until", "deleted_at", "subscriptions").Updates(&sub).Error
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if from != newsletter.StatusPending {
+			newsletter.Record(database.DB, sub, from, "form")
+		}
+		if sub.ConfirmationSentAt != nil && time.Since(*sub.ConfirmationSentAt) < newsletter.ResendInterval {
+			respondJSON(w, map[string]string{"message": subscribeMessage})
+			return
+		}
+	}
 
-	result := database.DB.Create(&sub)
-	if result.Error != nil {
-		// handle duplicate email gracefully potentially
-		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+	if err := sendConfirmation(r, &sub); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, map[string]string{"message": subscribeMessage})
+}
+
+const subscribeMessage = "Please check your inbox and confirm your subscription"
+
+// sendConfirmation queues the confirmation email, replacing any earlier link
+func sendConfirmation(r *http.Request, sub *models.Subscriber) error {
+	now := time.Now().Truncate(time.Second)
+	if err := database.DB.Model(sub).Update("confirmation_sent_at", now).Error; err != nil {
+		return err
+	}
+	sub.ConfirmationSentAt = &now
+
+	data := mailtmpl.ConfirmData{
+		Link:       emailedAPIURL("/subscribe/confirm?token=" + newsletter.ConfirmToken(*sub)),
+		ValidHours: int(newsletter.ConfirmTTL().Hours()),
+	}
+	if data.ValidHours >= 48 {
+		data.ValidDays = data.ValidHours / 24
+	}
+	// In the language the form was filled in
+	email, err := mailtmpl.Render("newsletter.confirm", i18n.Negotiate(r), data)
+	if err != nil {
+		return err
+	}
+	return mail.Enqueue("newsletter.confirm", mail.Message{To: sub.Email, Subject: email.Subject, Text: email.Text, HTML: email.HTML})
+}
+
+// ConfirmSubscription activates a subscriber from the emailed link,
+// /subscribe/confirm?token=, once the page it shows is submitted
+func ConfirmSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondConfirm(w, confirmPage{Question: "Confirm your subscription to Yiaga Africa updates?", Button: "Confirm my subscription"})
+		return
+	}
+	if _, err := newsletter.Confirm(r.URL.Query().Get("token")); err != nil {
+		respondNotice(w, http.StatusBadRequest, "Subscription not confirmed", err.Error())
 		return
 	}
-	respondJSON(w, map[string]string{"message": "Subscribed successfully"})
+	respondNotice(w, http.StatusOK, "Subscription confirmed", "Thank you, your subscription is confirmed.")
 }
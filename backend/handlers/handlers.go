@@ -3,9 +3,74 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 )
 
 func respondJSON(w http.ResponseWriter, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(payload)
 }
+
+// siteURL is the public address of the frontend, used when building absolute links
+func siteURL() string {
+	u := os.Getenv("SITE_URL")
+	if u == "" {
+		u = "https://yiaga.org"
+	}
+	return strings.TrimRight(u, "/")
+}
+
+// emailedAPIURL is the absolute URL of an /api path for links sent by email.
+// It is built from API_URL, the API's public address (default SITE_URL),
+// and never from the request, whose Host header the sender controls.
+func emailedAPIURL(path string) string {
+	base := os.Getenv("API_URL")
+	if base == "" {
+		base = siteURL()
+	}
+	return strings.TrimRight(base, "/") + "/api" + path
+}
+
+// absoluteURL turns a site relative path (e.g. "/src/assets/blog-1.jpg") into a full URL
+func absoluteURL(path string) string {
+	if path == "" || strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return siteURL() + path
+}
+
+// notModified sets the validators for a cacheable response and reports whether
+// the client's copy is still fresh, in which case a 304 has already been written.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since (RFC 9110 13.2.2)
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !lastModified.Truncate(time.Second).After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+	"yiaga-backend/wordpress"
+)
+
+// ImportWordPress ingests a WXR export uploaded as the "file" form field, in
+// the background: it answers 202 with the run, which GetImportRun reports on.
+// Set update=true to refresh posts from an earlier import. Media missing from
+// WP_UPLOADS_DIR is downloaded from the exported site when WP_DOWNLOAD_MEDIA
+// is set.
+func ImportWordPress(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Error retrieving the file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	// The upload's temporary file goes with the request
+	export, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := wordpress.DefaultOptions()
+	opts.Update = r.FormValue("update") == "true"
+	run := models.ImportRun{Status: "running", Filename: header.Filename, Update: opts.Update}
+	run.StartedBy, _ = currentEditor(r)
+	if err := database.DB.Create(&run).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	go runImport(run, export, opts)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
+
+func runImport(run models.ImportRun, export []byte, opts wordpress.Options) {
+	updates := map[string]interface{}{"status": "done"}
+	report, err := wordpress.Import(bytes.NewReader(export), opts)
+	if err == nil {
+		var encoded []byte
+		encoded, err = json.Marshal(report)
+		updates["report"] = encoded
+	}
+	if err != nil {
+		updates["status"], updates["error"] = "failed", err.Error()
+	}
+	if err := database.DB.Model(&run).Updates(updates).Error; err != nil {
+		log.Printf("wordpress import %d: %v", run.ID, err)
+	}
+	invalidateRelated()
+}
+
+// GetImportRun reports on a WordPress import started by ImportWordPress
+func GetImportRun(w http.ResponseWriter, r *http.Request) {
+	var run models.ImportRun
+	if err := database.DB.First(&run, chi.URLParam(r, "id")).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Import not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, run)
+}
+
+// findRedirect looks up a legacy URL, with and without its query string
+// (WordPress shortlinks are "/?p=123") and trailing slash
+func findRedirect(path, rawQuery string) (models.Redirect, bool) {
+	var redirect models.Redirect
+	path = strings.TrimSuffix(path, "/")
+	candidates := []string{path}
+	if rawQuery != "" {
+		if path == "" {
+			path = "/"
+		}
+		candidates = []string{path + "?" + rawQuery, path}
+	}
+	for _, from := range candidates {
+		if from == "" {
+			continue
+		}
+		if err := database.DB.Where("from_path = ?", from).First(&redirect).Error; err == nil {
+			return redirect, true
+		}
+	}
+	return redirect, false
+}
+
+// FollowRedirect handles requests for unknown paths, sending legacy links to
+// their new home on the site
+func FollowRedirect(w http.ResponseWriter, r *http.Request) {
+	redirect, ok := findRedirect(r.URL.Path, r.URL.RawQuery)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, siteURL()+redirect.ToPath, redirect.StatusCode)
+}
+
+// ResolveRedirect lets the SPA's not found page check for a legacy link:
+// GET /redirects/resolve?path=/2019/05/04/old-post/
+func ResolveRedirect(w http.ResponseWriter, r *http.Request) {
+	u, err := url.Parse(r.URL.Query().Get("path"))
+	if err != nil || u.Path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	redirect, ok := findRedirect(u.Path, u.RawQuery)
+	if !ok {
+		http.Error(w, "No redirect for this path", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, map[string]interface{}{
+		"to":     redirect.ToPath,
+		"status": redirect.StatusCode,
+	})
+}
@@ -3,21 +3,28 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"strings"
 
 	"github.com/go-chi/chi/v5"
 
 	"yiaga-backend/database"
 	"yiaga-backend/models"
+	"yiaga-backend/sanitize"
 )
 
 func GetInitiatives(w http.ResponseWriter, r *http.Request) {
 	var initiatives []models.Initiative
-	result := database.DB.Order("created_at desc").Find(&initiatives)
+	query := database.DB.Order("created_at desc")
+	if !isEditor(r) {
+		query = query.Scopes(publishedInitiatives)
+	}
+	result := query.Find(&initiatives)
 	if result.Error != nil {
 		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
 		return
 	}
+	for i := range initiatives {
+		localize(w, r, &initiatives[i])
+	}
 	respondJSON(w, initiatives)
 }
 
@@ -29,6 +36,12 @@ func GetInitiativeBySlug(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Initiative not found", http.StatusNotFound)
 		return
 	}
+	if initiative.PublishStatus != statusPublished && !isEditor(r) && !previewAllowed(w, r, "initiative", initiative.ID) {
+		http.Error(w, "Initiative not found", http.StatusNotFound)
+		return
+	}
+	localize(w, r, &initiative)
+	w.Header().Set("ETag", localizedTag(w, initiative.Version))
 	respondJSON(w, initiative)
 }
 
@@ -38,13 +51,18 @@ func CreateInitiative(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if init.Slug == "" {
-		init.Slug = strings.ToLower(strings.ReplaceAll(init.Title, " ", "-"))
+	if !validPublishStatus(init.PublishStatus) {
+		http.Error(w, "publish_status must be draft or published", http.StatusBadRequest)
+		return
 	}
-	if err := database.DB.Create(&init).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	init.Content = sanitize.RichText(init.Content)
+	sanitizeTranslatedContent(init.Translations)
+
+	if err := createWithSlug(&models.Initiative{}, &init, &init.Slug, init.Title); err != nil {
+		respondSlugError(w, err)
 		return
 	}
+	invalidateRelated()
 	respondJSON(w, init)
 }
 
@@ -55,6 +73,14 @@ func UpdateInitiative(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Initiative not found", http.StatusNotFound)
 		return
 	}
+	expected, ok := ifMatchVersion(w, r, init.Version)
+	if !ok {
+		return
+	}
+	if expected != init.Version {
+		respondStale(w, init, init.Version)
+		return
+	}
 	var input models.Initiative
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -62,10 +88,42 @@ func UpdateInitiative(w http.ResponseWriter, r *http.Request) {
 	}
 	// Update fields - simplistic
 	input.ID = init.ID
-	if err := database.DB.Save(&input).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	input.CreatedAt = init.CreatedAt
+	if input.PublishStatus == "" {
+		input.PublishStatus = init.PublishStatus
+	} else if !validPublishStatus(input.PublishStatus) {
+		http.Error(w, "publish_status must be draft or published", http.StatusBadRequest)
+		return
+	}
+	input.Content = sanitize.RichText(input.Content)
+	sanitizeTranslatedContent(input.Translations)
+	if input.Translations == nil {
+		input.Translations = init.Translations
+	}
+	if input.Slug == "" {
+		input.Slug = init.Slug
+	} else if input.Slug != init.Slug {
+		slug, err := assignSlug(&models.Initiative{}, input.Slug, input.Title, init.ID)
+		if err != nil {
+			respondSlugError(w, err)
+			return
+		}
+		input.Slug = slug
+	}
+	saved, err := saveVersioned(&input, &input.Version, expected)
+	if err != nil {
+		respondSlugError(w, slugConflict(err))
+		return
+	}
+	if !saved {
+		// Lost a race with another save since the initiative was loaded
+		var current models.Initiative
+		database.DB.First(&current, init.ID)
+		respondStale(w, current, current.Version)
 		return
 	}
+	invalidateRelated()
+	w.Header().Set("ETag", versionTag(input.Version))
 	respondJSON(w, input)
 }
 
@@ -75,5 +133,6 @@ func DeleteInitiative(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	invalidateRelated()
 	respondJSON(w, map[string]string{"message": "Deleted successfully"})
 }
@@ -33,8 +33,8 @@ func CreateJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	job.Posted = time.Now().Format("Jan 2, 2006") // Simple date string or use hook
-	if err := database.DB.Create(&job).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := createWithSlug(&models.Job{}, &job, &job.Slug, job.Title); err != nil {
+		respondSlugError(w, err)
 		return
 	}
 	respondJSON(w, job)
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/middleware"
+	"yiaga-backend/models"
+)
+
+// maxBulkComments caps how many comments one bulk request can change
+const maxBulkComments = 5000
+
+// commentFilter selects comments for moderation. Empty fields match
+// everything; From and To take a date (2006-01-02, To inclusive) or an
+// RFC 3339 time.
+type commentFilter struct {
+	TargetType string `json:"target_type"` // blog, initiative, resource
+	TargetID   uint   `json:"target_id"`
+	PostID     uint   `json:"post_id"` // Shorthand for a blog target
+	Status     string `json:"status"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Email      string `json:"email"`
+	Text       string `json:"text"` // Case insensitive match on the content
+}
+
+func commentFilterFromQuery(r *http.Request) commentFilter {
+	q := r.URL.Query()
+	f := commentFilter{TargetType: q.Get("target_type"), Status: q.Get("status"), From: q.Get("from"), To: q.Get("to"), Email: q.Get("email"), Text: q.Get("q")}
+	if id, err := strconv.ParseUint(q.Get("target_id"), 10, 64); err == nil {
+		f.TargetID = uint(id)
+	}
+	if id, err := strconv.ParseUint(q.Get("post"), 10, 64); err == nil {
+		f.PostID = uint(id)
+	}
+	return f
+}
+
+func (f commentFilter) empty() bool {
+	return f == commentFilter{}
+}
+
+// apply adds the filter's conditions to a comment query
+func (f commentFilter) apply(db *gorm.DB) (*gorm.DB, error) {
+	if f.PostID != 0 {
+		db = db.Where("target_type = ? AND target_id = ?", targetBlog, f.PostID)
+	}
+	if f.TargetType != "" {
+		db = db.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != 0 {
+		db = db.Where("target_id = ?", f.TargetID)
+	}
+	if f.Status != "" {
+		db = db.Where("status = ?", f.Status)
+	}
+	if f.From != "" {
+		from, _, err := parseFilterTime(f.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %v", err)
+		}
+		db = db.Where("created_at >= ?", from)
+	}
+	if f.To != "" {
+		to, dateOnly, err := parseFilterTime(f.To)
+		if err != nil {
+			return nil, fmt.Errorf("to: %v", err)
+		}
+		if dateOnly {
+			db = db.Where("created_at < ?", to.AddDate(0, 0, 1))
+		} else {
+			db = db.Where("created_at <= ?", to)
+		}
+	}
+	if f.Email != "" {
+		db = db.Where("lower(email) = ?", strings.ToLower(strings.TrimSpace(f.Email)))
+	}
+	if f.Text != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(f.Text)
+		db = db.Where("content ILIKE ?", "%"+escaped+"%")
+	}
+	return db, nil
+}
+
+func parseFilterTime(s string) (t time.Time, dateOnly bool, err error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("%q is not a date (2006-01-02) or RFC 3339 time", s)
+}
+
+// BulkModerateComments approves, rejects or deletes many comments at once,
+// chosen either by ID or by filter:
+//
+//	{"action": "reject", "ids": [4, 8, 15]}
+//	{"action": "delete", "filter": {"post_id": 12, "status": "pending", "text": "casino"}}
+//
+// With "dry_run": true nothing changes and the matching IDs are returned.
+// Everything happens in one transaction, recorded as a single audit entry.
+func BulkModerateComments(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Action string        `json:"action"`
+		IDs    []uint        `json:"ids"`
+		Filter commentFilter `json:"filter"`
+		DryRun bool          `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	statuses := map[string]string{"approve": "approved", "reject": "rejected"}
+	if _, ok := statuses[input.Action]; !ok && input.Action != "delete" {
+		http.Error(w, "action must be approve, reject or delete", http.StatusBadRequest)
+		return
+	}
+	// An empty selection must not mean every comment
+	if len(input.IDs) == 0 && input.Filter.empty() {
+		http.Error(w, "Select comments by ids or filter", http.StatusBadRequest)
+		return
+	}
+	if len(input.IDs) > maxBulkComments {
+		http.Error(w, fmt.Sprintf("At most %d comments can be changed at once", maxBulkComments), http.StatusBadRequest)
+		return
+	}
+
+	var matched, approved []uint
+	var touched []models.Comment
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&models.Comment{})
+		if len(input.IDs) > 0 {
+			query = query.Where("id IN ?", input.IDs)
+		}
+		query, err := input.Filter.apply(query)
+		if err != nil {
+			return errBadFilter{err}
+		}
+		if err := query.Order("id").Limit(maxBulkComments+1).Pluck("id", &matched).Error; err != nil {
+			return err
+		}
+		if len(matched) > maxBulkComments {
+			return errBadFilter{fmt.Errorf("the filter matches more than %d comments, narrow it down", maxBulkComments)}
+		}
+		if input.DryRun || len(matched) == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&models.Comment{}).Where("id IN ?", matched).Distinct("target_type", "target_id").Find(&touched).Error; err != nil {
+			return err
+		}
+		if input.Action == "approve" {
+			if err := tx.Model(&models.Comment{}).Where("id IN ? AND status <> ?", matched, "approved").
+				Pluck("id", &approved).Error; err != nil {
+				return err
+			}
+		}
+
+		if input.Action == "delete" {
+			err = tx.Where("id IN ?", matched).Delete(&models.Comment{}).Error
+		} else {
+			// As in UpdateCommentStatus, the moderator's decision replaces the classifier's
+			err = tx.Model(&models.Comment{}).Where("id IN ?", matched).
+				Updates(map[string]interface{}{"status": statuses[input.Action], "auto_rejected": false}).Error
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Create(bulkAuditEntry(r, input.Action, input.IDs, input.Filter, matched)).Error
+	})
+	if bad, ok := err.(errBadFilter); ok {
+		http.Error(w, bad.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	refreshCommentStats(touched...)
+	notifyNewlyApproved(r, approved)
+
+	if matched == nil {
+		matched = []uint{}
+	}
+	respondJSON(w, map[string]interface{}{
+		"action":   input.Action,
+		"dry_run":  input.DryRun,
+		"affected": len(matched),
+		"ids":      matched,
+	})
+}
+
+type errBadFilter struct{ error }
+
+// bulkAuditEntry summarises a bulk moderation: who, what, how the comments
+// were chosen and which ones changed
+func bulkAuditEntry(r *http.Request, action string, ids []uint, filter commentFilter, matched []uint) *models.AuditLog {
+	userID, username := currentEditor(r)
+	role := ""
+	if claims := middleware.ClaimsFromContext(r.Context()); claims != nil {
+		role = claims.Role
+	}
+
+	selection := "by id"
+	if !filter.empty() {
+		parts, _ := json.Marshal(filter)
+		selection = "by filter " + string(parts)
+		if len(ids) > 0 {
+			selection = fmt.Sprintf("by %d ids and filter %s", len(ids), parts)
+		}
+	}
+	details, _ := json.Marshal(matched)
+	return &models.AuditLog{
+		Action:    "comments." + action,
+		Details:   fmt.Sprintf("Bulk %s of %d comments selected %s: %s", action, len(matched), selection, details),
+		UserID:    strconv.FormatUint(uint64(userID), 10),
+		UserName:  username,
+		UserRole:  role,
+		IPAddress: clientIP(r),
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+}
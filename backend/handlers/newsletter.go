@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"yiaga-backend/models"
+	"yiaga-backend/newsletter"
+)
+
+// The preference centre is reached through the signed token in every
+// newsletter (see newsletter.PreferencesToken); there are no accounts.
+
+type preferences struct {
+	Email         string     `json:"email"`
+	Status        string     `json:"status"`
+	Subscriptions []string   `json:"subscriptions"`
+	PausedUntil   *time.Time `json:"paused_until"`
+	Topics        []string   `json:"topics"` // Every topic that can be chosen
+}
+
+func newPreferences(sub models.Subscriber) preferences {
+	topics := sub.Subscriptions
+	if topics == nil {
+		topics = []string{}
+	}
+	return preferences{Email: sub.Email, Status: sub.Status, Subscriptions: topics, PausedUntil: sub.PausedUntil, Topics: newsletter.Topics}
+}
+
+// preferencesSubscriber resolves the ?token= of a preference centre request
+func preferencesSubscriber(w http.ResponseWriter, r *http.Request) (models.Subscriber, bool) {
+	sub, ok := newsletter.FromPreferencesToken(r.URL.Query().Get("token"))
+	if !ok {
+		http.Error(w, "This link is not valid", http.StatusNotFound)
+		return sub, false
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	return sub, true
+}
+
+// preferencesPage is the preference centre page, which calls the endpoints
+// below: NEWSLETTER_PREFERENCES_URL, or /newsletter/preferences on the site
+func preferencesPage() string {
+	if page := os.Getenv("NEWSLETTER_PREFERENCES_URL"); page != "" {
+		return page
+	}
+	return siteURL() + "/newsletter/preferences"
+}
+
+// preferencesURL links to a subscriber's preference centre
+func preferencesURL(sub models.Subscriber) string {
+	return newsletter.PreferencesLink(preferencesPage(), sub)
+}
+
+// unsubscribeEndpoint is the RFC 8058 List-Unsubscribe target; newsletters
+// add each subscriber's token
+func unsubscribeEndpoint() string {
+	return emailedAPIURL("/subscribe/unsubscribe")
+}
+
+// GetPreferences shows a subscriber their subscription:
+// GET /subscribe/preferences?token=
+func GetPreferences(w http.ResponseWriter, r *http.Request) {
+	sub, ok := preferencesSubscriber(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, newPreferences(sub))
+}
+
+// UpdatePreferences changes topics, pauses, resumes or unsubscribes:
+//
+//	{"subscriptions": ["Monthly Newsletter"]}
+//	{"status": "paused", "pause_days": 30}
+//	{"status": "unsubscribed"}
+//
+// Fields left out are unchanged.
+func UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	sub, ok := preferencesSubscriber(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Subscriptions *[]string `json:"subscriptions"`
+		Status        string    `json:"status"`
+		PauseDays     int       `json:"pause_days"` // 0 pauses until resumed
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sub.Status == newsletter.StatusPending {
+		http.Error(w, "Please confirm your subscription from the email we sent first", http.StatusConflict)
+		return
+	}
+
+	fields := map[string]string{}
+	var topics []string
+	if input.Subscriptions != nil {
+		var err error
+		if topics, err = newsletter.CheckTopics(*input.Subscriptions); err != nil {
+			fields["subscriptions"] = err.Error()
+		}
+	}
+	var pausedUntil *time.Time
+	switch input.Status {
+	case "", newsletter.StatusActive, newsletter.StatusUnsubscribed:
+	case newsletter.StatusPaused:
+		if input.PauseDays < 0 || time.Duration(input.PauseDays)*24*time.Hour > newsletter.MaxPause {
+			fields["pause_days"] = fmt.Sprintf("Pauses last at most %d days", int(newsletter.MaxPause.Hours()/24))
+		} else if input.PauseDays > 0 {
+			until := time.Now().AddDate(0, 0, input.PauseDays)
+			pausedUntil = &until
+		}
+	default:
+		fields["status"] = "status must be active, paused or unsubscribed"
+	}
+	if len(fields) > 0 {
+		respondFieldErrors(w, fields)
+		return
+	}
+
+	if input.Subscriptions != nil && !sameTopics(topics, sub.Subscriptions) {
+		if err := newsletter.SetTopics(&sub, topics, "preferences"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if input.Status != "" {
+		if err := newsletter.SetStatus(&sub, input.Status, pausedUntil, "preferences"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	respondJSON(w, newPreferences(sub))
+}
+
+func sameTopics(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Unsubscribe handles the List-Unsubscribe link of newsletters:
+// /subscribe/unsubscribe?token=. Mail clients POST to it for one-click
+// unsubscribe (RFC 8058), which takes effect at once; a GET asks to confirm.
+func Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	sub, ok := preferencesSubscriber(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		respondConfirm(w, confirmPage{
+			Question: "Unsubscribe " + sub.Email + " from all Yiaga Africa newsletters?",
+			Button:   "Unsubscribe",
+			Link:     preferencesURL(sub),
+			LinkText: "Choose topics or pause instead",
+		})
+		return
+	}
+
+	source := "unsubscribe_page"
+	if r.ParseForm() == nil && r.PostForm.Get("List-Unsubscribe") == "One-Click" {
+		source = "one_click"
+	}
+	if sub.Status != newsletter.StatusPending {
+		if err := newsletter.SetStatus(&sub, newsletter.StatusUnsubscribed, nil, source); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	respondNotice(w, http.StatusOK, "Unsubscribed", "You have been unsubscribed and will not receive any more newsletters.")
+}
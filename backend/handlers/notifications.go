@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm/clause"
+
+	"yiaga-backend/database"
+	"yiaga-backend/mail"
+	"yiaga-backend/mailtmpl"
+	"yiaga-backend/models"
+	"yiaga-backend/pdftext"
+	"yiaga-backend/sanitize"
+)
+
+// Commenters are emailed when their comment is approved and when an approved
+// reply is posted under it. Each email carries a signed link that stops all
+// further emails about that thread (the top level comment and its replies).
+// The wording is in the comment.approved and comment.reply email templates.
+
+// commentThreadID is the top level comment a comment belongs to
+func commentThreadID(c models.Comment) uint {
+	for i := 0; c.ParentID != nil && i <= maxCommentDepth; i++ {
+		var parent models.Comment
+		if err := database.DB.Unscoped().Select("id", "parent_id").First(&parent, *c.ParentID).Error; err != nil {
+			break
+		}
+		c = parent
+	}
+	return c.ID
+}
+
+func signUnsubscribe(email string, threadID uint) string {
+	mac := hmac.New(sha256.New, commentSecret())
+	fmt.Fprintf(mac, "unsubscribe:%s:%d", strings.ToLower(email), threadID)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18])
+}
+
+func unsubscribeURL(email string, threadID uint) string {
+	q := url.Values{"email": {strings.ToLower(email)}, "sig": {signUnsubscribe(email, threadID)}}
+	return emailedAPIURL(fmt.Sprintf("/comments/threads/%d/unsubscribe?%s", threadID, q.Encode()))
+}
+
+// notifyCommenter queues an email to the author of c unless they have left
+// the thread. kind names the email template.
+func notifyCommenter(r *http.Request, kind string, c models.Comment, body mailtmpl.CommentData) {
+	if c.Email == "" || c.IsStaff {
+		return
+	}
+	threadID := commentThreadID(c)
+	var count int64
+	database.DB.Model(&models.CommentUnsubscribe{}).
+		Where("email = ? AND thread_id = ?", strings.ToLower(c.Email), threadID).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	target, _ := findCommentTarget(c.TargetType, c.TargetID, false)
+	if target.Title == "" {
+		target.Title = c.PostTitle
+	}
+	body.Name = c.Author
+	body.PostTitle = target.Title
+	body.Link = fmt.Sprintf("%s#comment-%d", target.URL, c.ID)
+	body.Unsubscribe = unsubscribeURL(c.Email, threadID)
+
+	email, err := mailtmpl.Render(kind, []string{c.Locale}, body)
+	if err != nil {
+		log.Printf("comment email %s: %v", kind, err)
+		return
+	}
+	err = mail.Enqueue(kind, mail.Message{
+		To:      c.Email,
+		Subject: email.Subject,
+		Text:    email.Text,
+		HTML:    email.HTML,
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + body.Unsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+	if err != nil {
+		log.Printf("comment email %s: %v", kind, err)
+	}
+}
+
+// quoteComment is a short plain text extract of a comment for emails
+func quoteComment(content string) string {
+	return pdftext.Excerpt(sanitize.PlainText(content), 300)
+}
+
+// notifyApproved tells a commenter their comment is live and, for a reply,
+// tells the author of the comment it answers
+func notifyApproved(r *http.Request, c models.Comment) {
+	notifyCommenter(r, "comment.approved", c, mailtmpl.CommentData{Quote: quoteComment(c.Content)})
+	notifyReply(r, c)
+}
+
+// notifyReply tells the author of the comment an approved reply answers
+func notifyReply(r *http.Request, reply models.Comment) {
+	if reply.ParentID == nil {
+		return
+	}
+	var parent models.Comment
+	if err := database.DB.Where("status = ?", "approved").First(&parent, *reply.ParentID).Error; err != nil {
+		return
+	}
+	// Nobody needs telling about their own reply
+	if strings.EqualFold(parent.Email, reply.Email) {
+		return
+	}
+	notifyCommenter(r, "comment.reply", parent, mailtmpl.CommentData{
+		ReplyAuthor: reply.Author,
+		Quote:       quoteComment(reply.Content),
+	})
+}
+
+// notifyNewlyApproved sends approval emails for the given comments, which
+// were not approved before
+func notifyNewlyApproved(r *http.Request, ids []uint) {
+	if len(ids) == 0 {
+		return
+	}
+	var comments []models.Comment
+	database.DB.Where("id IN ? AND status = ?", ids, "approved").Find(&comments)
+	for _, c := range comments {
+		notifyApproved(r, c)
+	}
+}
+
+// UnsubscribeCommentThread stops emails about a thread for the signed address:
+// /comments/threads/{id}/unsubscribe?email=&sig=. Mail clients POST to it for
+// one-click unsubscribe (RFC 8058), which takes effect at once; a GET asks to
+// confirm.
+func UnsubscribeCommentThread(w http.ResponseWriter, r *http.Request) {
+	threadID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	email := strings.ToLower(r.URL.Query().Get("email"))
+	if err != nil || email == "" ||
+		!hmac.Equal([]byte(signUnsubscribe(email, uint(threadID))), []byte(r.URL.Query().Get("sig"))) {
+		http.Error(w, "This unsubscribe link is not valid", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		respondConfirm(w, confirmPage{Question: "Stop emails to " + email + " about this conversation?", Button: "Unsubscribe"})
+		return
+	}
+
+	err = database.DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.CommentUnsubscribe{Email: email, ThreadID: uint(threadID)}).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondNotice(w, http.StatusOK, "Unsubscribed", "You will not get any more emails about this conversation.")
+}
+
+// --- Admin ---
+
+// GetOutgoingEmails lists the mail queue, newest first; filter with
+// ?status=queued|sending|sent|failed and ?kind=
+func GetOutgoingEmails(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Order("created_at desc").Limit(200)
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	var emails []models.OutgoingEmail
+	if err := query.Find(&emails).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, emails)
+}
+
+// RetryOutgoingEmail queues a failed email again
+func RetryOutgoingEmail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := mail.Retry(uint(id)); errors.Is(err, mail.ErrNoEmail) {
+		http.Error(w, "Email not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, mail.ErrNotFailed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, map[string]string{"message": "Queued"})
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// Small pages for links followed from emails. Mail scanners fetch those
+// links too, so a GET only shows a confirmPage whose form POSTs back to the
+// same URL, and the handler acts on the POST.
+
+type confirmPage struct {
+	Question string
+	Button   string
+	Link     string // Optional alternative to the action
+	LinkText string
+}
+
+var confirmTemplate = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Button}}</title></head>
+<body style="font-family:Arial,sans-serif;max-width:32em;margin:4em auto">
+<p>{{.Question}}</p>
+<form method="post"><button type="submit">{{.Button}}</button></form>
+{{if .Link}}<p><a href="{{.Link}}">{{.LinkText}}</a></p>
+{{end}}</body></html>`))
+
+var noticeTemplate = template.Must(template.New("notice").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body style="font-family:Arial,sans-serif;max-width:32em;margin:4em auto">
+<p>{{.Message}}</p>
+<p><a href="{{.Site}}">Back to Yiaga Africa</a></p>
+</body></html>`))
+
+func pageHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+}
+
+// respondConfirm asks the reader to confirm the action of the link they followed
+func respondConfirm(w http.ResponseWriter, page confirmPage) {
+	pageHeaders(w)
+	confirmTemplate.Execute(w, page)
+}
+
+// respondNotice answers a link followed from an email with a small page
+func respondNotice(w http.ResponseWriter, status int, title, message string) {
+	pageHeaders(w)
+	w.WriteHeader(status)
+	noticeTemplate.Execute(w, map[string]string{"Title": title, "Message": message, "Site": siteURL()})
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+	"yiaga-backend/pdftext"
+)
+
+// excerptLength matches the length of the hand written excerpts in the seeds
+const excerptLength = 280
+
+// extractPDF reads the text of a linked PDF. Links that are not PDFs, or that
+// fail to download or parse, give an empty document so saves are never blocked.
+func extractPDF(link string) pdftext.Document {
+	u, err := url.Parse(link)
+	if link == "" || err != nil || !strings.HasSuffix(strings.ToLower(u.Path), ".pdf") {
+		return pdftext.Document{}
+	}
+	if !u.IsAbs() && !strings.HasPrefix(link, "/src/assets/") {
+		link = absoluteURL(link)
+	}
+	doc, err := pdftext.FromURL(link)
+	if err != nil {
+		log.Printf("pdf: extracting %s: %v", link, err)
+	}
+	return doc
+}
+
+// indexPostPDF refreshes the extracted text of a post's PDF and fills an empty
+// excerpt from it
+func indexPostPDF(post *models.BlogPost) {
+	doc := extractPDF(post.PdfUrl)
+	post.PdfText = doc.Text
+	post.PdfPages = doc.Pages
+	if strings.TrimSpace(post.Excerpt) == "" && doc.Text != "" {
+		post.Excerpt = pdftext.Excerpt(doc.Text, excerptLength)
+	}
+}
+
+// indexResourcePDF does the same for a resource's download, filling an empty
+// description
+func indexResourcePDF(res *models.Resource) {
+	doc := extractPDF(res.FileUrl)
+	res.PdfText = doc.Text
+	res.PdfPages = doc.Pages
+	if strings.TrimSpace(res.Description) == "" && doc.Text != "" {
+		res.Description = pdftext.Excerpt(doc.Text, excerptLength)
+	}
+}
+
+// storePostPDF indexes a post's PDF and writes just the derived columns, so
+// neither updated_at nor the edit version moves
+func storePostPDF(post *models.BlogPost) error {
+	indexPostPDF(post)
+	return database.DB.Model(post).UpdateColumns(map[string]interface{}{
+		"pdf_text": post.PdfText, "pdf_pages": post.PdfPages, "excerpt": post.Excerpt,
+	}).Error
+}
+
+// storeResourcePDF does the same for a resource
+func storeResourcePDF(res *models.Resource) error {
+	indexResourcePDF(res)
+	return database.DB.Model(res).UpdateColumns(map[string]interface{}{
+		"pdf_text": res.PdfText, "pdf_pages": res.PdfPages, "description": res.Description,
+	}).Error
+}
+
+// pdfJob asks the indexer to extract the PDF linked from a saved record
+type pdfJob struct {
+	Kind string // "post" or "resource"
+	ID   uint
+	Link string // The link as saved; the job is dropped if it has changed since
+}
+
+var (
+	pdfJobs      = make(chan pdfJob, 256)
+	pdfDropped   atomic.Bool // A job was dropped from a full queue
+	startIndexer sync.Once
+)
+
+// StartPDFIndexer starts the background PDF indexer. Jobs are kept in memory
+// only, so on start it first catches up on records that still have no text
+// for their PDF: those whose job was lost to a restart, or dropped from a
+// full queue, which also makes it catch up again once the queue drains.
+func StartPDFIndexer() {
+	startIndexer.Do(func() { go runPDFIndexer() })
+}
+
+// queuePDF schedules extraction of a record's PDF after it is saved, so a
+// slow download never holds up the editor
+func queuePDF(kind string, id uint, link string) {
+	if link == "" {
+		return
+	}
+	StartPDFIndexer()
+	select {
+	case pdfJobs <- pdfJob{Kind: kind, ID: id, Link: link}:
+	default:
+		pdfDropped.Store(true)
+		log.Printf("pdf: queue full, %s %d is indexed once it drains", kind, id)
+	}
+}
+
+func runPDFIndexer() {
+	catchUpPDFs()
+	for job := range pdfJobs {
+		if err := runPDFJob(job); err != nil {
+			log.Printf("pdf: indexing %s %d: %v", job.Kind, job.ID, err)
+		}
+		if len(pdfJobs) == 0 && pdfDropped.Swap(false) {
+			catchUpPDFs()
+		}
+	}
+}
+
+// catchUpPDFs indexes the PDFs of records saved with a PDF link that were
+// never extracted. A PDF that fails to download is tried again on the next
+// catch up; one that was read but has no text is left alone, as it has pages.
+func catchUpPDFs() {
+	var jobs []pdfJob
+	var posts []models.BlogPost
+	if err := database.DB.Select("id", "pdf_url").
+		Where("pdf_url ILIKE ? AND COALESCE(pdf_text, '') = '' AND COALESCE(pdf_pages, 0) = 0", "%.pdf%").Find(&posts).Error; err != nil {
+		log.Printf("pdf: catching up: %v", err)
+		return
+	}
+	for _, p := range posts {
+		jobs = append(jobs, pdfJob{Kind: "post", ID: p.ID, Link: p.PdfUrl})
+	}
+	var resources []models.Resource
+	if err := database.DB.Select("id", "file_url").
+		Where("file_url ILIKE ? AND COALESCE(pdf_text, '') = '' AND COALESCE(pdf_pages, 0) = 0", "%.pdf%").Find(&resources).Error; err != nil {
+		log.Printf("pdf: catching up: %v", err)
+		return
+	}
+	for _, res := range resources {
+		jobs = append(jobs, pdfJob{Kind: "resource", ID: res.ID, Link: res.FileUrl})
+	}
+
+	for _, job := range jobs {
+		if err := runPDFJob(job); err != nil {
+			log.Printf("pdf: indexing %s %d: %v", job.Kind, job.ID, err)
+		}
+	}
+	if len(jobs) > 0 {
+		log.Printf("pdf: caught up on %d records", len(jobs))
+	}
+}
+
+func runPDFJob(job pdfJob) error {
+	switch job.Kind {
+	case "post":
+		var post models.BlogPost
+		if err := database.DB.First(&post, job.ID).Error; err != nil {
+			return err
+		}
+		if post.PdfUrl != job.Link {
+			return nil
+		}
+		if err := storePostPDF(&post); err != nil {
+			return err
+		}
+	case "resource":
+		var res models.Resource
+		if err := database.DB.First(&res, job.ID).Error; err != nil {
+			return err
+		}
+		if res.FileUrl != job.Link {
+			return nil
+		}
+		if err := storeResourcePDF(&res); err != nil {
+			return err
+		}
+	}
+	invalidateRelated()
+	return nil
+}
+
+// searchScope filters a list to rows matching q, best matches first. q uses
+// web search syntax: quoted phrases, OR, and -excluded words.
+func searchScope(table, q string) func(*gorm.DB) *gorm.DB {
+	document := database.SearchDocuments[table]
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(document+" @@ websearch_to_tsquery('simple', ?)", q).
+			Order(gorm.Expr("ts_rank("+document+", websearch_to_tsquery('simple', ?)) desc", q))
+	}
+}
+
+// ReindexPDFs re-extracts every linked PDF in the background, for records
+// saved before extraction existed or whose remote file has changed
+func ReindexPDFs(w http.ResponseWriter, r *http.Request) {
+	go func() {
+		var posts []models.BlogPost
+		database.DB.Where("pdf_url <> ''").Find(&posts)
+		for i := range posts {
+			if err := storePostPDF(&posts[i]); err != nil {
+				log.Printf("pdf: indexing post %d: %v", posts[i].ID, err)
+			}
+		}
+
+		var resources []models.Resource
+		database.DB.Where("file_url <> ''").Find(&resources)
+		for i := range resources {
+			if err := storeResourcePDF(&resources[i]); err != nil {
+				log.Printf("pdf: indexing resource %d: %v", resources[i].ID, err)
+			}
+		}
+		invalidateRelated()
+		log.Printf("pdf: reindexed %d posts and %d resources", len(posts), len(resources))
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Reindexing started"})
+}
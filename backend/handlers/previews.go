@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/middleware"
+	"yiaga-backend/models"
+)
+
+// Publication states shared by posts, initiatives and announcements
+const (
+	statusDraft     = "draft"
+	statusPublished = "published"
+)
+
+// maxPreviewLifetime bounds how long a shared draft link stays usable
+const maxPreviewLifetime = 30 * 24 * time.Hour
+
+// publishedPosts limits a query to posts readers may see: not drafts and not
+// scheduled for later
+func publishedPosts(db *gorm.DB) *gorm.DB {
+	return db.Where("status = ? AND published_at <= ?", statusPublished, time.Now())
+}
+
+// publishedInitiatives limits a query to initiatives readers may see
+func publishedInitiatives(db *gorm.DB) *gorm.DB {
+	return db.Where("publish_status = ?", statusPublished)
+}
+
+// validPublishStatus accepts the states an editor can set; empty keeps the default
+func validPublishStatus(s string) bool {
+	return s == "" || s == statusDraft || s == statusPublished
+}
+
+// isEditor reports whether a request on a public route carries a valid CMS
+// token, in which case drafts are included
+func isEditor(r *http.Request) bool {
+	return middleware.OptionalClaims(r) != nil
+}
+
+func previewSecret() []byte {
+	if s := os.Getenv("PREVIEW_SECRET"); s != "" {
+		return []byte(s)
+	}
+	return middleware.JwtKey
+}
+
+// signPreview builds the token string for a stored PreviewToken: its ID and
+// an HMAC binding it to the record and expiry, so tokens cannot be forged or
+// moved to another record
+func signPreview(t models.PreviewToken) string {
+	mac := hmac.New(sha256.New, previewSecret())
+	fmt.Fprintf(mac, "preview:%d:%s:%d:%d", t.ID, t.ContentType, t.ContentID, t.ExpiresAt.Unix())
+	return fmt.Sprintf("%d.%s", t.ID, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18]))
+}
+
+// previewAllowed checks the ?preview= token of a request against the record
+// being served, and records its use. Preview responses must not be cached or
+// indexed.
+func previewAllowed(w http.ResponseWriter, r *http.Request, contentType string, id uint) bool {
+	token := r.URL.Query().Get("preview")
+	idPart, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	tokenID, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	var stored models.PreviewToken
+	if err := database.DB.First(&stored, tokenID).Error; err != nil {
+		return false
+	}
+	if !hmac.Equal([]byte(signPreview(stored)), []byte(token)) ||
+		stored.ContentType != contentType || stored.ContentID != id ||
+		stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return false
+	}
+
+	database.DB.Model(&stored).UpdateColumns(map[string]interface{}{
+		"last_used_at": time.Now(),
+		"uses":         gorm.Expr("uses + 1"),
+	})
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	return true
+}
+
+// previewRecord loads the record a token is issued for and the page to open
+// it on
+func previewRecord(r *http.Request, contentType string, id uint) (string, error) {
+	switch contentType {
+	case "blog":
+		var post models.BlogPost
+		if err := database.DB.First(&post, id).Error; err != nil {
+			return "", err
+		}
+		return postURL(post), nil
+	case "initiative":
+		var initiative models.Initiative
+		if err := database.DB.First(&initiative, id).Error; err != nil {
+			return "", err
+		}
+		return siteURL() + "/initiatives/" + initiative.Slug, nil
+	case "announcement":
+		var announcement models.Announcement
+		if err := database.DB.First(&announcement, id).Error; err != nil {
+			return "", err
+		}
+		// Announcements have no page of their own
+		return apiURL(r, fmt.Sprintf("/announcements/%d", id)), nil
+	}
+	return "", fmt.Errorf("type must be blog, initiative or announcement")
+}
+
+type previewLink struct {
+	models.PreviewToken
+	Token  string `json:"token"`
+	URL    string `json:"url"`
+	Active bool   `json:"active"`
+}
+
+func newPreviewLink(r *http.Request, t models.PreviewToken) previewLink {
+	link := previewLink{PreviewToken: t, Token: signPreview(t)}
+	link.Active = t.RevokedAt == nil && time.Now().Before(t.ExpiresAt)
+	if page, err := previewRecord(r, t.ContentType, t.ContentID); err == nil {
+		link.URL = page + "?preview=" + link.Token
+	}
+	return link
+}
+
+// --- Admin ---
+
+// CreatePreview issues a preview link:
+// {"type": "blog", "id": 12, "expires_in_hours": 72, "note": "Funder review"}
+func CreatePreview(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Type           string `json:"type"`
+		ID             uint   `json:"id"`
+		ExpiresInHours int    `json:"expires_in_hours"`
+		Note           string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := previewRecord(r, input.Type, input.ID); err != nil {
+		http.Error(w, "Content not found: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lifetime := time.Duration(input.ExpiresInHours) * time.Hour
+	if lifetime <= 0 {
+		lifetime = 72 * time.Hour
+	}
+	if lifetime > maxPreviewLifetime {
+		http.Error(w, "Preview links can last at most 30 days", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := currentEditor(r)
+	token := models.PreviewToken{
+		ContentType: input.Type,
+		ContentID:   input.ID,
+		Note:        input.Note,
+		CreatedBy:   userID,
+		// Whole seconds, as signed
+		ExpiresAt: time.Now().Add(lifetime).Truncate(time.Second),
+	}
+	if err := database.DB.Create(&token).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, newPreviewLink(r, token))
+}
+
+// GetPreviews lists the caller's preview links, newest first. Filter with
+// ?type=blog&id=12; admins can pass ?all=true to see everyone's.
+func GetPreviews(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := database.DB.Order("created_at desc")
+	claims := middleware.ClaimsFromContext(r.Context())
+	if q.Get("all") != "true" || claims == nil || claims.Role != "admin" {
+		userID, _ := currentEditor(r)
+		query = query.Where("created_by = ?", userID)
+	}
+	if t := q.Get("type"); t != "" {
+		query = query.Where("content_type = ?", t)
+	}
+	if id := q.Get("id"); id != "" {
+		query = query.Where("content_id = ?", id)
+	}
+
+	var tokens []models.PreviewToken
+	if err := query.Find(&tokens).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	links := make([]previewLink, len(tokens))
+	for i, t := range tokens {
+		links[i] = newPreviewLink(r, t)
+	}
+	respondJSON(w, links)
+}
+
+// RevokePreview stops a preview link working. Editors can revoke their own
+// links, admins anyone's.
+func RevokePreview(w http.ResponseWriter, r *http.Request) {
+	var token models.PreviewToken
+	if err := database.DB.First(&token, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Preview link not found", http.StatusNotFound)
+		return
+	}
+	userID, _ := currentEditor(r)
+	claims := middleware.ClaimsFromContext(r.Context())
+	if token.CreatedBy != userID && (claims == nil || claims.Role != "admin") {
+		http.Error(w, "Only the issuer or an admin can revoke this link", http.StatusForbidden)
+		return
+	}
+
+	if token.RevokedAt == nil {
+		now := time.Now()
+		token.RevokedAt = &now
+		if err := database.DB.Model(&token).Update("revoked_at", now).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	respondJSON(w, newPreviewLink(r, token))
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+	"yiaga-backend/related"
+)
+
+// The index is rebuilt lazily after content changes, and at least every
+// relatedTTL so that recency scores and out-of-band edits catch up.
+const relatedTTL = 30 * time.Minute
+
+var relatedCache struct {
+	sync.Mutex
+	index   *related.Index
+	built   time.Time
+	results map[string][]related.Result
+}
+
+// invalidateRelated drops the cached index; call it whenever posts,
+// initiatives or resources are created, edited or deleted.
+func invalidateRelated() {
+	relatedCache.Lock()
+	relatedCache.index = nil
+	relatedCache.results = nil
+	relatedCache.Unlock()
+}
+
+func similarContent(kind string, id uint, wantKind string, limit int) ([]related.Result, error) {
+	relatedCache.Lock()
+	defer relatedCache.Unlock()
+
+	if relatedCache.index == nil || time.Since(relatedCache.built) > relatedTTL {
+		docs, err := relatedDocuments()
+		if err != nil {
+			return nil, err
+		}
+		relatedCache.index = related.NewIndex(docs)
+		relatedCache.built = time.Now()
+		relatedCache.results = map[string][]related.Result{}
+	}
+
+	cacheKey := fmt.Sprintf("%s:%d:%s:%d", kind, id, wantKind, limit)
+	if results, ok := relatedCache.results[cacheKey]; ok {
+		return results, nil
+	}
+	results := relatedCache.index.Similar(kind, id, wantKind, limit)
+	relatedCache.results[cacheKey] = results
+	return results, nil
+}
+
+func relatedDocuments() ([]related.Document, error) {
+	var docs []related.Document
+
+	var posts []models.BlogPost
+	if err := database.DB.Scopes(publishedPosts).Find(&posts).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range posts {
+		docs = append(docs, related.Document{
+			Kind: "post", ID: p.ID, Title: p.Title, Text: p.Excerpt + " " + p.Content,
+			Category: p.Category, Tags: p.Tags, Date: p.PublishedAt,
+		})
+	}
+
+	var initiatives []models.Initiative
+	if err := database.DB.Scopes(publishedInitiatives).Find(&initiatives).Error; err != nil {
+		return nil, err
+	}
+	for _, i := range initiatives {
+		docs = append(docs, related.Document{
+			Kind: "initiative", ID: i.ID, Title: i.Title,
+			Text:     strings.Join(append([]string{i.Description, i.FullDescription, i.Content}, i.Activities...), " "),
+			Category: i.Category, Date: i.UpdatedAt,
+		})
+	}
+
+	var resources []models.Resource
+	if err := database.DB.Find(&resources).Error; err != nil {
+		return nil, err
+	}
+	for _, res := range resources {
+		docs = append(docs, related.Document{
+			Kind: "resource", ID: res.ID, Title: res.Title, Text: res.Description,
+			Category: res.Category, Date: res.PublishedAt,
+		})
+	}
+	return docs, nil
+}
+
+// GetRelatedForBlog serves /blogs/{slug}/related: "read next" posts plus
+// matching resources and initiatives. Drafts and scheduled posts are not
+// found, so the endpoint does not reveal that they exist.
+func GetRelatedForBlog(w http.ResponseWriter, r *http.Request) {
+	var post models.BlogPost
+	if err := database.DB.Scopes(publishedPosts).Where("slug = ?", chi.URLParam(r, "slug")).First(&post).Error; err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	respondRelated(w, r, "post", post.ID)
+}
+
+// GetRelatedForInitiative serves /initiatives/{slug}/related
+func GetRelatedForInitiative(w http.ResponseWriter, r *http.Request) {
+	var initiative models.Initiative
+	if err := database.DB.Scopes(publishedInitiatives).Where("slug = ?", chi.URLParam(r, "slug")).First(&initiative).Error; err != nil {
+		http.Error(w, "Initiative not found", http.StatusNotFound)
+		return
+	}
+	respondRelated(w, r, "initiative", initiative.ID)
+}
+
+func respondRelated(w http.ResponseWriter, r *http.Request, kind string, id uint) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 20 {
+		limit = 4
+	}
+
+	response := map[string]interface{}{}
+	for _, want := range []string{"post", "initiative", "resource"} {
+		results, err := similarContent(kind, id, want, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ids := make([]uint, len(results))
+		for i, res := range results {
+			ids[i] = res.ID
+		}
+
+		switch want {
+		case "post":
+			var posts []models.BlogPost
+			if len(ids) > 0 {
+				err = database.DB.Where("id IN ?", ids).Find(&posts).Error
+			}
+			posts = orderByIDs(posts, ids, func(p models.BlogPost) uint { return p.ID })
+			for i := range posts {
+				localize(w, r, &posts[i])
+			}
+			response["posts"] = posts
+		case "initiative":
+			var initiatives []models.Initiative
+			if len(ids) > 0 {
+				err = database.DB.Where("id IN ?", ids).Find(&initiatives).Error
+			}
+			initiatives = orderByIDs(initiatives, ids, func(i models.Initiative) uint { return i.ID })
+			for i := range initiatives {
+				localize(w, r, &initiatives[i])
+			}
+			response["initiatives"] = initiatives
+		case "resource":
+			var resources []models.Resource
+			if len(ids) > 0 {
+				err = database.DB.Where("id IN ?", ids).Find(&resources).Error
+			}
+			response["resources"] = orderByIDs(resources, ids, func(res models.Resource) uint { return res.ID })
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	respondJSON(w, response)
+}
+
+// orderByIDs puts rows back into ranking order after an IN query
+func orderByIDs[T any](rows []T, ids []uint, idOf func(T) uint) []T {
+	byID := make(map[uint]T, len(rows))
+	for _, row := range rows {
+		byID[idOf(row)] = row
+	}
+	ordered := make([]T, 0, len(rows))
+	for _, id := range ids {
+		if row, ok := byID[id]; ok {
+			ordered = append(ordered, row)
+		}
+	}
+	return ordered
+}
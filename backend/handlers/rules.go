@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+	"yiaga-backend/moderation"
+)
+
+// applyCommentRules runs the moderation rules over a new comment, masking
+// text in place. Comments are moderated before they appear anyway, so a hold
+// only records why; a reject rejects it outright.
+func applyCommentRules(c *models.Comment) error {
+	result, err := moderation.Check(moderation.TargetComment,
+		moderation.Field{Name: "author", Value: &c.Author},
+		moderation.Field{Name: "content", Value: &c.Content},
+	)
+	if err != nil {
+		return err
+	}
+	c.ModerationMatches = result.Matches
+	if result.Reject {
+		c.Status = "rejected"
+		c.AutoRejected = true
+	}
+	return nil
+}
+
+// applyContactRules runs the moderation rules over a contact form message.
+// Held and rejected messages are kept, out of the "new" inbox.
+func applyContactRules(m *models.ContactMessage) error {
+	result, err := moderation.Check(moderation.TargetContact,
+		moderation.Field{Name: "name", Value: &m.Name},
+		moderation.Field{Name: "subject", Value: &m.Subject},
+		moderation.Field{Name: "message", Value: &m.Message},
+	)
+	if err != nil {
+		return err
+	}
+	m.ModerationMatches = result.Matches
+	switch {
+	case result.Reject:
+		m.Status = "rejected"
+	case result.Hold:
+		m.Status = "held"
+	}
+	return nil
+}
+
+// --- Admin ---
+
+func GetModerationRules(w http.ResponseWriter, r *http.Request) {
+	var rules []models.ModerationRule
+	if err := database.DB.Order("id").Find(&rules).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, rules)
+}
+
+// ruleInput is a rule as sent by the CMS. A missing is_active makes a new rule
+// active and leaves an existing one as it is.
+type ruleInput struct {
+	models.ModerationRule
+	IsActive *bool `json:"is_active"` // Tells a missing value from false
+}
+
+func CreateModerationRule(w http.ResponseWriter, r *http.Request) {
+	var input ruleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule := input.ModerationRule
+	rule.ID = 0
+	rule.IsActive = input.IsActive == nil || *input.IsActive
+	if err := moderation.Validate(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := database.DB.Create(&rule).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, rule)
+}
+
+func UpdateModerationRule(w http.ResponseWriter, r *http.Request) {
+	var rule models.ModerationRule
+	if err := database.DB.First(&rule, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+	var input ruleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule.Name = input.Name
+	rule.AppliesTo = input.AppliesTo
+	rule.Pattern = input.Pattern
+	rule.Keywords = input.Keywords
+	rule.MaxLinks = input.MaxLinks
+	rule.Action = input.Action
+	if input.IsActive != nil {
+		rule.IsActive = *input.IsActive
+	}
+	if err := moderation.Validate(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := database.DB.Save(&rule).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, rule)
+}
+
+func DeleteModerationRule(w http.ResponseWriter, r *http.Request) {
+	if err := database.DB.Delete(&models.ModerationRule{}, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, map[string]string{"message": "Deleted"})
+}
+
+// TestModerationRules shows what the rules would do to a sample text without
+// saving anything: {"target": "comment", "text": "..."}. Pass "rule" to try
+// an unsaved rule on its own.
+func TestModerationRules(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Target string                 `json:"target"`
+		Text   string                 `json:"text"`
+		Rule   *models.ModerationRule `json:"rule"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if input.Target == "" {
+		input.Target = moderation.TargetComment
+	}
+
+	text := input.Text
+	field := moderation.Field{Name: "text", Value: &text}
+	var result moderation.Result
+	if input.Rule != nil {
+		if err := moderation.Validate(input.Rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result = moderation.Apply([]models.ModerationRule{*input.Rule}, input.Target, field)
+	} else {
+		var err error
+		if result, err = moderation.Check(input.Target, field); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if result.Matches == nil {
+		result.Matches = []models.RuleMatch{}
+	}
+	respondJSON(w, map[string]interface{}{
+		"matches": result.Matches,
+		"hold":    result.Hold,
+		"reject":  result.Reject,
+		"text":    text,
+	})
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+	"yiaga-backend/sitemap"
+)
+
+// sitemapSource describes one per-type sitemap. Only types the SPA has a
+// detail route for belong here; jobs and resources are listed on their index
+// pages, which sitemapPages covers.
+type sitemapSource struct {
+	Name       string
+	Model      interface{}
+	Scope      func(*gorm.DB) *gorm.DB
+	PathPrefix string
+	ChangeFreq string
+	Priority   float64
+}
+
+var sitemapSources = []sitemapSource{
+	{
+		Name:       "blog",
+		Model:      &models.BlogPost{},
+		Scope:      func(db *gorm.DB) *gorm.DB { return db.Scopes(publishedPosts).Where("type = ?", "blog") },
+		PathPrefix: "/blog/",
+		ChangeFreq: "monthly",
+		Priority:   0.7,
+	},
+	{
+		Name:       "news",
+		Model:      &models.BlogPost{},
+		Scope:      func(db *gorm.DB) *gorm.DB { return db.Scopes(publishedPosts).Where("type = ?", "news") },
+		PathPrefix: "/news/",
+		ChangeFreq: "monthly",
+		Priority:   0.7,
+	},
+	{
+		Name:       "initiatives",
+		Model:      &models.Initiative{},
+		Scope:      publishedInitiatives,
+		PathPrefix: "/initiatives/",
+		ChangeFreq: "monthly",
+		Priority:   0.8,
+	},
+}
+
+// Top level SPA routes that are always listed
+var sitemapPages = []string{
+	"/", "/about", "/governance", "/democracy", "/initiatives", "/resources",
+	"/news", "/blog", "/careers", "/contact", "/programmes",
+}
+
+type sitemapRow struct {
+	ID        uint
+	Slug      string
+	UpdatedAt time.Time
+}
+
+func (s sitemapSource) query() *gorm.DB {
+	q := database.DB.Model(s.Model).Where("slug <> ''")
+	if s.Scope != nil {
+		q = s.Scope(q)
+	}
+	return q
+}
+
+// GetSitemapIndex serves /sitemap.xml, listing every chunk of every type
+func GetSitemapIndex(w http.ResponseWriter, r *http.Request) {
+	refs := []sitemap.Ref{{Loc: sitemapBaseURL() + "/sitemaps/pages-1.xml"}}
+
+	for _, src := range sitemapSources {
+		var total int64
+		if err := src.query().Count(&total).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for page := 1; page <= sitemap.Chunks(total); page++ {
+			chunk := src.query().Select("updated_at").Order("id").
+				Limit(sitemap.MaxURLs).Offset((page - 1) * sitemap.MaxURLs)
+			var lastMod sql.NullTime
+			if err := database.DB.Table("(?) AS chunk", chunk).Select("MAX(updated_at)").Scan(&lastMod).Error; err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			refs = append(refs, sitemap.Ref{
+				Loc:     fmt.Sprintf("%s/sitemaps/%s-%d.xml", sitemapBaseURL(), src.Name, page),
+				LastMod: lastMod.Time,
+			})
+		}
+	}
+
+	body, err := sitemap.Index(refs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeXML(w, body)
+}
+
+// GetSitemap serves /sitemaps/{name}, where name is "<type>-<page>.xml"
+func GetSitemap(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSuffix(chi.URLParam(r, "name"), ".xml")
+	sep := strings.LastIndex(name, "-")
+	if sep < 0 {
+		http.Error(w, "Sitemap not found", http.StatusNotFound)
+		return
+	}
+	kind := name[:sep]
+	page, err := strconv.Atoi(name[sep+1:])
+	if err != nil || page < 1 {
+		http.Error(w, "Sitemap not found", http.StatusNotFound)
+		return
+	}
+
+	if kind == "pages" {
+		if page != 1 {
+			http.Error(w, "Sitemap not found", http.StatusNotFound)
+			return
+		}
+		var urls []sitemap.URL
+		for _, p := range sitemapPages {
+			urls = append(urls, sitemap.URL{Loc: siteURL() + p, ChangeFreq: "weekly", Priority: 0.9})
+		}
+		body, _ := sitemap.URLSet(urls)
+		writeXML(w, body)
+		return
+	}
+
+	var src *sitemapSource
+	for i := range sitemapSources {
+		if sitemapSources[i].Name == kind {
+			src = &sitemapSources[i]
+		}
+	}
+	if src == nil {
+		http.Error(w, "Sitemap not found", http.StatusNotFound)
+		return
+	}
+
+	var rows []sitemapRow
+	err = src.query().Select("id, slug, updated_at").Order("id").
+		Limit(sitemap.MaxURLs).Offset((page - 1) * sitemap.MaxURLs).
+		Scan(&rows).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(rows) == 0 && page > 1 {
+		http.Error(w, "Sitemap not found", http.StatusNotFound)
+		return
+	}
+
+	var lastMod time.Time
+	urls := make([]sitemap.URL, 0, len(rows))
+	for _, row := range rows {
+		urls = append(urls, sitemap.URL{
+			Loc:        siteURL() + src.PathPrefix + row.Slug,
+			LastMod:    row.UpdatedAt,
+			ChangeFreq: src.ChangeFreq,
+			Priority:   src.Priority,
+		})
+		if row.UpdatedAt.After(lastMod) {
+			lastMod = row.UpdatedAt
+		}
+	}
+
+	etag := fmt.Sprintf(`"%s-%d-%d-%d"`, kind, page, len(rows), lastMod.UnixNano())
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if notModified(w, r, etag, lastMod) {
+		return
+	}
+
+	body, err := sitemap.URLSet(urls)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeXML(w, body)
+}
+
+// GetRobotsTxt serves a robots.txt that points crawlers at the sitemap index.
+//
+// ROBOTS_DISALLOW is a comma separated list of paths to keep out of search
+// engines (defaults to the admin area) and ROBOTS_ALLOW_INDEXING=false blocks
+// everything, which is what staging deployments want.
+func GetRobotsTxt(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+
+	if os.Getenv("ROBOTS_ALLOW_INDEXING") == "false" {
+		b.WriteString("Disallow: /\n")
+	} else {
+		disallow := os.Getenv("ROBOTS_DISALLOW")
+		if disallow == "" {
+			disallow = "/admin"
+		}
+		for _, path := range strings.Split(disallow, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				b.WriteString("Disallow: " + path + "\n")
+			}
+		}
+		b.WriteString("\nSitemap: " + sitemapBaseURL() + "/sitemap.xml\n")
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write([]byte(b.String()))
+}
+
+// sitemapBaseURL is where the sitemap files themselves are served from.
+// SITEMAP_BASE_URL lets them live on the API host while page URLs point at the site.
+func sitemapBaseURL() string {
+	if base := os.Getenv("SITEMAP_BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	return siteURL()
+}
+
+func writeXML(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(body)
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+	"yiaga-backend/slug"
+)
+
+var errSlugTaken = errors.New("slug is already in use")
+
+// sluggedModels maps the ?type= values accepted by CheckSlug to their tables
+var sluggedModels = map[string]func() interface{}{
+	"blog":       func() interface{} { return &models.BlogPost{} },
+	"initiative": func() interface{} { return &models.Initiative{} },
+	"job":        func() interface{} { return &models.Job{} },
+	"resource":   func() interface{} { return &models.Resource{} },
+}
+
+// uniqueSlug returns base, or base with the lowest free numeric suffix
+// ("elections-2", "elections-3", ...). Soft deleted rows still hold their slug
+// in the unique index, so they are counted as taken.
+func uniqueSlug(model interface{}, base string, excludeID uint) (string, error) {
+	if base == "" {
+		base = "untitled"
+	}
+
+	var taken []string
+	query := database.DB.Unscoped().Model(model).
+		Where("slug = ? OR slug LIKE ?", base, base+"-%")
+	if excludeID != 0 {
+		query = query.Where("id <> ?", excludeID)
+	}
+	if err := query.Pluck("slug", &taken).Error; err != nil {
+		return "", err
+	}
+
+	used := make(map[string]bool, len(taken))
+	for _, s := range taken {
+		used[s] = true
+	}
+	if !used[base] {
+		return base, nil
+	}
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s-%d", base, n)
+		if !used[candidate] {
+			return candidate, nil
+		}
+	}
+}
+
+// assignSlug fills in the slug for a record being saved. An empty requested
+// slug is generated from the title; an editor chosen one must be well formed
+// and free, otherwise errSlugTaken or a slug validation error is returned.
+func assignSlug(model interface{}, requested, title string, id uint) (string, error) {
+	if requested == "" {
+		base := slug.Make(title)
+		if len(base) > slug.MaxLength-4 {
+			// Leave room for a numeric suffix
+			base = strings.Trim(base[:slug.MaxLength-4], "-")
+		}
+		return uniqueSlug(model, base, id)
+	}
+
+	if err := slug.Validate(requested); err != nil {
+		return "", err
+	}
+	var count int64
+	query := database.DB.Unscoped().Model(model).Where("slug = ?", requested)
+	if id != 0 {
+		query = query.Where("id <> ?", id)
+	}
+	if err := query.Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "", errSlugTaken
+	}
+	return requested, nil
+}
+
+// slugAttempts bounds how often createWithSlug regenerates a slug that was
+// taken by a concurrent save
+const slugAttempts = 5
+
+// createWithSlug assigns record's slug (held in slugField) as assignSlug does
+// and inserts it. The check and the insert can race with another save of the
+// same title: a generated slug is then worked out again and the insert
+// retried, while an editor chosen one fails with errSlugTaken.
+func createWithSlug(model, record interface{}, slugField *string, title string) error {
+	requested := *slugField
+	for attempt := 1; ; attempt++ {
+		s, err := assignSlug(model, requested, title, 0)
+		if err != nil {
+			return err
+		}
+		*slugField = s
+		err = database.DB.Create(record).Error
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return err
+		}
+		if requested != "" || attempt == slugAttempts {
+			return errSlugTaken
+		}
+	}
+}
+
+// slugConflict turns a unique index violation from saving a record whose
+// slug was checked earlier into errSlugTaken
+func slugConflict(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return errSlugTaken
+	}
+	return err
+}
+
+// respondSlugError maps assignSlug and createWithSlug failures onto HTTP responses
+func respondSlugError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errSlugTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, slug.ErrEmpty), errors.Is(err, slug.ErrTooLong), errors.Is(err, slug.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// CheckSlug lets editors validate a slug before saving:
+// GET /slugs/check?type=blog&slug=my-post[&id=12] or ?type=blog&title=My Post
+func CheckSlug(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	newModel, ok := sluggedModels[q.Get("type")]
+	if !ok {
+		http.Error(w, "type must be one of blog, initiative, job or resource", http.StatusBadRequest)
+		return
+	}
+	id, _ := strconv.ParseUint(q.Get("id"), 10, 64)
+
+	requested := q.Get("slug")
+	base := requested
+	if base == "" {
+		base = slug.Make(q.Get("title"))
+	}
+
+	response := map[string]interface{}{
+		"slug":      requested,
+		"valid":     true,
+		"available": true,
+	}
+
+	if requested != "" {
+		if err := slug.Validate(requested); err != nil {
+			response["valid"] = false
+			response["available"] = false
+			response["error"] = err.Error()
+			base = slug.Make(requested)
+		} else if _, err := assignSlug(newModel(), requested, "", uint(id)); err != nil {
+			if !errors.Is(err, errSlugTaken) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			response["available"] = false
+			response["error"] = err.Error()
+		}
+	}
+
+	suggestion, err := uniqueSlug(newModel(), base, uint(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response["suggestion"] = suggestion
+	respondJSON(w, response)
+}
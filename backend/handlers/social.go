@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html"
+	"html/template"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+)
+
+// The React app sets its <head> on the client, which link unfurlers never run.
+// These handlers answer /blog/{slug}, /news/{slug} and /initiatives/{slug}: bots
+// get a small server rendered page carrying the metadata, people are sent on
+// to the SPA.
+
+var crawlerAgents = []string{
+	"facebookexternalhit", "facebot", "twitterbot", "whatsapp", "linkedinbot",
+	"slackbot", "telegrambot", "discordbot", "skypeuripreview", "pinterest",
+	"redditbot", "applebot", "googlebot", "bingbot", "duckduckbot", "yandex",
+	"embedly", "iframely", "vkshare", "mastodon", "bluesky",
+}
+
+// IsCrawler reports whether a User-Agent belongs to a search engine or link preview bot
+func IsCrawler(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, bot := range crawlerAgents {
+		if strings.Contains(ua, bot) {
+			return true
+		}
+	}
+	return false
+}
+
+type socialMeta struct {
+	Type        string // og:type
+	Title       string
+	Description string
+	URL         string
+	Image       string
+	Author      string
+	Section     string
+	Tags        []string
+	Published   time.Time
+	Modified    time.Time
+	SiteName    string
+	TwitterSite string
+	JSONLD      template.JS
+}
+
+var socialTemplate = template.Must(template.New("social").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} | {{.SiteName}}</title>
+<meta name="description" content="{{.Description}}">
+<link rel="canonical" href="{{.URL}}">
+<meta property="og:site_name" content="{{.SiteName}}">
+<meta property="og:type" content="{{.Type}}">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.URL}}">
+{{- if .Image}}
+<meta property="og:image" content="{{.Image}}">
+<meta property="og:image:alt" content="{{.Title}}">
+{{- end}}
+{{- if not .Published.IsZero}}
+<meta property="article:published_time" content="{{.Published.UTC.Format "2006-01-02T15:04:05Z07:00"}}">
+{{- end}}
+{{- if not .Modified.IsZero}}
+<meta property="article:modified_time" content="{{.Modified.UTC.Format "2006-01-02T15:04:05Z07:00"}}">
+{{- end}}
+{{- if .Author}}
+<meta property="article:author" content="{{.Author}}">
+{{- end}}
+{{- if .Section}}
+<meta property="article:section" content="{{.Section}}">
+{{- end}}
+{{- range .Tags}}
+<meta property="article:tag" content="{{.}}">
+{{- end}}
+<meta name="twitter:card" content="{{if .Image}}summary_large_image{{else}}summary{{end}}">
+<meta name="twitter:site" content="{{.TwitterSite}}">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+{{- if .Image}}
+<meta name="twitter:image" content="{{.Image}}">
+{{- end}}
+<script type="application/ld+json">{{.JSONLD}}</script>
+</head>
+<body>
+<article>
+<h1>{{.Title}}</h1>
+<p>{{.Description}}</p>
+<p><a href="{{.URL}}">Read on {{.SiteName}}</a></p>
+</article>
+</body>
+</html>
+`))
+
+// GetPostPreview serves /blog/{slug} and /news/{slug}. Each post is only
+// found under the prefix of its own type, so it has a single canonical URL.
+func GetPostPreview(w http.ResponseWriter, r *http.Request) {
+	if !IsCrawler(r.UserAgent()) {
+		http.Redirect(w, r, siteURL()+r.URL.RequestURI(), http.StatusFound)
+		return
+	}
+
+	postType := "blog"
+	if strings.HasPrefix(r.URL.Path, "/news/") {
+		postType = "news"
+	}
+
+	var post models.BlogPost
+	if err := database.DB.Scopes(publishedPosts).Where("slug = ? AND type = ?", chi.URLParam(r, "slug"), postType).First(&post).Error; err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	description := post.Excerpt
+	if description == "" {
+		description = plainText(post.Content, 200)
+	}
+	published := post.PublishedAt
+	if published.IsZero() {
+		published = post.CreatedAt
+	}
+
+	meta := socialMeta{
+		Type:        "article",
+		Title:       post.Title,
+		Description: description,
+		URL:         postURL(post),
+		Image:       absoluteURL(post.Image),
+		Author:      post.Author,
+		Section:     post.Category,
+		Tags:        post.Tags,
+		Published:   published,
+		Modified:    post.UpdatedAt,
+	}
+	schemaType := "BlogPosting"
+	if post.Type == "news" {
+		schemaType = "NewsArticle"
+	}
+	renderSocialPreview(w, meta, schemaType)
+}
+
+// GetInitiativePreview serves /initiatives/{slug}
+func GetInitiativePreview(w http.ResponseWriter, r *http.Request) {
+	if !IsCrawler(r.UserAgent()) {
+		http.Redirect(w, r, siteURL()+r.URL.RequestURI(), http.StatusFound)
+		return
+	}
+
+	var initiative models.Initiative
+	if err := database.DB.Scopes(publishedInitiatives).Where("slug = ?", chi.URLParam(r, "slug")).First(&initiative).Error; err != nil {
+		http.Error(w, "Initiative not found", http.StatusNotFound)
+		return
+	}
+
+	description := initiative.Description
+	if description == "" {
+		description = plainText(initiative.Content, 200)
+	}
+
+	meta := socialMeta{
+		Type:        "article",
+		Title:       initiative.Title,
+		Description: plainText(description, 300),
+		URL:         siteURL() + "/initiatives/" + initiative.Slug,
+		Image:       absoluteURL(initiative.Image),
+		Section:     initiative.Category,
+		Published:   initiative.CreatedAt,
+		Modified:    initiative.UpdatedAt,
+	}
+	renderSocialPreview(w, meta, "Article")
+}
+
+func renderSocialPreview(w http.ResponseWriter, meta socialMeta, schemaType string) {
+	meta.SiteName = "Yiaga Africa"
+	meta.TwitterSite = os.Getenv("TWITTER_HANDLE")
+	if meta.TwitterSite == "" {
+		meta.TwitterSite = "@YiagaAfrica"
+	}
+
+	publisher := map[string]interface{}{
+		"@type": "Organization",
+		"name":  meta.SiteName,
+		"url":   siteURL(),
+		"logo":  map[string]string{"@type": "ImageObject", "url": absoluteURL("/logo.png")},
+	}
+	article := map[string]interface{}{
+		"@context":         "https://schema.org",
+		"@type":            schemaType,
+		"headline":         meta.Title,
+		"description":      meta.Description,
+		"url":              meta.URL,
+		"mainEntityOfPage": map[string]string{"@type": "WebPage", "@id": meta.URL},
+		"publisher":        publisher,
+		"datePublished":    meta.Published.UTC().Format(time.RFC3339),
+		"dateModified":     meta.Modified.UTC().Format(time.RFC3339),
+	}
+	if meta.Image != "" {
+		article["image"] = []string{meta.Image}
+	}
+	if meta.Author != "" {
+		article["author"] = map[string]string{"@type": "Person", "name": meta.Author}
+	} else {
+		article["author"] = publisher
+	}
+	if meta.Section != "" {
+		article["articleSection"] = meta.Section
+	}
+	if len(meta.Tags) > 0 {
+		article["keywords"] = strings.Join(meta.Tags, ", ")
+	}
+
+	// json.Marshal escapes <, > and &, so the payload cannot close the script tag
+	ld, err := json.Marshal(article)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	meta.JSONLD = template.JS(ld)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	w.Header().Set("Vary", "User-Agent")
+	if err := socialTemplate.Execute(w, meta); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var (
+	tagPattern        = regexp.MustCompile(`(?s)<[^>]*>`)
+	whitespacePattern = regexp.MustCompile(`\s+`)
+)
+
+// plainText strips markup from rich text and truncates it on a word boundary
+func plainText(s string, max int) string {
+	s = html.UnescapeString(tagPattern.ReplaceAllString(s, " "))
+	s = strings.TrimSpace(whitespacePattern.ReplaceAllString(s, " "))
+	if len([]rune(s)) <= max {
+		return s
+	}
+	runes := []rune(s)[:max]
+	if cut := strings.LastIndex(string(runes), " "); cut > max/2 {
+		return string(runes)[:cut] + "…"
+	}
+	return string(runes) + "…"
+}
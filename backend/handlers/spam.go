@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm/clause"
+
+	"yiaga-backend/database"
+	"yiaga-backend/middleware"
+	"yiaga-backend/models"
+	"yiaga-backend/spam"
+)
+
+// Comment form defences, in the order CreateComment applies them:
+//
+//  1. A honeypot field ("website") hidden from people but filled in by bots.
+//  2. A signed form token from GET /comments/challenge; submissions sooner
+//     than COMMENT_MIN_SECONDS after it was issued are refused, as are
+//     reused or day-old tokens.
+//  3. When COMMENT_POW_BITS is set, a proof of work: a nonce such that
+//     sha256(token + ":" + nonce) starts with that many zero bits.
+//  4. Per-IP and per-email limits over the last hour.
+//  5. A naive Bayes classifier trained on moderators' past decisions, which
+//     rejects near certain spam and orders the rest of the queue.
+
+const formTokenMaxAge = 24 * time.Hour
+
+func commentSecret() []byte {
+	if s := os.Getenv("COMMENT_SECRET"); s != "" {
+		return []byte(s)
+	}
+	return middleware.JwtKey
+}
+
+func envInt(name string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n >= 0 {
+		return n
+	}
+	return fallback
+}
+
+// commentMinDelay is how long a person needs at least to write a comment
+func commentMinDelay() time.Duration {
+	return time.Duration(envInt("COMMENT_MIN_SECONDS", 5)) * time.Second
+}
+
+// powBits is the proof of work difficulty; 0 turns it off. 18 bits takes a
+// phone around a second.
+func powBits() int {
+	return envInt("COMMENT_POW_BITS", 0)
+}
+
+// formTarget names a comment target in form tokens; blog posts are a bare ID,
+// as before other targets existed
+func formTarget(targetType string, targetID uint) string {
+	if targetType == targetBlog {
+		return strconv.FormatUint(uint64(targetID), 10)
+	}
+	return fmt.Sprintf("%s:%d", targetType, targetID)
+}
+
+// signFormToken binds an issue time and random nonce to a comment target
+func signFormToken(target string, issued time.Time, nonce string) string {
+	payload := fmt.Sprintf("%s.%d.%s", target, issued.Unix(), nonce)
+	mac := hmac.New(sha256.New, commentSecret())
+	mac.Write([]byte("comment:" + payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18])
+}
+
+// GetCommentChallenge issues the form token (and proof of work difficulty)
+// for a comment form: GET /comments/challenge?target_type=initiative&target_id=3,
+// or ?post_id=12 for a blog post
+func GetCommentChallenge(w http.ResponseWriter, r *http.Request) {
+	targetType, targetID, ok, err := targetFromQuery(r.URL.Query())
+	if err != nil || !ok {
+		http.Error(w, "target_type and target_id are required", http.StatusBadRequest)
+		return
+	}
+	random := make([]byte, 9)
+	rand.Read(random)
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, map[string]interface{}{
+		"token":       signFormToken(formTarget(targetType, targetID), time.Now(), base64.RawURLEncoding.EncodeToString(random)),
+		"min_seconds": int(commentMinDelay().Seconds()),
+		"pow_bits":    powBits(),
+	})
+}
+
+// checkFormToken validates the token and proof of work of a submission and
+// returns a message for the reader when it fails. An accepted token is
+// recorded until it expires, so one solved challenge cannot be replayed on
+// this or any other instance.
+func checkFormToken(token, powNonce, targetType string, targetID uint) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return "The form has expired, please reload the page", nil
+	}
+	issuedUnix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "The form has expired, please reload the page", nil
+	}
+	issued := time.Unix(issuedUnix, 0)
+	if !hmac.Equal([]byte(signFormToken(formTarget(targetType, targetID), issued, parts[2])), []byte(token)) {
+		return "The form has expired, please reload the page", nil
+	}
+
+	age := time.Since(issued)
+	if age > formTokenMaxAge {
+		return "The form has expired, please reload the page", nil
+	}
+	if age < commentMinDelay() {
+		return "That was quick! Please take a moment before submitting", nil
+	}
+
+	if difficulty := powBits(); difficulty > 0 {
+		sum := sha256.Sum256([]byte(token + ":" + powNonce))
+		if leadingZeroBits(sum[:]) < difficulty {
+			return "Your browser did not finish the anti-spam check, please try again", nil
+		}
+	}
+
+	if err := database.DB.Where("expires_at < ?", time.Now()).Delete(&models.UsedFormToken{}).Error; err != nil {
+		return "", err
+	}
+	used := database.DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.UsedFormToken{Token: token, ExpiresAt: issued.Add(formTokenMaxAge)})
+	if used.Error != nil {
+		return "", used.Error
+	}
+	if used.RowsAffected == 0 {
+		return "This form was already submitted, please reload the page", nil
+	}
+	return "", nil
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
+
+// commenterIPHash identifies repeat submitters without storing addresses
+func commenterIPHash(r *http.Request) string {
+	mac := hmac.New(sha256.New, commentSecret())
+	mac.Write([]byte("ip:" + clientIP(r)))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// commentRateLimited counts the last hour's comments from this address and
+// email, deleted ones included, against COMMENT_IP_LIMIT (default 10) and
+// COMMENT_EMAIL_LIMIT (default 5)
+func commentRateLimited(ipHash, email string) (bool, error) {
+	since := time.Now().Add(-time.Hour)
+	var byIP, byEmail int64
+	if err := database.DB.Unscoped().Model(&models.Comment{}).Where("ip_hash = ? AND created_at > ?", ipHash, since).Count(&byIP).Error; err != nil {
+		return false, err
+	}
+	if err := database.DB.Unscoped().Model(&models.Comment{}).Where("lower(email) = ? AND created_at > ?", email, since).Count(&byEmail).Error; err != nil {
+		return false, err
+	}
+	return byIP >= int64(envInt("COMMENT_IP_LIMIT", 10)) || byEmail >= int64(envInt("COMMENT_EMAIL_LIMIT", 5)), nil
+}
+
+// scoreComment runs the classifier over a new comment, rejecting it outright
+// at or above spam.Threshold
+func scoreComment(c *models.Comment) {
+	score, reasons, ok := spam.Evaluate(spam.Comment{Author: c.Author, Email: c.Email, Content: c.Content})
+	if !ok {
+		return
+	}
+	c.SpamScore = &score
+	c.SpamReasons = make([]string, len(reasons))
+	for i, reason := range reasons {
+		c.SpamReasons[i] = reason.String()
+	}
+	if score >= spam.Threshold() {
+		c.Status = "rejected"
+		c.AutoRejected = true
+	}
+}
+
+// GetSpamModel describes the classifier in use
+func GetSpamModel(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, map[string]interface{}{
+		"model":     spam.Info(),
+		"threshold": spam.Threshold(),
+	})
+}
+
+// RetrainSpamModel retrains now rather than waiting for the next scheduled run
+func RetrainSpamModel(w http.ResponseWriter, r *http.Request) {
+	info, err := spam.Retrain()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, map[string]interface{}{
+		"model":     info,
+		"threshold": spam.Threshold(),
+	})
+}
+
+// GetCommentSpamScore rescores a comment with the current model and explains
+// the result: each reason is a feature and its pull towards spam (+) or not (-)
+func GetCommentSpamScore(w http.ResponseWriter, r *http.Request) {
+	var c models.Comment
+	if err := database.DB.First(&c, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	score, reasons, ok := spam.Evaluate(spam.Comment{Author: c.Author, Email: c.Email, Content: c.Content})
+	if !ok {
+		http.Error(w, "The spam model needs more moderated comments before it can score", http.StatusConflict)
+		return
+	}
+	respondJSON(w, map[string]interface{}{
+		"comment_id":    c.ID,
+		"score":         score,
+		"reasons":       reasons,
+		"stored_score":  c.SpamScore,
+		"auto_rejected": c.AutoRejected,
+		"threshold":     spam.Threshold(),
+	})
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+)
+
+// Things readers can comment on, stored as Comment.TargetType
+const (
+	targetBlog       = "blog" // Blog posts and news items
+	targetInitiative = "initiative"
+	targetResource   = "resource"
+)
+
+// commentTarget is the record a comment thread belongs to
+type commentTarget struct {
+	Type  string
+	ID    uint
+	Title string
+	URL   string // Page the thread is shown on
+}
+
+// findCommentTarget loads a comment target. With published set, only targets
+// readers can see are found: no drafts or scheduled posts.
+func findCommentTarget(targetType string, id uint, published bool) (commentTarget, error) {
+	target := commentTarget{Type: targetType, ID: id}
+	switch targetType {
+	case targetBlog:
+		var post models.BlogPost
+		query := database.DB.Select("id", "slug", "type", "title")
+		if published {
+			query = query.Scopes(publishedPosts)
+		}
+		if err := query.First(&post, id).Error; err != nil {
+			return target, err
+		}
+		target.Title, target.URL = post.Title, postURL(post)
+	case targetInitiative:
+		var initiative models.Initiative
+		query := database.DB.Select("id", "slug", "title")
+		if published {
+			query = query.Scopes(publishedInitiatives)
+		}
+		if err := query.First(&initiative, id).Error; err != nil {
+			return target, err
+		}
+		target.Title, target.URL = initiative.Title, siteURL()+"/initiatives/"+initiative.Slug
+	case targetResource:
+		var resource models.Resource
+		if err := database.DB.Select("id", "slug", "title").First(&resource, id).Error; err != nil {
+			return target, err
+		}
+		// Resources have no page of their own, only an entry on /resources
+		target.Title, target.URL = resource.Title, siteURL()+"/resources#"+resource.Slug
+	default:
+		return target, fmt.Errorf("target_type must be blog, initiative or resource")
+	}
+	return target, nil
+}
+
+// targetFromQuery reads ?target_type=&target_id=, or the older ?post_id= for
+// blog posts. ok is false when neither is given.
+func targetFromQuery(q url.Values) (targetType string, id uint, ok bool, err error) {
+	raw := q.Get("target_id")
+	targetType = q.Get("target_type")
+	if raw == "" && q.Get("post_id") != "" {
+		raw, targetType = q.Get("post_id"), targetBlog
+	}
+	if raw == "" {
+		return "", 0, false, nil
+	}
+	if targetType == "" {
+		targetType = targetBlog
+	}
+	n, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return "", 0, true, fmt.Errorf("invalid target id %q", raw)
+	}
+	return targetType, uint(n), true, nil
+}
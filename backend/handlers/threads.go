@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+	"yiaga-backend/sanitize"
+)
+
+// maxCommentDepth bounds nesting: top level comments are depth 0, and replies
+// to a comment already at the limit join it as siblings instead
+const maxCommentDepth = 3
+
+const removedComment = "[removed]"
+
+// commentNode is the public view of a comment in a thread. Emails are never
+// exposed to readers.
+type commentNode struct {
+	ID        uint           `json:"id"`
+	ParentID  *uint          `json:"parent_id"`
+	Author    string         `json:"author"`
+	Content   string         `json:"content"`
+	Date      string         `json:"date"`
+	CreatedAt time.Time      `json:"created_at"`
+	IsStaff   bool           `json:"is_staff"`
+	Removed   bool           `json:"removed"`
+	Replies   []*commentNode `json:"replies"`
+}
+
+// replyParent resolves where a reply to parentID attaches, applying the depth
+// bound. Only approved comments on the same target can be replied to.
+func replyParent(targetType string, targetID, parentID uint) (*models.Comment, string) {
+	var parent models.Comment
+	err := database.DB.Where("target_type = ? AND target_id = ? AND status = ?", targetType, targetID, "approved").
+		First(&parent, parentID).Error
+	if err != nil {
+		return nil, "The comment you are replying to is not available"
+	}
+	if parent.Depth >= maxCommentDepth && parent.ParentID != nil {
+		var grandparent models.Comment
+		if err := database.DB.Unscoped().First(&grandparent, *parent.ParentID).Error; err == nil {
+			return &grandparent, ""
+		}
+	}
+	return &parent, ""
+}
+
+// commentThread builds the approved comment tree of a post, initiative or
+// resource. Removed (deleted
+// or no longer approved) comments that still have visible replies stay as
+// "[removed]" placeholders so the conversation keeps its shape; otherwise
+// they are left out.
+func commentThread(targetType string, targetID uint) ([]*commentNode, error) {
+	var comments []models.Comment
+	err := database.DB.Unscoped().Where("target_type = ? AND target_id = ?", targetType, targetID).
+		Order("created_at asc").Find(&comments).Error
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[uint]*commentNode, len(comments))
+	for _, c := range comments {
+		node := &commentNode{ID: c.ID, ParentID: c.ParentID, Date: c.Date, CreatedAt: c.CreatedAt, Replies: []*commentNode{}}
+		if c.DeletedAt.Valid || c.Status != "approved" {
+			node.Removed = true
+			node.Content = removedComment
+		} else {
+			node.Author = c.Author
+			node.Content = c.Content
+			node.IsStaff = c.IsStaff
+		}
+		nodes[c.ID] = node
+	}
+
+	var roots []*commentNode
+	for _, c := range comments {
+		node := nodes[c.ID]
+		if c.ParentID != nil {
+			if parent, ok := nodes[*c.ParentID]; ok {
+				parent.Replies = append(parent.Replies, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+
+	roots = prune(roots)
+	// Newest conversations first; replies read top to bottom
+	sort.SliceStable(roots, func(i, j int) bool { return roots[i].CreatedAt.After(roots[j].CreatedAt) })
+	return roots, nil
+}
+
+// prune drops removed comments with no visible replies beneath them
+func prune(nodes []*commentNode) []*commentNode {
+	kept := nodes[:0]
+	for _, n := range nodes {
+		n.Replies = prune(n.Replies)
+		if !n.Removed || len(n.Replies) > 0 {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// ReplyToComment posts an approved staff answer under a reader's comment.
+// Replying to a comment that is still pending approves it.
+func ReplyToComment(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	content := sanitize.Comment(input.Content)
+	if strings.TrimSpace(content) == "" {
+		respondFieldErrors(w, map[string]string{"content": "Reply cannot be empty"})
+		return
+	}
+
+	// The reply is signed with the editor's account, looked up before anything changes
+	userID, _ := currentEditor(r)
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Your account no longer exists", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var parent models.Comment
+	if err := database.DB.First(&parent, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if parent.Status == "rejected" {
+		http.Error(w, "Rejected comments cannot be replied to", http.StatusConflict)
+		return
+	}
+	if parent.Status != "approved" {
+		if err := database.DB.Model(&parent).Updates(map[string]interface{}{"status": "approved", "auto_rejected": false}).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyApproved(r, parent)
+	}
+	attachTo, _ := replyParent(parent.TargetType, parent.TargetID, parent.ID)
+	if attachTo == nil {
+		attachTo = &parent
+	}
+
+	reply := models.Comment{
+		Content:    content,
+		Author:     user.Username,
+		Email:      user.Email,
+		TargetType: parent.TargetType,
+		TargetID:   parent.TargetID,
+		PostTitle:  parent.PostTitle,
+		ParentID:   &attachTo.ID,
+		Depth:      attachTo.Depth + 1,
+		IsStaff:    true,
+		Status:     "approved",
+		Date:       time.Now().Format("Jan 2, 2006"),
+	}
+	if err := database.DB.Create(&reply).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	refreshCommentStats(reply)
+	notifyReply(r, reply)
+	respondJSON(w, reply)
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"yiaga-backend/database"
+	"yiaga-backend/i18n"
+	"yiaga-backend/models"
+	"yiaga-backend/sanitize"
+)
+
+// localize renders items in the locale negotiated from ?lang= and Accept-Language
+func localize(w http.ResponseWriter, r *http.Request, items ...i18n.Translatable) {
+	chain := i18n.Negotiate(r)
+	if !strings.Contains(w.Header().Get("Vary"), "Accept-Language") {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	w.Header().Set("Content-Language", chain[0])
+	for _, item := range items {
+		i18n.Apply(item, chain)
+	}
+}
+
+type translatableRecord struct {
+	Type  string `json:"type"`
+	ID    uint   `json:"id"`
+	Title string `json:"title"`
+	Key   string `json:"key"` // Slug, or page for hero sections
+	item  i18n.Translatable
+}
+
+// loadTranslatable fetches one record of the given type by ID
+func loadTranslatable(kind, id string) (i18n.Translatable, error) {
+	var item i18n.Translatable
+	switch kind {
+	case "blog":
+		item = &models.BlogPost{}
+	case "initiative":
+		item = &models.Initiative{}
+	case "announcement":
+		item = &models.Announcement{}
+	case "hero":
+		item = &models.HeroContent{}
+	default:
+		return nil, nil
+	}
+	return item, database.DB.First(item, id).Error
+}
+
+// loadTranslatables fetches every record of the given type, or of all types
+func loadTranslatables(kind string) ([]translatableRecord, error) {
+	var records []translatableRecord
+
+	if kind == "" || kind == "blog" {
+		var posts []models.BlogPost
+		if err := database.DB.Order("id").Find(&posts).Error; err != nil {
+			return nil, err
+		}
+		for i := range posts {
+			records = append(records, translatableRecord{"blog", posts[i].ID, posts[i].Title, posts[i].Slug, &posts[i]})
+		}
+	}
+	if kind == "" || kind == "initiative" {
+		var initiatives []models.Initiative
+		if err := database.DB.Order("id").Find(&initiatives).Error; err != nil {
+			return nil, err
+		}
+		for i := range initiatives {
+			records = append(records, translatableRecord{"initiative", initiatives[i].ID, initiatives[i].Title, initiatives[i].Slug, &initiatives[i]})
+		}
+	}
+	if kind == "" || kind == "announcement" {
+		var announcements []models.Announcement
+		if err := database.DB.Order("id").Find(&announcements).Error; err != nil {
+			return nil, err
+		}
+		for i := range announcements {
+			records = append(records, translatableRecord{"announcement", announcements[i].ID, announcements[i].Title, "", &announcements[i]})
+		}
+	}
+	if kind == "" || kind == "hero" {
+		var heroes []models.HeroContent
+		if err := database.DB.Order("id").Find(&heroes).Error; err != nil {
+			return nil, err
+		}
+		for i := range heroes {
+			records = append(records, translatableRecord{"hero", heroes[i].ID, heroes[i].Title, heroes[i].Page, &heroes[i]})
+		}
+	}
+	return records, nil
+}
+
+// GetMissingTranslations lists records whose text has not been translated:
+// GET /translations/missing?locale=fr&type=blog (both optional)
+func GetMissingTranslations(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("type")
+	locales := []string{}
+	if locale := r.URL.Query().Get("locale"); locale != "" {
+		if !i18n.IsSupported(locale) || locale == i18n.Default {
+			http.Error(w, "Unsupported locale", http.StatusBadRequest)
+			return
+		}
+		locales = append(locales, locale)
+	} else {
+		for _, l := range i18n.Supported {
+			if l != i18n.Default {
+				locales = append(locales, l)
+			}
+		}
+	}
+
+	records, err := loadTranslatables(kind)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type missingEntry struct {
+		translatableRecord
+		Locale        string   `json:"locale"`
+		MissingFields []string `json:"missing_fields"`
+	}
+	result := []missingEntry{}
+	for _, rec := range records {
+		for _, locale := range locales {
+			if missing := i18n.Missing(rec.item, locale); len(missing) > 0 {
+				result = append(result, missingEntry{rec, locale, missing})
+			}
+		}
+	}
+	respondJSON(w, result)
+}
+
+// SetTranslation merges translated fields for one locale:
+// PUT /translations/{type}/{id}/{locale} with {"title": "...", "content": "..."}
+func SetTranslation(w http.ResponseWriter, r *http.Request) {
+	locale := chi.URLParam(r, "locale")
+	if !i18n.IsSupported(locale) || locale == i18n.Default {
+		http.Error(w, "Unsupported locale", http.StatusBadRequest)
+		return
+	}
+
+	item, err := loadTranslatable(chi.URLParam(r, "type"), chi.URLParam(r, "id"))
+	if item == nil {
+		http.Error(w, "Unknown content type", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Record not found", http.StatusNotFound)
+		return
+	}
+
+	var values map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if content, ok := values["content"]; ok {
+		values["content"] = sanitize.RichText(content)
+	}
+	if unknown := i18n.Set(item, locale, values); len(unknown) > 0 {
+		http.Error(w, "Fields cannot be translated: "+strings.Join(unknown, ", "), http.StatusBadRequest)
+		return
+	}
+
+	if !saveTranslations(w, chi.URLParam(r, "type"), item) {
+		return
+	}
+	invalidateRelated()
+	item.Localization().AvailableLocales = i18n.Available(item)
+	respondJSON(w, item)
+}
+
+// DeleteTranslation removes every translated field for one locale
+func DeleteTranslation(w http.ResponseWriter, r *http.Request) {
+	locale := chi.URLParam(r, "locale")
+	item, err := loadTranslatable(chi.URLParam(r, "type"), chi.URLParam(r, "id"))
+	if item == nil {
+		http.Error(w, "Unknown content type", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Record not found", http.StatusNotFound)
+		return
+	}
+
+	delete(item.Localization().Translations, locale)
+	if !saveTranslations(w, chi.URLParam(r, "type"), item) {
+		return
+	}
+	respondJSON(w, map[string]string{"message": "Translation removed"})
+}
+
+// itemVersion is the version of the items that have one, posts and
+// initiatives, or nil
+func itemVersion(item i18n.Translatable) (*int, uint) {
+	switch v := item.(type) {
+	case *models.BlogPost:
+		return &v.Version, v.ID
+	case *models.Initiative:
+		return &v.Version, v.ID
+	}
+	return nil, 0
+}
+
+// saveTranslations writes an item's translations. A versioned item has its
+// version bumped, so an editor holding an older ETag gets 412 instead of
+// overwriting the translation, and a translation saved since the item was
+// loaded is answered with 412 too. It reports false when it has answered
+// the request.
+func saveTranslations(w http.ResponseWriter, kind string, item i18n.Translatable) bool {
+	version, id := itemVersion(item)
+	if version == nil {
+		if err := database.DB.Model(item).Select("translations").Updates(item).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		return true
+	}
+
+	loaded := *version
+	*version++
+	result := database.DB.Model(item).Where("version = ?", loaded).Select("translations", "version").Updates(item)
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		current, err := loadTranslatable(kind, strconv.FormatUint(uint64(id), 10))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		currentVersion, _ := itemVersion(current)
+		respondStale(w, current, *currentVersion)
+		return false
+	}
+	w.Header().Set("ETag", versionTag(*version))
+	return true
+}
+
+// sanitizeTranslatedContent cleans the translated copies of a rich text body
+// the same way the original is cleaned
+func sanitizeTranslatedContent(t models.Translations) {
+	for _, fields := range t {
+		if content, ok := fields["content"]; ok {
+			fields["content"] = sanitize.RichText(content)
+		}
+	}
+}
+
+// GetLocales lists the locales content can be translated into
+func GetLocales(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, map[string]interface{}{
+		"default":   i18n.Default,
+		"supported": i18n.Supported,
+	})
+}
@@ -0,0 +1,183 @@
+package i18n
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"yiaga-backend/models"
+)
+
+// Default is the language the base columns are written in
+const Default = "en"
+
+// Supported lists the locales editors can translate into
+var Supported = []string{"en", "fr", "ha", "yo", "ig"}
+
+// Translatable is implemented by models that embed models.Localized
+type Translatable interface {
+	TranslatableFields() map[string]*string
+	Localization() *models.Localized
+}
+
+// IsSupported reports whether locale is one of Supported
+func IsSupported(locale string) bool {
+	for _, l := range Supported {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate builds the fallback chain for a request: an explicit ?lang=
+// first, then the Accept-Language preferences in q order, then Default.
+// Regional variants fall back to their base language ("fr-CA" -> "fr").
+func Negotiate(r *http.Request) []string {
+	var chain []string
+	add := func(tag string) {
+		base := strings.ToLower(strings.SplitN(strings.SplitN(tag, "-", 2)[0], "_", 2)[0])
+		if !IsSupported(base) {
+			return
+		}
+		for _, l := range chain {
+			if l == base {
+				return
+			}
+		}
+		chain = append(chain, base)
+	}
+
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		add(lang)
+	}
+
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if fields[0] == "" || fields[0] == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			prefs = append(prefs, pref{fields[0], q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	for _, p := range prefs {
+		add(p.tag)
+	}
+
+	add(Default)
+	return chain
+}
+
+// Apply rewrites the item's text fields in place using the first locale in the
+// chain that has a non-empty translation for each field. Fields without any
+// translation keep their Default value.
+func Apply(item Translatable, chain []string) {
+	loc := item.Localization()
+	loc.AvailableLocales = Available(item)
+	loc.Locale = Default
+
+	for name, field := range item.TranslatableFields() {
+		for _, locale := range chain {
+			if locale == Default {
+				break
+			}
+			if value := loc.Translations[locale][name]; value != "" {
+				*field = value
+				// Report the most preferred locale that contributed anything
+				if loc.Locale == Default || indexOf(chain, locale) < indexOf(chain, loc.Locale) {
+					loc.Locale = locale
+				}
+				break
+			}
+		}
+	}
+}
+
+// Available lists Default plus every locale with at least one translated field
+func Available(item Translatable) []string {
+	locales := []string{Default}
+	for locale, fields := range item.Localization().Translations {
+		if locale == Default {
+			continue
+		}
+		for _, v := range fields {
+			if v != "" {
+				locales = append(locales, locale)
+				break
+			}
+		}
+	}
+	sort.Strings(locales[1:])
+	return locales
+}
+
+// Missing lists the translatable fields that have source text but no
+// translation in locale
+func Missing(item Translatable, locale string) []string {
+	var missing []string
+	translated := item.Localization().Translations[locale]
+	for name, field := range item.TranslatableFields() {
+		if *field != "" && translated[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// Set merges translated fields for one locale into the item. Empty values
+// remove the translation. Unknown field names are reported back.
+func Set(item Translatable, locale string, values map[string]string) (unknown []string) {
+	fields := item.TranslatableFields()
+	loc := item.Localization()
+	if loc.Translations == nil {
+		loc.Translations = models.Translations{}
+	}
+	current := loc.Translations[locale]
+	if current == nil {
+		current = map[string]string{}
+	}
+	for name, value := range values {
+		if _, ok := fields[name]; !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if value == "" {
+			delete(current, name)
+		} else {
+			current[name] = value
+		}
+	}
+	if len(current) == 0 {
+		delete(loc.Translations, locale)
+	} else {
+		loc.Translations[locale] = current
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return len(list)
+}
@@ -0,0 +1,90 @@
+// Package lease shares queued rows, such as outgoing emails or campaign
+// recipients, between server instances. A batch of due rows is claimed in a
+// short transaction with SKIP LOCKED and leased to the instance until a
+// deadline; each row is then worked outside any transaction and its outcome
+// committed on its own, so a crash or a failed update costs at most the row
+// in hand. A lease that runs out was left by an instance that stopped part
+// way, and its rows are queued again.
+package lease
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"yiaga-backend/database"
+)
+
+// Queue describes a table worked through leases. Its rows need status,
+// next_attempt_at and claimed_until columns.
+type Queue struct {
+	Model   interface{} // e.g. &models.OutgoingEmail{}
+	Table   string
+	Queued  string // Status of rows waiting their turn
+	Sending string // Status of claimed rows
+	Batch   int    // Most rows one claim takes
+
+	// Scope, when set, narrows the rows that may be claimed, e.g. with a join
+	Scope func(*gorm.DB) *gorm.DB
+}
+
+// Backoff is the wait before the given retry: 2, 4, 8, 16 then 32 minutes
+func Backoff(attempts int) time.Duration {
+	return time.Duration(1<<attempts) * time.Minute
+}
+
+// Reclaim queues again the rows whose lease ran out. It runs before every
+// claim, so a restarted instance picks up its own work first.
+func (q Queue) Reclaim() error {
+	return database.DB.Model(q.Model).
+		Where("status = ? AND claimed_until < ?", q.Sending, time.Now()).
+		Updates(map[string]interface{}{"status": q.Queued, "claimed_until": nil}).Error
+}
+
+// Claim leases a batch of due rows to this instance for the given time and
+// loads them into dest, a pointer to a slice of the model, oldest due first.
+// It returns when the lease ends.
+func (q Queue) Claim(dest interface{}, lease time.Duration) (time.Time, error) {
+	until := time.Now().Add(lease)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(q.Model).
+			Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: q.Table}, Options: "SKIP LOCKED"})
+		if q.Scope != nil {
+			query = q.Scope(query)
+		}
+		var ids []uint
+		err := query.
+			Where(q.Table+".status = ? AND "+q.Table+".next_attempt_at <= ?", q.Queued, time.Now()).
+			Order(q.Table+".next_attempt_at").Limit(q.Batch).
+			Pluck(q.Table+".id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		err = tx.Model(q.Model).Where("id IN ?", ids).
+			Updates(map[string]interface{}{"status": q.Sending, "claimed_until": until}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Order("next_attempt_at").Find(dest).Error
+	})
+	return until, err
+}
+
+// Release queues again claimed rows this instance ran out of time for
+func (q Queue) Release(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return database.DB.Model(q.Model).
+		Where("id IN ? AND status = ?", ids, q.Sending).
+		Updates(map[string]interface{}{"status": q.Queued, "claimed_until": nil}).Error
+}
+
+// Finish records the outcome of a claimed row and ends its lease. A row
+// whose lease was taken back in the meantime is left to its new claimant.
+func (q Queue) Finish(id uint, update map[string]interface{}) error {
+	update["claimed_until"] = nil
+	return database.DB.Model(q.Model).Where("id = ? AND status = ?", id, q.Sending).
+		Updates(update).Error
+}
@@ -0,0 +1,174 @@
+// Package mailtest runs an in-process SMTP server for tests of code that
+// sends through package mail.
+package mailtest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Message is one message the server accepted
+type Message struct {
+	From   string
+	To     []string
+	Header mail.Header
+	Body   string
+	Raw    []byte
+}
+
+// Server is a minimal SMTP sink. It offers no STARTTLS or AUTH, so package
+// mail talks to it in plain text.
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	reply    func(rcpt string) (code int, text string)
+	messages []Message
+}
+
+// Start listens on a free local port and points SMTP_HOST and SMTP_PORT at
+// it for the rest of the test
+func Start(t *testing.T) *Server {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{listener: l}
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	t.Setenv("SMTP_HOST", host)
+	t.Setenv("SMTP_PORT", port)
+	t.Setenv("SMTP_USER", "")
+
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		l.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+// SetReply decides the answer to RCPT TO for each address from now on, e.g.
+// 451 for a temporary failure or 550 for a permanent one. A nil func, or a
+// code of 0, accepts the recipient.
+func (s *Server) SetReply(reply func(rcpt string) (code int, text string)) {
+	s.mu.Lock()
+	s.reply = reply
+	s.mu.Unlock()
+}
+
+// Messages returns what has been delivered so far
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.session(conn)
+		}()
+	}
+}
+
+func (s *Server) session(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	reply("220 mailtest ready")
+
+	var msg Message
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 mailtest")
+		case "MAIL":
+			msg = Message{From: address(line)}
+			reply("250 OK")
+		case "RCPT":
+			rcpt := address(line)
+			s.mu.Lock()
+			decide := s.reply
+			s.mu.Unlock()
+			if decide != nil {
+				if code, text := decide(rcpt); code != 0 {
+					reply("%d %s", code, text)
+					continue
+				}
+			}
+			msg.To = append(msg.To, rcpt)
+			reply("250 OK")
+		case "DATA":
+			if len(msg.To) == 0 {
+				reply("554 no valid recipients")
+				continue
+			}
+			reply("354 go ahead")
+			var raw bytes.Buffer
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				raw.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.Raw = raw.Bytes()
+			if parsed, err := mail.ReadMessage(bytes.NewReader(msg.Raw)); err == nil {
+				msg.Header = parsed.Header
+				var body bytes.Buffer
+				body.ReadFrom(parsed.Body)
+				msg.Body = body.String()
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case "RSET":
+			msg = Message{}
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// address pulls the address out of "MAIL FROM:<a@b>" or "RCPT TO:<a@b>"
+func address(line string) string {
+	start, end := strings.Index(line, "<"), strings.LastIndex(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
@@ -0,0 +1,140 @@
+package mail
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/lease"
+	"yiaga-backend/models"
+)
+
+// MaxAttempts is how many times a message is tried before it is marked failed
+const MaxAttempts = 6
+
+// batchSize is how many due messages one pass of the queue sends
+const batchSize = 50
+
+// Enqueue stores a message for the queue to send. kind labels it for the
+// admin list, e.g. "comment.approved".
+func Enqueue(kind string, m Message) error {
+	return database.DB.Create(&models.OutgoingEmail{
+		Kind:          kind,
+		To:            m.To,
+		Subject:       m.Subject,
+		Text:          m.Text,
+		HTML:          m.HTML,
+		Headers:       m.Headers,
+		Status:        "queued",
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// queue hands due messages out to instances, see package lease
+var queue = lease.Queue{
+	Model:   &models.OutgoingEmail{},
+	Table:   "outgoing_emails",
+	Queued:  "queued",
+	Sending: "sending",
+	Batch:   batchSize,
+}
+
+// leaseTime is how long a claimed batch may take to send. Sending stops
+// while a whole SendTimeout is still left, so a live instance never has its
+// claim taken back.
+const leaseTime = 15 * time.Minute
+
+// ProcessQueue sends the messages that are due, each committed on its own.
+// Several instances can share the queue without sending twice.
+func ProcessQueue() error {
+	if !Configured() {
+		return nil
+	}
+	if err := queue.Reclaim(); err != nil {
+		return err
+	}
+	var due []models.OutgoingEmail
+	until, err := queue.Claim(&due, leaseTime)
+	if err != nil {
+		return err
+	}
+	for i, e := range due {
+		if time.Until(until) < SendTimeout {
+			return queue.Release(ids(due[i:]))
+		}
+		if err := deliver(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ids(emails []models.OutgoingEmail) []uint {
+	ids := make([]uint, len(emails))
+	for i, e := range emails {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+// deliver sends one claimed message and records the outcome
+func deliver(e models.OutgoingEmail) error {
+	e.Attempts++
+	sendErr := Send(Message{To: e.To, Subject: e.Subject, Text: e.Text, HTML: e.HTML, Headers: e.Headers})
+	update := map[string]interface{}{"attempts": e.Attempts}
+	switch {
+	case sendErr == nil:
+		update["status"] = "sent"
+		update["sent_at"] = time.Now()
+		update["last_error"] = ""
+	case e.Attempts >= MaxAttempts:
+		update["status"] = "failed"
+		update["last_error"] = sendErr.Error()
+	default:
+		update["status"] = "queued"
+		update["next_attempt_at"] = time.Now().Add(lease.Backoff(e.Attempts))
+		update["last_error"] = sendErr.Error()
+	}
+	return queue.Finish(e.ID, update)
+}
+
+// Errors from Retry
+var (
+	ErrNoEmail   = errors.New("no such email")
+	ErrNotFailed = errors.New("only failed emails can be retried")
+)
+
+// Retry puts a failed message back in the queue with fresh attempts
+func Retry(id uint) error {
+	result := database.DB.Model(&models.OutgoingEmail{}).Where("id = ? AND status = ?", id, "failed").
+		Updates(map[string]interface{}{"status": "queued", "attempts": 0, "next_attempt_at": time.Now()})
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	err := database.DB.Select("id").First(&models.OutgoingEmail{}, id).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNoEmail
+	case err != nil:
+		return err
+	}
+	return ErrNotFailed
+}
+
+// RunQueue calls ProcessQueue every interval, starting at once so claims
+// left by a previous run are taken back. Without SMTP_HOST messages stay
+// queued until it is set.
+func RunQueue(interval time.Duration) {
+	if !Configured() {
+		log.Println("mail: SMTP_HOST not set, emails will stay queued")
+	}
+	for {
+		if err := ProcessQueue(); err != nil {
+			log.Printf("mail queue failed: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,130 @@
+package mail_test
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"yiaga-backend/database"
+	"yiaga-backend/mail"
+	"yiaga-backend/mail/mailtest"
+	"yiaga-backend/models"
+)
+
+func TestSend(t *testing.T) {
+	sink := mailtest.Start(t)
+	t.Setenv("MAIL_FROM", "Yiaga Africa <no-reply@yiaga.org>")
+
+	err := mail.Send(mail.Message{
+		To:      "Reader <reader@example.org>",
+		Subject: "Your comment is live",
+		Text:    "Thanks for joining the conversation.",
+		HTML:    "<p>Thanks for joining the conversation.</p>",
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<https://api.yiaga.org/unsubscribe?sig=abc>",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click\r\nBcc: victim@example.org",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := sink.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("%d messages delivered, want 1", len(msgs))
+	}
+	m := msgs[0]
+	if m.From != "no-reply@yiaga.org" || len(m.To) != 1 || m.To[0] != "reader@example.org" {
+		t.Errorf("envelope from %q to %v", m.From, m.To)
+	}
+	if got := m.Header.Get("List-Unsubscribe"); got != "<https://api.yiaga.org/unsubscribe?sig=abc>" {
+		t.Errorf("List-Unsubscribe = %q", got)
+	}
+	if got := m.Header.Get("Bcc"); got != "" {
+		t.Errorf("header injection added Bcc: %q", got)
+	}
+}
+
+var initTestDB sync.Once
+
+// testDB connects to TEST_DATABASE_URL, a throwaway Postgres database, and
+// empties the mail queue. Tests needing it are skipped without one.
+func testDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	initTestDB.Do(func() { database.Init(dsn) })
+	if err := database.DB.Exec(`TRUNCATE outgoing_emails RESTART IDENTITY`).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func queued(t *testing.T) map[string]models.OutgoingEmail {
+	t.Helper()
+	var rows []models.OutgoingEmail
+	if err := database.DB.Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	byTo := map[string]models.OutgoingEmail{}
+	for _, e := range rows {
+		byTo[e.To] = e
+	}
+	return byTo
+}
+
+func TestProcessQueue(t *testing.T) {
+	testDB(t)
+	sink := mailtest.Start(t)
+	sink.SetReply(func(rcpt string) (int, string) {
+		if rcpt == "busy@example.org" {
+			return 451, "try again later"
+		}
+		return 0, ""
+	})
+
+	for _, to := range []string{"one@example.org", "busy@example.org"} {
+		if err := mail.Enqueue("comment.approved", mail.Message{To: to, Subject: "Approved", Text: "Hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mail.ProcessQueue(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := queued(t)
+	if e := got["one@example.org"]; e.Status != "sent" || e.SentAt == nil || e.ClaimedUntil != nil {
+		t.Errorf("one@example.org: status %q, sent at %v, claimed until %v", e.Status, e.SentAt, e.ClaimedUntil)
+	}
+	if e := got["busy@example.org"]; e.Status != "queued" || e.Attempts != 1 || e.LastError == "" || !e.NextAttemptAt.After(time.Now()) {
+		t.Errorf("busy@example.org: status %q, attempts %d, last error %q, next attempt %v", e.Status, e.Attempts, e.LastError, e.NextAttemptAt)
+	}
+	if n := len(sink.Messages()); n != 1 {
+		t.Errorf("%d messages delivered, want 1", n)
+	}
+
+	// A pass that finds nothing due sends nothing again
+	if err := mail.ProcessQueue(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sink.Messages()); n != 1 {
+		t.Errorf("%d messages delivered after a second pass, want 1", n)
+	}
+
+	// A claim left by an instance that died is taken back once its lease ends
+	sink.SetReply(nil)
+	expired := time.Now().Add(-time.Minute)
+	database.DB.Model(&models.OutgoingEmail{}).Where("\"to\" = ?", "busy@example.org").
+		Updates(map[string]interface{}{"status": "sending", "claimed_until": expired, "next_attempt_at": expired})
+	if err := mail.ProcessQueue(); err != nil {
+		t.Fatal(err)
+	}
+	if e := queued(t)["busy@example.org"]; e.Status != "sent" || e.Attempts != 2 {
+		t.Errorf("busy@example.org after reclaim: status %q, attempts %d", e.Status, e.Attempts)
+	}
+	if n := len(sink.Messages()); n != 2 {
+		t.Errorf("%d messages delivered, want 2", n)
+	}
+}
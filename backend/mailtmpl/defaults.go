@@ -0,0 +1,103 @@
+package mailtmpl
+
+import (
+	"errors"
+	"log"
+
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/i18n"
+	"yiaga-backend/models"
+)
+
+// builtin are the templates shipped with the application. They are seeded
+// for admins to edit, and used as they are for anything missing from the
+// database.
+var builtin = []models.EmailTemplate{
+	{Name: DefaultLayout, Kind: KindLayout, HTML: `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{subject}}</title>
+<style>
+body { margin: 0; padding: 0; background: #f4f4f4; font-family: Arial, sans-serif; color: #222222; line-height: 1.5; }
+.wrapper { max-width: 600px; margin: 0 auto; padding: 24px; background: #ffffff; }
+a { color: #0a7c3e; }
+.button { display: inline-block; padding: 10px 18px; background: #0a7c3e; color: #ffffff; text-decoration: none; border-radius: 4px; }
+blockquote { margin: 0 0 16px; padding: 8px 12px; border-left: 3px solid #0a7c3e; color: #444444; }
+.muted { font-size: 12px; color: #777777; }
+.muted a { color: #777777; }
+@media (max-width: 620px) { .wrapper { padding: 12px; } }
+</style></head>
+<body><div class="wrapper">
+{{template "header" .}}
+{{template "content" .}}
+{{template "footer" .}}
+</div></body></html>`},
+
+	{Name: "header", Kind: KindPartial, HTML: `<p><a href="{{siteURL}}" style="text-decoration:none"><strong>Yiaga Africa</strong></a></p>`},
+
+	{Name: "footer", Kind: KindPartial, HTML: `<p class="muted">&copy; {{year}} Yiaga Africa</p>`},
+
+	{Name: "comment-links", Kind: KindPartial, HTML: `{{if .Quote}}<blockquote>{{.Quote}}</blockquote>{{end}}
+<p><a href="{{.Link}}">Read the conversation</a></p>
+<p class="muted">You are receiving this because you commented on &ldquo;{{.PostTitle}}&rdquo;.
+<a href="{{.Unsubscribe}}">Stop emails about this conversation</a>.</p>`},
+
+	{Name: "newsletter-links", Kind: KindPartial, HTML: `<p class="muted">You are receiving this because you subscribed to Yiaga Africa updates.
+<a href="{{.PreferencesURL}}">Manage your subscription</a> or <a href="{{.UnsubscribeURL}}">unsubscribe</a>.</p>`},
+
+	{Name: "comment.approved", Kind: KindEmail, Subject: "Your comment has been published", HTML: `<p>Hello {{.Name}},</p>
+<p>Your comment has been approved and is now visible to everyone.</p>
+{{template "comment-links" .}}`},
+
+	{Name: "comment.reply", Kind: KindEmail, Subject: "{{.ReplyAuthor}} replied to your comment", HTML: `<p>Hello {{.Name}},</p>
+<p>{{.ReplyAuthor}} replied to your comment:</p>
+{{template "comment-links" .}}`},
+
+	{Name: "newsletter.campaign", Kind: KindEmail, Subject: "{{.Subject}}", HTML: `{{.Body}}
+{{template "newsletter-links" .}}`},
+
+	{Name: "newsletter.confirm", Kind: KindEmail, Subject: "Confirm your Yiaga Africa newsletter subscription", HTML: `<p>Thank you for subscribing to Yiaga Africa updates.</p>
+<p><a class="button" href="{{.Link}}">Confirm my subscription</a></p>
+<p class="muted">The link works for {{if .ValidDays}}{{.ValidDays}} days{{else}}{{.ValidHours}} hours{{end}}.
+If you did not sign up, ignore this email and you will not hear from us again.</p>`},
+}
+
+func init() {
+	for i := range builtin {
+		builtin[i].Locale = i18n.Default
+		builtin[i].Version = 1
+	}
+}
+
+// Seed stores any built in template missing from the database, with its
+// first version
+func Seed() {
+	for _, t := range builtin {
+		err := database.DB.Unscoped().Where("name = ? AND locale = ?", t.Name, t.Locale).First(&models.EmailTemplate{}).Error
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err := database.DB.Create(&t).Error; err != nil {
+			log.Printf("mailtmpl: seeding %s: %v", t.Name, err)
+			continue
+		}
+		if err := Snapshot(t); err != nil {
+			log.Printf("mailtmpl: seeding %s: %v", t.Name, err)
+			continue
+		}
+		log.Printf("Database seeded with email template %s", t.Name)
+	}
+}
+
+// Snapshot records a template's current content as its version
+func Snapshot(t models.EmailTemplate) error {
+	return database.DB.Create(&models.EmailTemplateVersion{
+		TemplateID: t.ID,
+		Version:    t.Version,
+		Layout:     t.Layout,
+		Subject:    t.Subject,
+		HTML:       t.HTML,
+		Text:       t.Text,
+		EditedBy:   t.UpdatedBy,
+	}).Error
+}
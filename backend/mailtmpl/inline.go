@@ -0,0 +1,295 @@
+package mailtmpl
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aymerick/douceur/css"
+	"github.com/aymerick/douceur/parser"
+	"golang.org/x/net/html"
+)
+
+// Many mail clients drop <style> elements, so rendered HTML has its style
+// sheet rules copied into style attributes. Only simple selectors are
+// inlined: tags, classes and ids, combined with descendant and child
+// combinators. Anything else, and @media blocks, stays in a <style> element
+// for the clients that read it.
+
+type compound struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+type selector struct {
+	parts       []compound
+	combinators []string // Between parts[i] and parts[i+1]: " " or ">"
+	specificity int
+}
+
+var compoundPattern = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*|\*)?((?:[.#][A-Za-z0-9_-]+)*)$`)
+var qualifierPattern = regexp.MustCompile(`[.#][A-Za-z0-9_-]+`)
+
+// parseSelector reads a selector the inliner can apply, ok is false otherwise
+func parseSelector(s string) (selector, bool) {
+	var sel selector
+	combinator := ""
+	for _, token := range strings.Fields(strings.ReplaceAll(s, ">", " > ")) {
+		if token == ">" {
+			if combinator != "" || len(sel.parts) == 0 {
+				return sel, false
+			}
+			combinator = ">"
+			continue
+		}
+		m := compoundPattern.FindStringSubmatch(token)
+		if m == nil || (m[1] == "" && m[2] == "") {
+			return sel, false
+		}
+		c := compound{tag: strings.ToLower(m[1])}
+		if c.tag == "*" {
+			c.tag = ""
+		} else if c.tag != "" {
+			sel.specificity++
+		}
+		for _, q := range qualifierPattern.FindAllString(m[2], -1) {
+			if q[0] == '#' {
+				c.id = q[1:]
+				sel.specificity += 100
+			} else {
+				c.classes = append(c.classes, q[1:])
+				sel.specificity += 10
+			}
+		}
+		if len(sel.parts) > 0 {
+			if combinator == "" {
+				combinator = " "
+			}
+			sel.combinators = append(sel.combinators, combinator)
+		}
+		sel.parts = append(sel.parts, c)
+		combinator = ""
+	}
+	return sel, len(sel.parts) > 0 && combinator == ""
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func (c compound) matches(n *html.Node) bool {
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" && attr(n, "id") != c.id {
+		return false
+	}
+	classes := strings.Fields(attr(n, "class"))
+	for _, want := range c.classes {
+		found := false
+		for _, have := range classes {
+			if have == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func parentElement(n *html.Node) *html.Node {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode {
+			return p
+		}
+	}
+	return nil
+}
+
+// matches checks the selector right to left from n
+func (s selector) matches(n *html.Node, i int) bool {
+	if !s.parts[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	if s.combinators[i-1] == ">" {
+		p := parentElement(n)
+		return p != nil && s.matches(p, i-1)
+	}
+	for p := parentElement(n); p != nil; p = parentElement(p) {
+		if s.matches(p, i-1) {
+			return true
+		}
+	}
+	return false
+}
+
+type inlineRule struct {
+	sel          selector
+	order        int
+	declarations []*css.Declaration
+}
+
+type applied struct {
+	important   bool
+	specificity int
+	order       int
+	decl        *css.Declaration
+}
+
+// Inline copies the rules of a document's <style> elements into style
+// attributes. Existing style attributes win over the style sheet, except
+// against !important.
+func Inline(doc string) (string, error) {
+	root, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return "", err
+	}
+
+	var styles []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "style" {
+			styles = append(styles, n)
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	if len(styles) == 0 {
+		return doc, nil
+	}
+
+	var rules []inlineRule
+	var kept []string
+	for _, style := range styles {
+		var text strings.Builder
+		for c := style.FirstChild; c != nil; c = c.NextSibling {
+			text.WriteString(c.Data)
+		}
+		sheet, err := parser.Parse(text.String())
+		if err != nil {
+			return "", err
+		}
+		for _, rule := range sheet.Rules {
+			if rule.Kind != css.QualifiedRule {
+				kept = append(kept, rule.String())
+				continue
+			}
+			var rest []string
+			for _, s := range rule.Selectors {
+				if sel, ok := parseSelector(s); ok {
+					rules = append(rules, inlineRule{sel: sel, order: len(rules), declarations: rule.Declarations})
+				} else {
+					rest = append(rest, s)
+				}
+			}
+			if len(rest) > 0 {
+				keep := *rule
+				keep.Selectors = rest
+				keep.Prelude = strings.Join(rest, ", ")
+				kept = append(kept, keep.String())
+			}
+		}
+	}
+
+	var apply func(*html.Node)
+	apply = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data != "style" && n.Data != "head" {
+			var matched []applied
+			for _, r := range rules {
+				if r.sel.matches(n, len(r.sel.parts)-1) {
+					for _, d := range r.declarations {
+						matched = append(matched, applied{d.Important, r.sel.specificity, r.order, d})
+					}
+				}
+			}
+			if len(matched) > 0 {
+				setStyle(n, matched)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			apply(c)
+		}
+	}
+	apply(root)
+
+	// What could not be inlined stays in the first <style> element
+	for i, style := range styles {
+		if i > 0 || len(kept) == 0 {
+			style.Parent.RemoveChild(style)
+			continue
+		}
+		for c := style.FirstChild; c != nil; c = style.FirstChild {
+			style.RemoveChild(c)
+		}
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: strings.Join(kept, "\n")})
+	}
+
+	var out bytes.Buffer
+	if err := html.Render(&out, root); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// setStyle merges matched declarations into n's style attribute
+func setStyle(n *html.Node, matched []applied) {
+	// Existing inline declarations count as the most specific normal rule.
+	// The parser loses the value of a last declaration without a semicolon.
+	style := strings.TrimRight(strings.TrimSpace(attr(n, "style")), ";") + ";"
+	if existing, err := parser.ParseDeclarations(style); err == nil {
+		for _, d := range existing {
+			matched = append(matched, applied{d.Important, 1 << 20, 0, d})
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if a.important != b.important {
+			return !a.important
+		}
+		if a.specificity != b.specificity {
+			return a.specificity < b.specificity
+		}
+		return a.order < b.order
+	})
+
+	values := map[string]*css.Declaration{}
+	var order []string
+	for _, m := range matched {
+		if _, ok := values[m.decl.Property]; !ok {
+			order = append(order, m.decl.Property)
+		}
+		values[m.decl.Property] = m.decl
+	}
+	parts := make([]string, len(order))
+	for i, p := range order {
+		parts[i] = p + ": " + values[p].Value
+		if values[p].Important {
+			parts[i] += " !important"
+		}
+	}
+	style = strings.Join(parts, "; ")
+
+	for i, a := range n.Attr {
+		if a.Key == "style" {
+			n.Attr[i].Val = style
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: "style", Val: style})
+}
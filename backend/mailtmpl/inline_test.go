@@ -0,0 +1,172 @@
+package mailtmpl
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// styleOf returns the style attribute of the element with the given id
+func styleOf(t *testing.T, doc, id string) string {
+	t.Helper()
+	root, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found *html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && attr(n, "id") == id {
+			found = n
+		}
+		for c := n.FirstChild; c != nil && found == nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	if found == nil {
+		t.Fatalf("no element #%s in %s", id, doc)
+	}
+	return attr(found, "style")
+}
+
+func TestInline(t *testing.T) {
+	tests := []struct {
+		name  string
+		style string
+		body  string
+		want  map[string]string // Style attribute by element id
+	}{
+		{
+			name:  "tag, class and id",
+			style: `p { color: red } .note { font-size: 12px } #x { margin: 0 }`,
+			body:  `<p id="a">a</p><p id="b" class="note">b</p><div id="x">x</div>`,
+			want:  map[string]string{"a": "color: red", "b": "color: red; font-size: 12px", "x": "margin: 0"},
+		},
+		{
+			name:  "more specific wins whatever the order",
+			style: `#a { color: blue } p.note { color: green } .note { color: red } p { color: black }`,
+			body:  `<p id="a" class="note">a</p><p id="b" class="note">b</p><p id="c">c</p>`,
+			want:  map[string]string{"a": "color: blue", "b": "color: green", "c": "color: black"},
+		},
+		{
+			name:  "later rule wins at equal specificity",
+			style: `.one { color: red } .two { color: blue }`,
+			body:  `<p id="a" class="two one">a</p>`,
+			want:  map[string]string{"a": "color: blue"},
+		},
+		{
+			name:  "important beats specificity",
+			style: `p { color: red !important } #a { color: blue }`,
+			body:  `<p id="a">a</p>`,
+			want:  map[string]string{"a": "color: red !important"},
+		},
+		{
+			name:  "existing style attribute wins",
+			style: `#a { color: red; padding: 4px }`,
+			body:  `<p id="a" style="color: blue">a</p>`,
+			want:  map[string]string{"a": "color: blue; padding: 4px"},
+		},
+		{
+			name:  "important beats existing style attribute",
+			style: `p { color: red !important }`,
+			body:  `<p id="a" style="color: blue; margin: 0;">a</p>`,
+			want:  map[string]string{"a": "color: red !important; margin: 0"},
+		},
+		{
+			name:  "descendant and child combinators",
+			style: `td a { color: red } table > tbody > tr > td > span { color: blue } div > span { color: green }`,
+			body:  `<table><tr><td><a id="a">a</a><span id="s">s</span></td></tr></table><div><p><span id="d">d</span></p></div>`,
+			want:  map[string]string{"a": "color: red", "s": "color: blue", "d": ""},
+		},
+		{
+			name:  "unmatched classes",
+			style: `.one.two { color: red }`,
+			body:  `<p id="a" class="one">a</p><p id="b" class="one two">b</p>`,
+			want:  map[string]string{"a": "", "b": "color: red"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Inline(`<html><head><style>` + tt.style + `</style></head><body>` + tt.body + `</body></html>`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for id, want := range tt.want {
+				if got := styleOf(t, out, id); got != want {
+					t.Errorf("#%s style %q, want %q", id, got, want)
+				}
+			}
+			if strings.Contains(out, "<style") {
+				t.Errorf("style element kept with nothing left in it: %s", out)
+			}
+		})
+	}
+}
+
+func TestInlineKeepsWhatItCannotApply(t *testing.T) {
+	out, err := Inline(`<html><head><style>
+p { color: red }
+a:hover, .link { text-decoration: underline }
+@media (max-width: 600px) { p { color: blue } }
+</style><style>li + li { margin: 0 }</style></head>
+<body><p id="p">p</p><a id="a" class="link" href="#">a</a></body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := styleOf(t, out, "p"); got != "color: red" {
+		t.Errorf("#p style %q, want the p rule only", got)
+	}
+	if got := styleOf(t, out, "a"); got != "text-decoration: underline" {
+		t.Errorf("#a style %q, want the .link part of the rule", got)
+	}
+	if n := strings.Count(out, "<style>"); n != 1 {
+		t.Errorf("%d style elements, want the first one only: %s", n, out)
+	}
+	for _, kept := range []string{"@media (max-width: 600px)", "a:hover", "li + li"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("%q not kept in %s", kept, out)
+		}
+	}
+	for _, inlined := range []string{".link", "p { color: red }"} {
+		if strings.Contains(out, inlined) {
+			t.Errorf("%q inlined but kept in %s", inlined, out)
+		}
+	}
+}
+
+func TestInlineWithoutStyle(t *testing.T) {
+	doc := `<p style="color: red">unchanged</p>`
+	if out, err := Inline(doc); err != nil || out != doc {
+		t.Errorf("Inline = %q, %v, want the document unchanged", out, err)
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector    string
+		ok          bool
+		specificity int
+	}{
+		{"p", true, 1},
+		{"*", true, 0},
+		{".note", true, 10},
+		{"#main", true, 100},
+		{"p.note.big", true, 21},
+		{"div#main > p a.link", true, 113},
+		{"td>a", true, 2},
+		{"a:hover", false, 0},
+		{"li + li", false, 0},
+		{"[href]", false, 0},
+		{"> p", false, 0},
+		{"p >", false, 0},
+		{"div > > p", false, 0},
+	}
+	for _, tt := range tests {
+		sel, ok := parseSelector(tt.selector)
+		if ok != tt.ok || (ok && sel.specificity != tt.specificity) {
+			t.Errorf("parseSelector(%q) = specificity %d, %v, want %d, %v", tt.selector, sel.specificity, ok, tt.specificity, tt.ok)
+		}
+	}
+}
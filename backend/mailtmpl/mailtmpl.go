@@ -0,0 +1,342 @@
+// Package mailtmpl renders transactional emails from admin editable
+// templates. An email's HTML is the "content" block of a layout, which can
+// include shared partials by name ({{template "footer" .}}). The rendered
+// HTML has its CSS inlined, and a plain text alternative is generated from
+// it unless the email has a hand-written one.
+package mailtmpl
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"yiaga-backend/database"
+	"yiaga-backend/i18n"
+	"yiaga-backend/models"
+)
+
+// Kinds of template
+const (
+	KindEmail   = "email"
+	KindLayout  = "layout"
+	KindPartial = "partial"
+)
+
+// DefaultLayout wraps emails that name no layout
+const DefaultLayout = "layout"
+
+// Type is an email the application sends, with data for previews
+type Type struct {
+	Description string      `json:"description"`
+	Sample      interface{} `json:"sample"`
+}
+
+// CommentData is what comment notification emails are rendered with
+type CommentData struct {
+	Name        string // The commenter being emailed
+	ReplyAuthor string // comment.reply only
+	Quote       string // Extract of the comment or reply
+	Link        string
+	PostTitle   string
+	Unsubscribe string // Stops emails about the thread
+}
+
+// ConfirmData is what the newsletter confirmation email is rendered with
+type ConfirmData struct {
+	Link       string
+	ValidDays  int // How long the link works, in whole days when 2 or more
+	ValidHours int
+}
+
+// CampaignData is what newsletter campaigns are rendered with. Body is the
+// campaign's own HTML, already executed for the subscriber.
+type CampaignData struct {
+	Subject        string
+	Body           htmltemplate.HTML
+	PreferencesURL string
+	UnsubscribeURL string
+}
+
+// Types lists the emails the application sends, by template name
+var Types = map[string]Type{
+	"comment.approved": {
+		Description: "Tells a commenter their comment is published",
+		Sample: CommentData{
+			Name: "Amina", Quote: "Thank you for this report, the findings on youth turnout are eye-opening.",
+			Link: "https://yiaga.org/blog/sample-post#comment-1", PostTitle: "Sample post",
+			Unsubscribe: "https://yiaga.org/api/comments/threads/1/unsubscribe",
+		},
+	},
+	"comment.reply": {
+		Description: "Tells a commenter someone replied to their comment",
+		Sample: CommentData{
+			Name: "Amina", ReplyAuthor: "Tunde", Quote: "I agree, and the state by state breakdown is worth a look too.",
+			Link: "https://yiaga.org/blog/sample-post#comment-2", PostTitle: "Sample post",
+			Unsubscribe: "https://yiaga.org/api/comments/threads/1/unsubscribe",
+		},
+	},
+	"newsletter.campaign": {
+		Description: "Wraps every newsletter campaign, with links to manage the subscription",
+		Sample: CampaignData{
+			Subject: "Election update", Body: "<p>Our observers are reporting from every polling unit.</p>",
+			PreferencesURL: "https://yiaga.org/newsletter/preferences?token=sample",
+			UnsubscribeURL: "https://yiaga.org/api/subscribe/unsubscribe?token=sample",
+		},
+	},
+	"newsletter.confirm": {
+		Description: "Asks a new newsletter subscriber to confirm their address",
+		Sample:      ConfirmData{Link: "https://yiaga.org/api/subscribe/confirm?token=sample", ValidDays: 7},
+	},
+}
+
+// TypeNames lists Types in order
+func TypeNames() []string {
+	names := make([]string, 0, len(Types))
+	for name := range Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Rendered is an email ready to send
+type Rendered struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+func siteURL() string {
+	if u := os.Getenv("SITE_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "https://yiaga.org"
+}
+
+func funcs(subject string) map[string]interface{} {
+	return map[string]interface{}{
+		"siteURL": siteURL,
+		"year":    func() int { return time.Now().Year() },
+		"subject": func() string { return subject },
+	}
+}
+
+// chain is the locales to try in order, ending with i18n.Default
+func chain(locales []string) []string {
+	var out []string
+	for _, l := range append(locales, i18n.Default) {
+		if !i18n.IsSupported(l) {
+			continue
+		}
+		seen := false
+		for _, o := range out {
+			seen = seen || o == l
+		}
+		if !seen {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// set is every template a render may use, the best locale of each
+type set map[string]models.EmailTemplate
+
+func setKey(kind, name string) string { return kind + ":" + name }
+
+// loadSet picks each template in the first locale of the chain that has
+// it, then the built in version. Overrides, such as an unsaved edit being
+// previewed, replace templates of the same kind and name.
+func loadSet(locales []string, overrides []models.EmailTemplate) (set, error) {
+	locales = chain(locales)
+	var stored []models.EmailTemplate
+	// Without a database, as in tests, only the built in templates are used
+	if database.DB != nil {
+		if err := database.DB.Where("locale IN ?", locales).Find(&stored).Error; err != nil {
+			return nil, err
+		}
+	}
+	rank := map[string]int{}
+	for i, l := range locales {
+		rank[l] = i
+	}
+	s := set{}
+	for _, t := range builtin {
+		s[setKey(t.Kind, t.Name)] = t
+	}
+	best := map[string]int{}
+	for _, t := range stored {
+		key := setKey(t.Kind, t.Name)
+		if r, ok := best[key]; !ok || rank[t.Locale] < r {
+			best[key] = rank[t.Locale]
+			s[key] = t
+		}
+	}
+	for _, t := range overrides {
+		s[setKey(t.Kind, t.Name)] = t
+	}
+	return s, nil
+}
+
+// Render renders the email of a type in the first of the locales that has
+// it, falling back to i18n.Default
+func Render(name string, locales []string, data interface{}) (Rendered, error) {
+	s, err := loadSet(locales, nil)
+	if err != nil {
+		return Rendered{}, err
+	}
+	return s.render(name, data)
+}
+
+func (s set) render(name string, data interface{}) (Rendered, error) {
+	email, ok := s[setKey(KindEmail, name)]
+	if !ok {
+		return Rendered{}, fmt.Errorf("no email template %q", name)
+	}
+	layoutName := email.Layout
+	if layoutName == "" {
+		layoutName = DefaultLayout
+	}
+	layout, ok := s[setKey(KindLayout, layoutName)]
+	if !ok {
+		return Rendered{}, fmt.Errorf("%s: no layout %q", name, layoutName)
+	}
+
+	var out Rendered
+	var buf bytes.Buffer
+	subject, err := texttemplate.New("subject").Funcs(funcs("")).Option("missingkey=error").Parse(email.Subject)
+	if err != nil {
+		return out, fmt.Errorf("%s subject: %v", name, err)
+	}
+	if err := subject.Execute(&buf, data); err != nil {
+		return out, fmt.Errorf("%s subject: %v", name, err)
+	}
+	out.Subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	t := htmltemplate.New(layoutName).Funcs(funcs(out.Subject)).Option("missingkey=error")
+	if _, err := t.Parse(layout.HTML); err != nil {
+		return out, fmt.Errorf("layout %s: %v", layoutName, err)
+	}
+	for _, p := range s {
+		if p.Kind != KindPartial {
+			continue
+		}
+		if _, err := t.New(p.Name).Parse(p.HTML); err != nil {
+			return out, fmt.Errorf("partial %s: %v", p.Name, err)
+		}
+	}
+	if _, err := t.New("content").Parse(email.HTML); err != nil {
+		return out, fmt.Errorf("%s: %v", name, err)
+	}
+	buf.Reset()
+	if err := t.ExecuteTemplate(&buf, layoutName, data); err != nil {
+		return out, fmt.Errorf("%s: %v", name, err)
+	}
+	if out.HTML, err = Inline(buf.String()); err != nil {
+		return out, fmt.Errorf("%s: inlining CSS: %v", name, err)
+	}
+
+	if strings.TrimSpace(email.Text) == "" {
+		out.Text, err = ToText(out.HTML)
+		return out, err
+	}
+	text, err := texttemplate.New("text").Funcs(funcs(out.Subject)).Option("missingkey=error").Parse(email.Text)
+	if err != nil {
+		return out, fmt.Errorf("%s text: %v", name, err)
+	}
+	buf.Reset()
+	if err := text.Execute(&buf, data); err != nil {
+		return out, fmt.Errorf("%s text: %v", name, err)
+	}
+	out.Text = buf.String()
+	return out, nil
+}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Check validates a template about to be saved by rendering with it: an
+// email with its type's sample data, a layout or partial inside every email
+func Check(t *models.EmailTemplate) error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Locale == "" {
+		t.Locale = i18n.Default
+	}
+	if !i18n.IsSupported(t.Locale) {
+		return fmt.Errorf("locale must be one of %s", strings.Join(i18n.Supported, ", "))
+	}
+	switch t.Kind {
+	case KindEmail:
+		if _, ok := Types[t.Name]; !ok {
+			return fmt.Errorf("name must be one of %s", strings.Join(TypeNames(), ", "))
+		}
+		if strings.TrimSpace(t.Subject) == "" {
+			return fmt.Errorf("subject is required")
+		}
+	case KindLayout, KindPartial:
+		if !namePattern.MatchString(t.Name) || t.Name == "content" {
+			return fmt.Errorf("name must be lower case letters, digits, - and _, and not \"content\"")
+		}
+		// Layouts and partials share one namespace when rendered
+		for _, b := range builtin {
+			if b.Name == t.Name && b.Kind != t.Kind {
+				return fmt.Errorf("%q is already the name of a %s", t.Name, b.Kind)
+			}
+		}
+		if database.DB != nil {
+			var other int64
+			err := database.DB.Model(&models.EmailTemplate{}).Where("name = ? AND kind <> ?", t.Name, t.Kind).Count(&other).Error
+			if err != nil {
+				return err
+			}
+			if other > 0 {
+				return fmt.Errorf("%q is already the name of another kind of template", t.Name)
+			}
+		}
+		t.Layout, t.Subject, t.Text = "", "", ""
+		if _, err := htmltemplate.New(t.Name).Funcs(funcs("")).Parse(t.HTML); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("kind must be email, layout or partial")
+	}
+
+	names := []string{t.Name}
+	if t.Kind != KindEmail {
+		names = TypeNames()
+	}
+	s, err := loadSet([]string{t.Locale}, []models.EmailTemplate{*t})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := s.render(name, Types[name].Sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Preview renders a template, saved or not, with sample data. A layout or
+// partial is shown inside the email named, or the first type.
+func Preview(t models.EmailTemplate, email string) (Rendered, error) {
+	if t.Kind == KindEmail {
+		email = t.Name
+	} else if email == "" {
+		email = TypeNames()[0]
+	}
+	typ, ok := Types[email]
+	if !ok {
+		return Rendered{}, fmt.Errorf("email must be one of %s", strings.Join(TypeNames(), ", "))
+	}
+	s, err := loadSet([]string{t.Locale}, []models.EmailTemplate{t})
+	if err != nil {
+		return Rendered{}, err
+	}
+	return s.render(email, typ.Sample)
+}
@@ -0,0 +1,87 @@
+package mailtmpl
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	spaces     = regexp.MustCompile(`[ \t\r\n\f]+`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// blocks start and end on a line of their own
+var blocks = map[string]bool{
+	"p": true, "div": true, "table": true, "tr": true, "ul": true, "ol": true,
+	"blockquote": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "footer": true, "section": true, "article": true,
+}
+
+// ToText turns an HTML email into its plain text alternative: paragraphs on
+// their own lines, links followed by their address, list items as dashes
+func ToText(doc string) (string, error) {
+	root, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	var walk func(*html.Node, string)
+	walk = func(n *html.Node, prefix string) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(spaces.ReplaceAllString(n.Data, " "))
+			return
+		case html.ElementNode:
+			switch n.Data {
+			case "head", "style", "script", "title":
+				return
+			case "br":
+				b.WriteString("\n" + prefix)
+				return
+			case "hr":
+				b.WriteString("\n\n----\n\n")
+				return
+			case "img":
+				b.WriteString(attr(n, "alt"))
+				return
+			case "li":
+				b.WriteString("\n" + prefix + "- ")
+			case "td", "th":
+				b.WriteString(" ")
+			case "blockquote":
+				prefix += "> "
+			}
+			if blocks[n.Data] {
+				b.WriteString("\n\n" + prefix)
+			}
+		}
+
+		start := b.Len()
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, prefix)
+		}
+
+		if n.Type == html.ElementNode {
+			if n.Data == "a" {
+				href := attr(n, "href")
+				label := strings.TrimSpace(b.String()[start:])
+				if href != "" && !strings.HasPrefix(href, "#") && href != label && strings.TrimPrefix(href, "mailto:") != label {
+					b.WriteString(" (" + href + ")")
+				}
+			}
+			if blocks[n.Data] {
+				b.WriteString("\n\n")
+			}
+		}
+	}
+	walk(root, "")
+
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text := blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text) + "\n", nil
+}
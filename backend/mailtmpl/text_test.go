@@ -0,0 +1,61 @@
+package mailtmpl
+
+import "testing"
+
+func TestToText(t *testing.T) {
+	tests := []struct {
+		name, html, want string
+	}{
+		{
+			name: "paragraphs and spacing",
+			html: "<p>Hello   there,\n  reader.</p><p>Second</p>",
+			want: "Hello there, reader.\n\nSecond\n",
+		},
+		{
+			name: "head, style and script left out",
+			html: "<html><head><title>T</title><style>p{color:red}</style></head><body><script>x()</script><p>Body</p></body></html>",
+			want: "Body\n",
+		},
+		{
+			name: "links followed by their address",
+			html: `<p>Read <a href="https://yiaga.org/report">the report</a>.</p>`,
+			want: "Read the report (https://yiaga.org/report).\n",
+		},
+		{
+			name: "links that are their own label, mailto or anchors",
+			html: `<p><a href="https://yiaga.org">https://yiaga.org</a> <a href="mailto:info@yiaga.org">info@yiaga.org</a> <a href="#top">top</a></p>`,
+			want: "https://yiaga.org info@yiaga.org top\n",
+		},
+		{
+			name: "lists",
+			html: "<p>Topics:</p><ul><li>Elections</li><li>Youth</li></ul>",
+			want: "Topics:\n\n- Elections\n- Youth\n",
+		},
+		{
+			name: "quotes",
+			html: "<p>She wrote:</p><blockquote>First line<br>second line</blockquote><p>End</p>",
+			want: "She wrote:\n\n> First line\n> second line\n\nEnd\n",
+		},
+		{
+			name: "rules, images and table cells",
+			html: `<p>Above</p><hr><table><tr><td><img alt="Logo"></td><td>Yiaga</td></tr></table>`,
+			want: "Above\n\n----\n\nLogo Yiaga\n",
+		},
+		{
+			name: "blank lines collapse",
+			html: "<div><div><p>One</p></div></div><div></div><p>Two</p>",
+			want: "One\n\nTwo\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToText(tt.html)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ToText(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"yiaga-backend/analytics"
 	"yiaga-backend/database"
+	"yiaga-backend/handlers"
+	"yiaga-backend/mail"
+	"yiaga-backend/newsletter"
 	"yiaga-backend/routes"
 	"yiaga-backend/seeds"
+	"yiaga-backend/spam"
+	"yiaga-backend/wordpress"
 )
 
 func main() {
@@ -20,9 +28,33 @@ func main() {
 	// 1. Initialize DB with retries (Update your database.Init to handle this)
 	database.Init(dsn)
 
+	// One-off commands, e.g. `yiaga-backend import-wordpress export.xml`
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	// 2. Seed data (Consider doing this asynchronously if it's large)
 	go seeds.SeedData()
 
+	// Fold finished days of post views into daily totals
+	go analytics.RunRollups(time.Hour)
+
+	// Keep the comment spam classifier learning from moderators' decisions
+	go spam.RunTraining(6 * time.Hour)
+
+	// Extract the text of linked PDFs, catching up on any not yet extracted
+	handlers.StartPDFIndexer()
+
+	// Send queued emails, retrying failures with backoff
+	go mail.RunQueue(30 * time.Second)
+
+	// Forget newsletter sign ups that were never confirmed, and end pauses
+	go newsletter.RunMaintenance(time.Hour)
+
+	// Deliver scheduled newsletter campaigns, rate limited by CAMPAIGN_RATE
+	go newsletter.RunCampaigns(30 * time.Second)
+
 	r := routes.SetupRouter()
 
 	// 3. Add a simple health check route in your routes/setup
@@ -40,3 +72,37 @@ func main() {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
+
+// runCommand runs a maintenance command against the database instead of
+// starting the server
+func runCommand(name string, args []string) {
+	switch name {
+	case "import-wordpress":
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		opts := wordpress.DefaultOptions()
+		fs.StringVar(&opts.UploadsDir, "uploads", opts.UploadsDir, "local copy of wp-content/uploads")
+		fs.BoolVar(&opts.Download, "download", opts.Download, "download media missing from -uploads from the old site")
+		fs.BoolVar(&opts.Update, "update", false, "overwrite posts imported by an earlier run")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			log.Fatalf("usage: %s import-wordpress [-uploads dir] [-download] [-update] export.xml", os.Args[0])
+		}
+
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		report, err := wordpress.Import(f, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, warning := range report.Warnings {
+			log.Println("warning:", warning)
+		}
+		log.Printf("created %d, updated %d, unchanged %d, skipped %d, media %d, redirects %d",
+			report.Created, report.Updated, report.Unchanged, report.Skipped, report.Media, report.Redirects)
+	default:
+		log.Fatalf("unknown command %q", name)
+	}
+}
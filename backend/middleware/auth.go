@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
@@ -11,6 +12,17 @@ import (
 
 var JwtKey = []byte("my_secret_key") // In production, use os.Getenv("JWT_SECRET")
 
+type contextKey string
+
+const claimsKey contextKey = "claims"
+
+// ClaimsFromContext returns the token claims of an authenticated request, or
+// nil outside AuthMiddleware
+func ClaimsFromContext(ctx context.Context) *models.Claims {
+	claims, _ := ctx.Value(claimsKey).(*models.Claims)
+	return claims
+}
+
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
@@ -35,7 +47,42 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Context could be enriched here with user info
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+	})
+}
+
+// RequireRole lets through only users with one of the given roles. It goes
+// inside AuthMiddleware, for routes that anyone who signed up must not reach.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if claims := ClaimsFromContext(r.Context()); claims != nil {
+				for _, role := range roles {
+					if claims.Role == role {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
+
+// OptionalClaims parses the bearer token of a request on a public route, so
+// handlers can show signed in editors more than readers. It returns nil when
+// there is no valid token.
+func OptionalClaims(r *http.Request) *models.Claims {
+	bearerToken := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(bearerToken) != 2 {
+		return nil
+	}
+	claims := &models.Claims{}
+	token, err := jwt.ParseWithClaims(bearerToken[1], claims, func(token *jwt.Token) (interface{}, error) {
+		return JwtKey, nil
 	})
+	if err != nil || !token.Valid {
+		return nil
+	}
+	return claims
 }
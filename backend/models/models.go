@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -20,6 +21,19 @@ type Claims struct {
 
 // --- Database Models ---
 
+// Translations - Per-locale overrides of a record's text fields, keyed by
+// locale then JSON field name, e.g. {"fr": {"title": "..."}}
+type Translations map[string]map[string]string
+
+// Localized - Embedded in content models that can be translated
+type Localized struct {
+	Translations     Translations `json:"translations,omitempty" gorm:"serializer:json"`
+	Locale           string       `json:"locale,omitempty" gorm:"-"`            // Locale the response was rendered in
+	AvailableLocales []string     `json:"available_locales,omitempty" gorm:"-"` // Locales with at least one translated field
+}
+
+func (l *Localized) Localization() *Localized { return l }
+
 // Announcement - Updated by staff
 type Announcement struct {
 	gorm.Model
@@ -30,6 +44,11 @@ type Announcement struct {
 	Image       string    `json:"image"`
 	Status      string    `json:"status" gorm:"default:'published'"` // draft, published
 	PublishedAt time.Time `json:"published_at"`
+	Localized
+}
+
+func (a *Announcement) TranslatableFields() map[string]*string {
+	return map[string]*string{"title": &a.Title, "description": &a.Description}
 }
 
 // BlogPost - Blog posts and News items
@@ -47,8 +66,22 @@ type BlogPost struct {
 	Type        string    `json:"type"`                        // "blog" or "news"
 	Tags        []string  `json:"tags" gorm:"serializer:json"` // JSON array of tags
 	AuthorRole  string    `json:"author_role"`
-	PdfUrl      string    `json:"pdf_url"` // Optional link to PDF
+	PdfUrl      string    `json:"pdf_url"`            // Optional link to PDF
+	PdfText     string    `json:"-" gorm:"type:text"` // Extracted from PdfUrl for search and excerpts
+	PdfPages    int       `json:"pdf_pages"`
 	PublishedAt time.Time `json:"published_at"`
+	Version     int       `json:"version" gorm:"not null;default:1"` // Bumped on every save, see If-Match
+	LegacyID    uint      `json:"legacy_id,omitempty" gorm:"index"`  // WordPress post ID for imported posts
+	Status      string    `json:"status" gorm:"default:'published'"` // draft, published
+	// Approved comments, maintained by database.RefreshCommentStats and never
+	// written through the model
+	CommentCount  int        `json:"comment_count" gorm:"<-:false;not null;default:0"`
+	LastCommentAt *time.Time `json:"last_comment_at" gorm:"<-:false"`
+	Localized
+}
+
+func (p *BlogPost) TranslatableFields() map[string]*string {
+	return map[string]*string{"title": &p.Title, "excerpt": &p.Excerpt, "content": &p.Content}
 }
 
 // Initiative - Projects and Initiatives
@@ -66,6 +99,18 @@ type Initiative struct {
 	Activities      []string `json:"activities" gorm:"serializer:json"` // List of activities
 	Stats           []Stat   `json:"stats" gorm:"serializer:json"`
 	Color           string   `json:"color"`
+	PublishStatus   string   `json:"publish_status" gorm:"default:'published'"` // draft, published; Status is the programme's own state
+	Version         int      `json:"version" gorm:"not null;default:1"`         // Bumped on every save, see If-Match
+	Localized
+}
+
+func (i *Initiative) TranslatableFields() map[string]*string {
+	return map[string]*string{
+		"title":            &i.Title,
+		"description":      &i.Description,
+		"full_description": &i.FullDescription,
+		"content":          &i.Content,
+	}
 }
 
 type Stat struct {
@@ -77,10 +122,13 @@ type Stat struct {
 type Resource struct {
 	gorm.Model
 	Title       string    `json:"title"`
+	Slug        string    `json:"slug" gorm:"index"`
 	Description string    `json:"description"`
-	Type        string    `json:"type"`     // "PDF Report", "E-Book", "Video"
-	Category    string    `json:"category"` // "Reports", "Toolkits"
-	FileUrl     string    `json:"file_url"` // Link to download
+	Type        string    `json:"type"`               // "PDF Report", "E-Book", "Video"
+	Category    string    `json:"category"`           // "Reports", "Toolkits"
+	FileUrl     string    `json:"file_url"`           // Link to download
+	PdfText     string    `json:"-" gorm:"type:text"` // Extracted from FileUrl when it is a PDF
+	PdfPages    int       `json:"pdf_pages"`
 	FileSize    string    `json:"file_size"`
 	Downloads   string    `json:"downloads"` // Using string to match "2.5K" format
 	Date        string    `json:"date"`
@@ -92,6 +140,7 @@ type Resource struct {
 type Job struct {
 	gorm.Model
 	Title        string   `json:"title"`
+	Slug         string   `json:"slug" gorm:"index"`
 	Department   string   `json:"department"`
 	Location     string   `json:"location"`
 	Type         string   `json:"type"` // "Full-time", "Contract"
@@ -101,16 +150,69 @@ type Job struct {
 	IsActive     bool     `json:"is_active" gorm:"default:true"`
 }
 
-// Comment - Blog/News discussions
+// Comment - Discussions on blog/news posts, initiatives and resources
 type Comment struct {
 	gorm.Model
-	Content   string `json:"content"`
-	Author    string `json:"author"`
-	Email     string `json:"email"`
-	PostID    uint   `json:"post_id"`                         // ID of the blog/news post
-	PostTitle string `json:"post_title"`                      // Title for display in admin
-	Status    string `json:"status" gorm:"default:'pending'"` // pending, approved, rejected
-	Date      string `json:"date"`                            // Formatted date
+	Content    string `json:"content"`
+	Author     string `json:"author"`
+	Email      string `json:"email"`
+	TargetType string `json:"target_type" gorm:"not null;default:'blog';index:idx_comment_target"` // blog, initiative, resource
+	TargetID   uint   `json:"target_id" gorm:"index:idx_comment_target"`
+	PostTitle  string `json:"post_title"`                      // Title of the target, for display in admin
+	Status     string `json:"status" gorm:"default:'pending'"` // pending, approved, rejected
+	Date       string `json:"date"`                            // Formatted date
+	IPHash     string `json:"-" gorm:"index"`                  // Keyed hash of the submitter's IP, for rate limiting
+	ParentID   *uint  `json:"parent_id" gorm:"index"`          // Comment this replies to; nil for top level
+	Depth      int    `json:"depth"`                           // 0 for top level, bounded by maxCommentDepth
+	IsStaff    bool   `json:"is_staff"`                        // Written by a CMS user
+	Locale     string `json:"locale"`                          // Negotiated when written; emails to the commenter use it
+	// Spam classifier output, nil until a trained model scores the comment
+	SpamScore    *float64 `json:"spam_score"`
+	SpamReasons  []string `json:"spam_reasons" gorm:"serializer:json"`
+	AutoRejected bool     `json:"auto_rejected"` // Rejected by the classifier or a rule and not yet reviewed
+	// Moderation rules the comment matched, see ModerationRule
+	ModerationMatches []RuleMatch `json:"moderation_matches" gorm:"serializer:json"`
+}
+
+// SpamModel - The trained comment spam classifier; only the latest is kept
+type SpamModel struct {
+	gorm.Model
+	SpamDocs int    `json:"spam_docs"`
+	HamDocs  int    `json:"ham_docs"`
+	Features int    `json:"features"`
+	Data     string `json:"-" gorm:"type:text"` // JSON encoded spam.Classifier
+}
+
+// UsedFormToken - A comment form token already submitted, kept until it
+// expires so it cannot be replayed
+type UsedFormToken struct {
+	Token     string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// CommentUnsubscribe - An address that wants no more emails about a comment
+// thread, identified by its top level comment
+type CommentUnsubscribe struct {
+	gorm.Model
+	Email    string `json:"email" gorm:"uniqueIndex:idx_comment_unsubscribe"` // Lowercased
+	ThreadID uint   `json:"thread_id" gorm:"uniqueIndex:idx_comment_unsubscribe"`
+}
+
+// OutgoingEmail - A message waiting in, or sent from, the mail queue
+type OutgoingEmail struct {
+	gorm.Model
+	Kind          string            `json:"kind" gorm:"index"` // e.g. "comment.approved", "comment.reply"
+	To            string            `json:"to"`
+	Subject       string            `json:"subject"`
+	Text          string            `json:"-" gorm:"type:text"`
+	HTML          string            `json:"-" gorm:"type:text"`
+	Headers       map[string]string `json:"-" gorm:"serializer:json"`
+	Status        string            `json:"status" gorm:"default:'queued';index"` // queued, sending, sent, failed
+	Attempts      int               `json:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at" gorm:"index"`
+	ClaimedUntil  *time.Time        `json:"claimed_until"` // Lease of the instance sending it
+	LastError     string            `json:"last_error"`
+	SentAt        *time.Time        `json:"sent_at"`
 }
 
 // AuditLog - System activity
@@ -132,16 +234,94 @@ type ContactMessage struct {
 	Email   string `json:"email"`
 	Subject string `json:"subject"`
 	Message string `json:"message"`
-	Status  string `json:"status" gorm:"default:'new'"` // new, read, archived
+	Status  string `json:"status" gorm:"default:'new'"` // new, read, archived, held, rejected
+	// Moderation rules the message matched, see ModerationRule
+	ModerationMatches []RuleMatch `json:"moderation_matches" gorm:"serializer:json"`
 }
 
-// Subscriber - Newsletter Subscribers
+// Subscriber - Newsletter Subscribers, confirmed by email (double opt-in)
 type Subscriber struct {
 	gorm.Model
-	Email         string    `json:"email" gorm:"uniqueIndex"`
-	Subscriptions []string  `json:"subscriptions" gorm:"serializer:json"` // List of selected topics
-	IsActive      bool      `json:"is_active" gorm:"default:true"`
-	SubscribedAt  time.Time `json:"subscribed_at"`
+	Email              string     `json:"email" gorm:"uniqueIndex"`
+	Subscriptions      []string   `json:"subscriptions" gorm:"serializer:json"` // List of selected topics
+	Status             string     `json:"status" gorm:"default:'active';index"` // pending, active, paused, unsubscribed
+	IsActive           bool       `json:"is_active" gorm:"default:false"`       // Status is active
+	SubscribedAt       time.Time  `json:"subscribed_at"`                        // When the subscription was confirmed
+	ConfirmationSentAt *time.Time `json:"confirmation_sent_at"`
+	ConfirmedAt        *time.Time `json:"confirmed_at"`
+	PausedUntil        *time.Time `json:"paused_until"` // Resumes automatically then; nil pauses indefinitely
+}
+
+// SubscriberEvent - One change to a subscription, for churn reporting
+type SubscriberEvent struct {
+	gorm.Model
+	SubscriberID uint     `json:"subscriber_id" gorm:"index"`
+	Event        string   `json:"event" gorm:"index"` // signed_up, confirmed, topics_changed, paused, resumed, resubscribed, unsubscribed
+	FromStatus   string   `json:"from_status"`
+	ToStatus     string   `json:"to_status"`
+	Topics       []string `json:"topics" gorm:"serializer:json"` // Topics after the change
+	Source       string   `json:"source"`                        // form, email, preferences, one_click, schedule
+}
+
+// Campaign - A newsletter sent to the subscribers of some topics
+type Campaign struct {
+	gorm.Model
+	Name        string     `json:"name"` // For the admin list only
+	Subject     string     `json:"subject"`
+	HTML        string     `json:"html" gorm:"type:text"`               // html/template, see newsletter.Render
+	Text        string     `json:"text" gorm:"type:text"`               // text/template; derived from HTML when empty
+	Topics      []string   `json:"topics" gorm:"serializer:json"`       // Empty sends to every active subscriber
+	Status      string     `json:"status" gorm:"default:'draft';index"` // draft, scheduled, sending, sent, cancelled
+	ScheduledAt *time.Time `json:"scheduled_at"`
+	StartedAt   *time.Time `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	CreatedBy   uint       `json:"created_by"`
+	// Link bases captured when the campaign is scheduled, as delivery runs
+	// outside any request
+	PreferencesPage string `json:"-"`
+	UnsubscribeURL  string `json:"-"`
+}
+
+// CampaignRecipient - One subscriber's copy of a campaign, the unit of delivery
+type CampaignRecipient struct {
+	gorm.Model
+	CampaignID    uint       `json:"campaign_id" gorm:"uniqueIndex:idx_campaign_recipient"`
+	SubscriberID  uint       `json:"subscriber_id" gorm:"uniqueIndex:idx_campaign_recipient"`
+	Email         string     `json:"email"`
+	Status        string     `json:"status" gorm:"default:'queued';index"` // queued, sending, sent, failed, skipped
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	ClaimedUntil  *time.Time `json:"claimed_until"` // Lease of the instance sending it
+	LastError     string     `json:"last_error"`
+	SentAt        *time.Time `json:"sent_at"`
+}
+
+// EmailTemplate - An admin editable email, layout or partial in one locale.
+// Built in templates are seeded in English; see package mailtmpl.
+type EmailTemplate struct {
+	gorm.Model
+	Name      string `json:"name" gorm:"not null;uniqueIndex:idx_email_template"` // Email type (e.g. "comment.approved"), or layout or partial name
+	Kind      string `json:"kind" gorm:"not null"`                                // email, layout or partial
+	Locale    string `json:"locale" gorm:"not null;default:'en';uniqueIndex:idx_email_template"`
+	Layout    string `json:"layout"`                // Emails: the layout they are wrapped in, default "layout"
+	Subject   string `json:"subject"`               // Emails: text/template
+	HTML      string `json:"html" gorm:"type:text"` // html/template
+	Text      string `json:"text" gorm:"type:text"` // Emails: plain text, generated from the HTML when empty
+	Version   int    `json:"version" gorm:"not null;default:1"`
+	UpdatedBy uint   `json:"updated_by"`
+}
+
+// EmailTemplateVersion - A saved revision of an EmailTemplate
+type EmailTemplateVersion struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	TemplateID uint      `json:"template_id" gorm:"uniqueIndex:idx_email_template_version"`
+	Version    int       `json:"version" gorm:"uniqueIndex:idx_email_template_version"`
+	Layout     string    `json:"layout"`
+	Subject    string    `json:"subject"`
+	HTML       string    `json:"html" gorm:"type:text"`
+	Text       string    `json:"text" gorm:"type:text"`
+	EditedBy   uint      `json:"edited_by"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 // User - Admin Users for CMS
@@ -165,6 +345,103 @@ type HeroContent struct {
 	SecondCTAText   string `json:"second_cta_text"`
 	SecondCTALink   string `json:"second_cta_link"`
 	BackgroundImage string `json:"background_image"`
+	Localized
+}
+
+func (h *HeroContent) TranslatableFields() map[string]*string {
+	return map[string]*string{
+		"title":           &h.Title,
+		"title_highlight": &h.TitleHighlight,
+		"description":     &h.Description,
+		"cta_text":        &h.CTAText,
+		"second_cta_text": &h.SecondCTAText,
+	}
+}
+
+// CuratedSlot - A named, editor curated position on the site, e.g. "home.featured"
+type CuratedSlot struct {
+	gorm.Model
+	Key      string     `json:"key" gorm:"uniqueIndex"`
+	Label    string     `json:"label"`
+	MaxItems int        `json:"max_items"`                    // 0 means no limit
+	Fill     string     `json:"fill"`                         // Optional automatic content for unused positions, e.g. "latest_news"
+	Items    []SlotItem `json:"items" gorm:"serializer:json"` // Pinned content in display order
+}
+
+// ModerationRule - An admin managed check on submitted comments and contact
+// messages. A rule matches when its pattern, any of its keywords or its link
+// limit does; its action then holds the submission for review, rejects it or
+// masks the matched text.
+type ModerationRule struct {
+	gorm.Model
+	Name      string   `json:"name"`
+	AppliesTo []string `json:"applies_to" gorm:"serializer:json"` // "comment", "contact"
+	Pattern   string   `json:"pattern"`                           // Regular expression (RE2 syntax), optional
+	Keywords  []string `json:"keywords" gorm:"serializer:json"`   // Whole words or phrases, case insensitive
+	MaxLinks  *int     `json:"max_links"`                         // More distinct links than this matches; nil for no limit
+	Action    string   `json:"action"`                            // hold, reject, mask
+	IsActive  bool     `json:"is_active"`                         // New rules are active unless sent as false
+}
+
+// RuleMatch - Why a submission matched a ModerationRule
+type RuleMatch struct {
+	RuleID uint   `json:"rule_id"`
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+	Field  string `json:"field"`
+	Match  string `json:"match"` // The matched text, or e.g. "4 links"
+}
+
+// SlotItem - A reference to a post, initiative or resource pinned in a slot
+type SlotItem struct {
+	Type      string     `json:"type"` // "post", "initiative", "resource"
+	ID        uint       `json:"id"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// EditLock - A soft, expiring marker that an editor has a record open. It is
+// advisory only; saves are guarded by the record's Version.
+type EditLock struct {
+	gorm.Model
+	ContentType string    `json:"content_type" gorm:"uniqueIndex:idx_edit_lock"`
+	ContentID   uint      `json:"content_id" gorm:"uniqueIndex:idx_edit_lock"`
+	UserID      uint      `json:"user_id"`
+	Username    string    `json:"username"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Redirect - A permanent redirect from a legacy URL, e.g. an old WordPress permalink
+type Redirect struct {
+	gorm.Model
+	FromPath   string `json:"from_path" gorm:"uniqueIndex"` // Path, plus query string where it identifies the page ("/?p=123")
+	ToPath     string `json:"to_path"`
+	StatusCode int    `json:"status_code" gorm:"default:301"`
+}
+
+// ImportRun - One WordPress import, run in the background. A run cut short by
+// a restart stays running; importing the export again is safe.
+type ImportRun struct {
+	gorm.Model
+	Status    string          `json:"status" gorm:"default:'running'"` // running, done, failed
+	Filename  string          `json:"filename"`
+	Update    bool            `json:"update"`
+	Report    json.RawMessage `json:"report" gorm:"type:jsonb"` // wordpress.Report, once done
+	Error     string          `json:"error,omitempty"`
+	StartedBy uint            `json:"started_by"`
+}
+
+// PreviewToken - A revocable, expiring link that lets someone outside the CMS
+// read an unpublished record. The token itself is an HMAC over these fields.
+type PreviewToken struct {
+	gorm.Model
+	ContentType string     `json:"content_type" gorm:"index:idx_preview_content"` // "blog", "initiative", "announcement"
+	ContentID   uint       `json:"content_id" gorm:"index:idx_preview_content"`
+	Note        string     `json:"note"` // Who it was shared with, e.g. "Funder review"
+	CreatedBy   uint       `json:"created_by" gorm:"index"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	Uses        int        `json:"uses"`
 }
 
 // Partner - Partners & Supporters
@@ -182,3 +459,37 @@ type Badge struct {
 	Image       string `json:"image"`
 	Description string `json:"description"`
 }
+
+// PostVisit - One visitor's activity on a post for one day. Visitors are
+// identified only by a hash keyed with that day's salt, which is thrown away
+// once the day is over, so hashes cannot be linked across days or reversed.
+type PostVisit struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	PostID      uint      `json:"post_id" gorm:"uniqueIndex:idx_post_visit"`
+	Day         string    `json:"day" gorm:"size:10;uniqueIndex:idx_post_visit;index"` // YYYY-MM-DD (UTC)
+	VisitorHash string    `json:"-" gorm:"size:64;uniqueIndex:idx_post_visit"`
+	Views       int       `json:"views"`
+	MaxScroll   int       `json:"max_scroll"`   // Furthest scroll depth reached, in percent
+	ReadSeconds int       `json:"read_seconds"` // Time with the page visible
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// PostDailyStat - Per post daily rollup of PostVisit rows. Totals rather than
+// averages are stored so late data can simply be added in.
+type PostDailyStat struct {
+	ID             uint      `json:"id" gorm:"primarykey"`
+	PostID         uint      `json:"post_id" gorm:"uniqueIndex:idx_post_day"`
+	Day            string    `json:"day" gorm:"size:10;uniqueIndex:idx_post_day;index"`
+	Views          int       `json:"views"`
+	UniqueVisitors int       `json:"unique_visitors"`
+	ScrollTotal    int       `json:"scroll_total"`    // Sum of each visitor's max scroll depth
+	ReadSeconds    int       `json:"read_seconds"`    // Sum of each visitor's read time
+	CompletedReads int       `json:"completed_reads"` // Visitors who scrolled at least 90%
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// AnalyticsSalt - Secret mixed into visitor hashes; only the current day's is kept
+type AnalyticsSalt struct {
+	Day  string `gorm:"primarykey;size:10"`
+	Salt string
+}
@@ -0,0 +1,266 @@
+package moderation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+)
+
+// Targets rules can apply to
+const (
+	TargetComment = "comment"
+	TargetContact = "contact"
+)
+
+// Rule actions, from least to most severe
+const (
+	ActionMask   = "mask"
+	ActionHold   = "hold"
+	ActionReject = "reject"
+)
+
+// maxMatchesPerField keeps a flood of one word from bloating the record
+const maxMatchesPerField = 5
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+
+// Field is one named piece of submitted text. Masking rewrites it in place.
+type Field struct {
+	Name  string
+	Value *string
+}
+
+// Result is what the rules decided about a submission
+type Result struct {
+	Matches []models.RuleMatch
+	Hold    bool
+	Reject  bool
+}
+
+// Check runs the active rules for a target over the fields of a submission
+func Check(target string, fields ...Field) (Result, error) {
+	var rules []models.ModerationRule
+	if err := database.DB.Where("is_active = ?", true).Order("id").Find(&rules).Error; err != nil {
+		return Result{}, err
+	}
+	return Apply(rules, target, fields...), nil
+}
+
+// Validate checks a rule before it is saved
+func Validate(rule *models.ModerationRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch rule.Action {
+	case ActionMask, ActionHold, ActionReject:
+	default:
+		return fmt.Errorf("action must be hold, reject or mask")
+	}
+	if len(rule.AppliesTo) == 0 {
+		rule.AppliesTo = []string{TargetComment, TargetContact}
+	}
+	for _, t := range rule.AppliesTo {
+		if t != TargetComment && t != TargetContact {
+			return fmt.Errorf("applies_to may only contain comment and contact")
+		}
+	}
+	var keywords []string
+	for _, k := range rule.Keywords {
+		if k = strings.TrimSpace(k); k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	rule.Keywords = keywords
+	if rule.Pattern != "" {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern: %v", err)
+		}
+		// It would match every submission
+		if re.MatchString("") {
+			return fmt.Errorf("pattern must not match empty text")
+		}
+	}
+	if rule.MaxLinks != nil && *rule.MaxLinks < 0 {
+		return fmt.Errorf("max_links cannot be negative")
+	}
+	if rule.Pattern == "" && len(rule.Keywords) == 0 && rule.MaxLinks == nil {
+		return fmt.Errorf("a rule needs a pattern, keywords or max_links")
+	}
+	return nil
+}
+
+// Apply runs the given rules over the fields. Every match is recorded; mask
+// rules rewrite the matched text as asterisks, in the record too so that what
+// they hide is not kept, and the most severe action decides whether the
+// submission is held or rejected.
+func Apply(rules []models.ModerationRule, target string, fields ...Field) Result {
+	var result Result
+	for _, rule := range rules {
+		if !appliesTo(rule, target) {
+			continue
+		}
+		matched := false
+		record := func(field, text string) {
+			matched = true
+			if rule.Action == ActionMask {
+				text = mask(text, [][]int{{0, len(text)}})
+			}
+			result.Matches = append(result.Matches, models.RuleMatch{
+				RuleID: rule.ID, Rule: rule.Name, Action: rule.Action, Field: field, Match: truncate(text, 100),
+			})
+		}
+
+		var matchers []func(string) [][]int
+		if rule.Pattern != "" {
+			if re, err := regexp.Compile(rule.Pattern); err == nil {
+				matchers = append(matchers, func(s string) [][]int { return re.FindAllStringIndex(s, -1) })
+			}
+		}
+		if re := keywordPattern(rule.Keywords); re != nil {
+			matchers = append(matchers, func(s string) [][]int { return wholeWords(s, re.FindAllStringIndex(s, -1)) })
+		}
+		for _, f := range fields {
+			var spans [][]int
+			for _, match := range matchers {
+				for _, span := range match(*f.Value) {
+					// A pattern saved before empty matches were refused
+					if span[1] > span[0] {
+						spans = append(spans, span)
+					}
+				}
+			}
+			for i, span := range spans {
+				if i == maxMatchesPerField {
+					break
+				}
+				record(f.Name, (*f.Value)[span[0]:span[1]])
+			}
+			if rule.Action == ActionMask && len(spans) > 0 {
+				*f.Value = mask(*f.Value, spans)
+			}
+		}
+
+		if rule.MaxLinks != nil {
+			links := map[string]bool{}
+			for _, f := range fields {
+				for _, link := range linkPattern.FindAllString(*f.Value, -1) {
+					links[strings.ToLower(link)] = true
+				}
+			}
+			if len(links) > *rule.MaxLinks {
+				record("links", fmt.Sprintf("%d links", len(links)))
+				if rule.Action == ActionMask {
+					for _, f := range fields {
+						*f.Value = mask(*f.Value, linkPattern.FindAllStringIndex(*f.Value, -1))
+					}
+				}
+			}
+		}
+
+		if matched {
+			switch rule.Action {
+			case ActionReject:
+				result.Reject = true
+			case ActionHold:
+				result.Hold = true
+			}
+		}
+	}
+	return result
+}
+
+func appliesTo(rule models.ModerationRule, target string) bool {
+	if len(rule.AppliesTo) == 0 {
+		return true
+	}
+	for _, t := range rule.AppliesTo {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// keywordPattern matches any of the keywords, case insensitively, with any
+// run of whitespace between the words of a phrase
+func keywordPattern(keywords []string) *regexp.Regexp {
+	if len(keywords) == 0 {
+		return nil
+	}
+	alternatives := make([]string, 0, len(keywords))
+	for _, k := range keywords {
+		words := strings.Fields(k)
+		for i, w := range words {
+			words[i] = regexp.QuoteMeta(w)
+		}
+		if len(words) > 0 {
+			alternatives = append(alternatives, strings.Join(words, `\s+`))
+		}
+	}
+	if len(alternatives) == 0 {
+		return nil
+	}
+	re, err := regexp.Compile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`)
+	if err != nil {
+		return nil
+	}
+	re.Longest()
+	return re
+}
+
+// wholeWords keeps the spans not inside a longer word, so "ass" does not
+// match "class"
+func wholeWords(s string, spans [][]int) [][]int {
+	var kept [][]int
+	for _, span := range spans {
+		before, _ := utf8.DecodeLastRuneInString(s[:span[0]])
+		after, _ := utf8.DecodeRuneInString(s[span[1]:])
+		if !isWordRune(before) && !isWordRune(after) {
+			kept = append(kept, span)
+		}
+	}
+	return kept
+}
+
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+}
+
+// mask replaces each span with one asterisk per character
+func mask(s string, spans [][]int) string {
+	if len(spans) == 0 {
+		return s
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	var b strings.Builder
+	last := 0
+	for _, span := range spans {
+		start := span[0]
+		if start < last {
+			start = last // Overlapping matches
+		}
+		if start >= span[1] {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(s[start:span[1]])))
+		last = span[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max]) + "…"
+}
@@ -0,0 +1,176 @@
+package moderation
+
+import (
+	"testing"
+
+	"yiaga-backend/models"
+)
+
+func intPtr(n int) *int { return &n }
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    models.ModerationRule
+		target  string
+		content string
+		want    string   // Content after masking
+		matches []string // Recorded match texts
+		hold    bool
+		reject  bool
+	}{
+		{
+			name:    "keyword holds",
+			rule:    models.ModerationRule{Name: "scam", Keywords: []string{"wire transfer"}, Action: ActionHold},
+			content: "Send a Wire  Transfer today",
+			want:    "Send a Wire  Transfer today",
+			matches: []string{"Wire  Transfer"},
+			hold:    true,
+		},
+		{
+			name:    "keywords match whole words only",
+			rule:    models.ModerationRule{Name: "rude", Keywords: []string{"ass"}, Action: ActionReject},
+			content: "A class assignment",
+			want:    "A class assignment",
+		},
+		{
+			name:    "pattern rejects",
+			rule:    models.ModerationRule{Name: "pills", Pattern: `(?i)v[i1]agra`, Action: ActionReject},
+			content: "Cheap V1AGRA here",
+			want:    "Cheap V1AGRA here",
+			matches: []string{"V1AGRA"},
+			reject:  true,
+		},
+		{
+			name:    "mask rewrites the text and the record",
+			rule:    models.ModerationRule{Name: "phone", Pattern: `\d{3}-\d{4}`, Action: ActionMask},
+			content: "Call 555-1234 or 555-9876",
+			want:    "Call ******** or ********",
+			matches: []string{"********", "********"},
+		},
+		{
+			name:    "mask counts characters, not bytes",
+			rule:    models.ModerationRule{Name: "word", Keywords: []string{"café"}, Action: ActionMask},
+			content: "Café ok",
+			want:    "**** ok",
+			matches: []string{"****"},
+		},
+		{
+			name:    "overlapping keyword and pattern masks",
+			rule:    models.ModerationRule{Name: "both", Keywords: []string{"bad word"}, Pattern: `word here`, Action: ActionMask},
+			content: "a bad word here",
+			want:    "a *************",
+			matches: []string{"*********", "********"}, // Pattern matches first
+		},
+		{
+			name:    "too many links",
+			rule:    models.ModerationRule{Name: "links", MaxLinks: intPtr(1), Action: ActionHold},
+			content: "see https://a.example and www.b.example",
+			want:    "see https://a.example and www.b.example",
+			matches: []string{"2 links"},
+			hold:    true,
+		},
+		{
+			name:    "repeated links count once",
+			rule:    models.ModerationRule{Name: "links", MaxLinks: intPtr(1), Action: ActionHold},
+			content: "https://a.example https://A.example",
+			want:    "https://a.example https://A.example",
+		},
+		{
+			name:    "other target",
+			rule:    models.ModerationRule{Name: "contact only", Keywords: []string{"spam"}, AppliesTo: []string{TargetContact}, Action: ActionReject},
+			target:  TargetComment,
+			content: "spam",
+			want:    "spam",
+		},
+		{
+			name:    "empty matches are ignored",
+			rule:    models.ModerationRule{Name: "old rule", Pattern: `x?`, Action: ActionReject},
+			content: "nothing to see",
+			want:    "nothing to see",
+		},
+		{
+			name:    "matches per field are capped",
+			rule:    models.ModerationRule{Name: "x", Pattern: `x`, Action: ActionHold},
+			content: "x x x x x x x",
+			want:    "x x x x x x x",
+			matches: []string{"x", "x", "x", "x", "x"},
+			hold:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = TargetComment
+			}
+			content := tt.content
+			result := Apply([]models.ModerationRule{tt.rule}, target, Field{Name: "content", Value: &content})
+			if content != tt.want {
+				t.Errorf("content %q, want %q", content, tt.want)
+			}
+			var got []string
+			for _, m := range result.Matches {
+				got = append(got, m.Match)
+			}
+			if len(got) != len(tt.matches) {
+				t.Fatalf("matches %q, want %q", got, tt.matches)
+			}
+			for i := range got {
+				if got[i] != tt.matches[i] {
+					t.Errorf("matches %q, want %q", got, tt.matches)
+					break
+				}
+			}
+			if result.Hold != tt.hold || result.Reject != tt.reject {
+				t.Errorf("hold %v reject %v, want %v %v", result.Hold, result.Reject, tt.hold, tt.reject)
+			}
+		})
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		spans [][]int
+		want  string
+	}{
+		{"none", "hello", nil, "hello"},
+		{"one", "hello world", [][]int{{6, 11}}, "hello *****"},
+		{"out of order", "ab cd ef", [][]int{{6, 8}, {0, 2}}, "** cd **"},
+		{"overlapping", "abcdef", [][]int{{0, 4}, {2, 6}}, "******"},
+		{"nested", "abcdef", [][]int{{0, 6}, {2, 3}}, "******"},
+		{"multibyte", "café ok", [][]int{{0, 5}}, "**** ok"},
+	}
+	for _, tt := range tests {
+		if got := mask(tt.s, tt.spans); got != tt.want {
+			t.Errorf("%s: mask(%q, %v) = %q, want %q", tt.name, tt.s, tt.spans, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    models.ModerationRule
+		wantErr bool
+	}{
+		{"keyword rule", models.ModerationRule{Name: "a", Keywords: []string{" spam "}, Action: ActionHold}, false},
+		{"no name", models.ModerationRule{Keywords: []string{"spam"}, Action: ActionHold}, true},
+		{"bad action", models.ModerationRule{Name: "a", Keywords: []string{"spam"}, Action: "delete"}, true},
+		{"bad target", models.ModerationRule{Name: "a", Keywords: []string{"spam"}, AppliesTo: []string{"post"}, Action: ActionHold}, true},
+		{"bad pattern", models.ModerationRule{Name: "a", Pattern: `(`, Action: ActionHold}, true},
+		{"pattern matching empty text", models.ModerationRule{Name: "a", Pattern: `a*`, Action: ActionHold}, true},
+		{"optional pattern", models.ModerationRule{Name: "a", Pattern: `x?`, Action: ActionHold}, true},
+		{"blank keywords only", models.ModerationRule{Name: "a", Keywords: []string{" "}, Action: ActionHold}, true},
+		{"negative links", models.ModerationRule{Name: "a", MaxLinks: intPtr(-1), Action: ActionHold}, true},
+		{"zero links", models.ModerationRule{Name: "a", MaxLinks: intPtr(0), Action: ActionHold}, false},
+	}
+	for _, tt := range tests {
+		rule := tt.rule
+		if err := Validate(&rule); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
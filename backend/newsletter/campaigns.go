@@ -0,0 +1,459 @@
+package newsletter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"yiaga-backend/database"
+	"yiaga-backend/lease"
+	"yiaga-backend/mail"
+	"yiaga-backend/mailtmpl"
+	"yiaga-backend/models"
+)
+
+// Campaign states. A scheduled campaign starts sending at ScheduledAt, when
+// its recipients are listed; from then on every recipient is delivered on its
+// own, so a restart carries on where it stopped.
+const (
+	CampaignDraft     = "draft"
+	CampaignScheduled = "scheduled"
+	CampaignSending   = "sending"
+	CampaignSent      = "sent"
+	CampaignCancelled = "cancelled"
+)
+
+// Recipient states
+const (
+	RecipientQueued  = "queued"
+	RecipientSending = "sending" // Claimed by an instance, see SendDue
+	RecipientSent    = "sent"
+	RecipientFailed  = "failed"
+	RecipientSkipped = "skipped" // Unsubscribed or paused before their turn, or cancelled
+)
+
+// recipientBatch is how many recipients one pass claims
+const recipientBatch = 20
+
+// Message is what campaign templates are executed with
+type Message struct {
+	Email          string
+	PreferencesURL string
+	UnsubscribeURL string
+}
+
+// ParseCampaign checks a campaign's subject and templates
+func ParseCampaign(c *models.Campaign) error {
+	c.Subject = strings.TrimSpace(c.Subject)
+	switch {
+	case c.Subject == "":
+		return fmt.Errorf("subject is required")
+	case strings.TrimSpace(c.HTML) == "" && strings.TrimSpace(c.Text) == "":
+		return fmt.Errorf("html or text is required")
+	}
+	if _, err := CheckTopics(c.Topics); err != nil {
+		return err
+	}
+	if _, err := htmltemplate.New("html").Parse(c.HTML); err != nil {
+		return fmt.Errorf("html: %v", err)
+	}
+	if _, err := texttemplate.New("text").Parse(c.Text); err != nil {
+		return fmt.Errorf("text: %v", err)
+	}
+	return nil
+}
+
+// PreferencesLink adds a subscriber's preference token to a page or endpoint
+func PreferencesLink(base string, sub models.Subscriber) string {
+	return base + "?token=" + url.QueryEscape(PreferencesToken(sub))
+}
+
+// Render builds one subscriber's copy of a campaign. The campaign's HTML is
+// the body of the "newsletter.campaign" email template, whose layout and
+// partials link to the preference centre and to unsubscribe, and every copy
+// carries one-click unsubscribe headers. A subscriber without an ID, as in
+// test sends, gets the links without a token.
+func Render(c models.Campaign, sub models.Subscriber) (mail.Message, error) {
+	data := Message{Email: sub.Email, PreferencesURL: c.PreferencesPage, UnsubscribeURL: c.UnsubscribeURL}
+	if sub.ID != 0 {
+		data.PreferencesURL = PreferencesLink(c.PreferencesPage, sub)
+		data.UnsubscribeURL = PreferencesLink(c.UnsubscribeURL, sub)
+	}
+
+	var body, text bytes.Buffer
+	if strings.TrimSpace(c.HTML) != "" {
+		t, err := htmltemplate.New("html").Parse(c.HTML)
+		if err != nil {
+			return mail.Message{}, err
+		}
+		if err := t.Execute(&body, data); err != nil {
+			return mail.Message{}, err
+		}
+	}
+	email, err := mailtmpl.Render("newsletter.campaign", nil, mailtmpl.CampaignData{
+		Subject:        c.Subject,
+		Body:           htmltemplate.HTML(documentBody(body.String())),
+		PreferencesURL: data.PreferencesURL,
+		UnsubscribeURL: data.UnsubscribeURL,
+	})
+	if err != nil {
+		return mail.Message{}, err
+	}
+
+	m := mail.Message{To: sub.Email, Subject: email.Subject, Text: email.Text}
+	if strings.TrimSpace(c.HTML) != "" {
+		m.HTML = email.HTML
+	}
+	if strings.TrimSpace(c.Text) != "" {
+		t, err := texttemplate.New("text").Parse(c.Text)
+		if err != nil {
+			return mail.Message{}, err
+		}
+		if err := t.Execute(&text, data); err != nil {
+			return mail.Message{}, err
+		}
+		fmt.Fprintf(&text, "\n\n--\nYou are receiving this because you subscribed to Yiaga Africa updates.\nManage your subscription: %s\nUnsubscribe: %s\n",
+			data.PreferencesURL, data.UnsubscribeURL)
+		m.Text = text.String()
+	}
+	if sub.ID != 0 {
+		m.Headers = map[string]string{
+			"List-Unsubscribe":      "<" + data.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}
+	}
+	return m, nil
+}
+
+// documentBody is what is inside the body of a campaign written as a whole
+// HTML document, as the layout supplies its own
+func documentBody(html string) string {
+	lower := strings.ToLower(html)
+	start := strings.Index(lower, "<body")
+	if start < 0 {
+		return html
+	}
+	open := strings.Index(lower[start:], ">")
+	if open < 0 {
+		return html
+	}
+	start += open + 1
+	if end := strings.LastIndex(lower, "</body>"); end >= start {
+		return html[start:end]
+	}
+	return html[start:]
+}
+
+// wantsCampaign reports whether a subscriber chose any of a campaign's topics.
+// A campaign without topics goes to everyone.
+func wantsCampaign(c models.Campaign, sub models.Subscriber) bool {
+	if len(c.Topics) == 0 {
+		return true
+	}
+	for _, t := range c.Topics {
+		for _, s := range sub.Subscriptions {
+			if t == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// StartDue lists the recipients of campaigns whose time has come and marks
+// them sending. Listing is idempotent, so a campaign interrupted half way is
+// simply listed again.
+func StartDue() error {
+	var due []models.Campaign
+	err := database.DB.Where("status = ? AND scheduled_at <= ?", CampaignScheduled, time.Now()).Find(&due).Error
+	if err != nil {
+		return err
+	}
+	for _, c := range due {
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			// Another instance may be starting it already
+			result := tx.Model(&models.Campaign{}).Where("id = ? AND status = ?", c.ID, CampaignScheduled).
+				Updates(map[string]interface{}{"status": CampaignSending, "started_at": time.Now()})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+
+			var subs []models.Subscriber
+			if err := tx.Select("id", "email", "subscriptions").Where("status = ?", StatusActive).Find(&subs).Error; err != nil {
+				return err
+			}
+			var recipients []models.CampaignRecipient
+			for _, sub := range subs {
+				if wantsCampaign(c, sub) {
+					recipients = append(recipients, models.CampaignRecipient{
+						CampaignID: c.ID, SubscriberID: sub.ID, Email: sub.Email,
+						Status: RecipientQueued, NextAttemptAt: time.Now(),
+					})
+				}
+			}
+			if len(recipients) == 0 {
+				return nil
+			}
+			return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(recipients, 500).Error
+		})
+		if err != nil {
+			return fmt.Errorf("campaign %d: %v", c.ID, err)
+		}
+	}
+	return nil
+}
+
+// rate is the most campaign emails sent a minute: CAMPAIGN_RATE, default 60.
+// It applies per server instance.
+func rate() int {
+	if n, err := strconv.Atoi(os.Getenv("CAMPAIGN_RATE")); err == nil && n > 0 {
+		return n
+	}
+	return 60
+}
+
+var (
+	paceMu   sync.Mutex
+	lastSend time.Time
+)
+
+// pace waits for the next free slot under the rate limit
+func pace() {
+	paceMu.Lock()
+	defer paceMu.Unlock()
+	if wait := time.Until(lastSend.Add(gap())); wait > 0 {
+		time.Sleep(wait)
+	}
+	lastSend = time.Now()
+}
+
+// permanent reports whether the server refused a message for good (5xx),
+// so retrying cannot help
+func permanent(err error) bool {
+	var tpErr *textproto.Error
+	return errors.As(err, &tpErr) && tpErr.Code >= 500
+}
+
+// gap is the time pacing leaves between two sends
+func gap() time.Duration {
+	return time.Minute / time.Duration(rate())
+}
+
+// leaseTime is how long a claimed batch may take to send, on top of the
+// time pacing it needs
+func leaseTime() time.Duration {
+	return time.Duration(recipientBatch)*gap() + 10*time.Minute
+}
+
+// recipients hands due recipients of sending campaigns out to instances, see
+// package lease
+var recipients = lease.Queue{
+	Model:   &models.CampaignRecipient{},
+	Table:   "campaign_recipients",
+	Queued:  RecipientQueued,
+	Sending: RecipientSending,
+	Batch:   recipientBatch,
+	Scope: func(db *gorm.DB) *gorm.DB {
+		return db.Joins("JOIN campaigns ON campaigns.id = campaign_recipients.campaign_id AND campaigns.status = ? AND campaigns.deleted_at IS NULL", CampaignSending)
+	},
+}
+
+// SendDue delivers a batch of due recipients, each committed on its own, and
+// returns how many it handled. Instances can share the work. Sending stops
+// while a send and its pacing still fit in the lease, so a live instance
+// never has its claim taken back.
+func SendDue() (int, error) {
+	if !mail.Configured() {
+		return 0, nil
+	}
+	if err := recipients.Reclaim(); err != nil {
+		return 0, err
+	}
+	var due []models.CampaignRecipient
+	until, err := recipients.Claim(&due, leaseTime())
+	if err != nil || len(due) == 0 {
+		return 0, err
+	}
+
+	campaigns := map[uint]models.Campaign{}
+	for i, r := range due {
+		if time.Until(until) < gap()+mail.SendTimeout {
+			ids := make([]uint, 0, len(due)-i)
+			for _, rest := range due[i:] {
+				ids = append(ids, rest.ID)
+			}
+			return i, recipients.Release(ids)
+		}
+		c, ok := campaigns[r.CampaignID]
+		if !ok {
+			if err := database.DB.First(&c, r.CampaignID).Error; err != nil {
+				return i, err
+			}
+			campaigns[r.CampaignID] = c
+		}
+		if err := deliver(c, r); err != nil {
+			return i, err
+		}
+	}
+	return len(due), nil
+}
+
+// deliver sends one claimed recipient their copy and records the outcome.
+// The campaign and subscriber are read again first, so a cancellation or an
+// unsubscribe made while the batch was waiting is respected.
+func deliver(c models.Campaign, r models.CampaignRecipient) error {
+	var current models.Campaign
+	if err := database.DB.Select("id", "status").First(&current, c.ID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	var sub models.Subscriber
+	if err := database.DB.First(&sub, r.SubscriberID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	var update map[string]interface{}
+	if current.Status != CampaignSending || sub.Status != StatusActive {
+		update = map[string]interface{}{"status": RecipientSkipped}
+	} else {
+		update = sendRecipient(c, sub, r)
+	}
+	return recipients.Finish(r.ID, update)
+}
+
+// sendRecipient renders and sends one subscriber's copy, paced under the rate
+// limit, and returns the changes to their recipient row
+func sendRecipient(c models.Campaign, sub models.Subscriber, r models.CampaignRecipient) map[string]interface{} {
+	m, err := Render(c, sub)
+	if err != nil {
+		return map[string]interface{}{"status": RecipientFailed, "last_error": err.Error()}
+	}
+	pace()
+	r.Attempts++
+	update := map[string]interface{}{"attempts": r.Attempts}
+	sendErr := mail.Send(m)
+	switch {
+	case sendErr == nil:
+		update["status"] = RecipientSent
+		update["sent_at"] = time.Now()
+		update["last_error"] = ""
+	case r.Attempts >= mail.MaxAttempts || permanent(sendErr):
+		update["status"] = RecipientFailed
+		update["last_error"] = sendErr.Error()
+	default:
+		update["status"] = RecipientQueued
+		update["next_attempt_at"] = time.Now().Add(lease.Backoff(r.Attempts))
+		update["last_error"] = sendErr.Error()
+	}
+	return update
+}
+
+// FinishDone marks sending campaigns with nobody left to send to as sent
+func FinishDone() error {
+	return database.DB.Model(&models.Campaign{}).
+		Where("status = ? AND NOT EXISTS (SELECT 1 FROM campaign_recipients r WHERE r.campaign_id = campaigns.id AND r.status IN ? AND r.deleted_at IS NULL)",
+			CampaignSending, []string{RecipientQueued, RecipientSending}).
+		Updates(map[string]interface{}{"status": CampaignSent, "finished_at": time.Now()}).Error
+}
+
+// Cancel stops a scheduled or sending campaign; recipients not yet sent to
+// are skipped, those in a batch being sent as their turn comes
+func Cancel(id uint) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Campaign{}).Where("id = ? AND status IN ?", id, []string{CampaignScheduled, CampaignSending}).
+			Updates(map[string]interface{}{"status": CampaignCancelled, "finished_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("only scheduled or sending campaigns can be cancelled")
+		}
+		return tx.Model(&models.CampaignRecipient{}).Where("campaign_id = ? AND status = ?", id, RecipientQueued).
+			Update("status", RecipientSkipped).Error
+	})
+}
+
+// ErrNotRetryable is returned by RetryFailed for campaigns that are not
+// sending or sent, whose recipients would stay queued for good
+var ErrNotRetryable = errors.New("only sending or sent campaigns can be retried")
+
+// RetryFailed queues a campaign's failed recipients again with fresh attempts
+func RetryFailed(id uint) (int64, error) {
+	var count int64
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Locked, so a cancellation waits until the retry is in place
+		var c models.Campaign
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "status").First(&c, id).Error
+		if err != nil {
+			return err
+		}
+		if c.Status != CampaignSending && c.Status != CampaignSent {
+			return ErrNotRetryable
+		}
+		result := tx.Model(&models.CampaignRecipient{}).Where("campaign_id = ? AND status = ?", id, RecipientFailed).
+			Updates(map[string]interface{}{"status": RecipientQueued, "attempts": 0, "next_attempt_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		count = result.RowsAffected
+		if count == 0 {
+			return nil
+		}
+		return tx.Model(&models.Campaign{}).Where("id = ? AND status = ?", id, CampaignSent).
+			Updates(map[string]interface{}{"status": CampaignSending, "finished_at": nil}).Error
+	})
+	return count, err
+}
+
+// Stats counts each campaign's recipients by status
+func Stats(ids ...uint) (map[uint]map[string]int64, error) {
+	var rows []struct {
+		CampaignID uint
+		Status     string
+		Count      int64
+	}
+	err := database.DB.Model(&models.CampaignRecipient{}).
+		Select("campaign_id, status, count(*) AS count").
+		Where("campaign_id IN ?", ids).Group("campaign_id, status").Scan(&rows).Error
+	stats := map[uint]map[string]int64{}
+	for _, id := range ids {
+		stats[id] = map[string]int64{RecipientQueued: 0, RecipientSending: 0, RecipientSent: 0, RecipientFailed: 0, RecipientSkipped: 0}
+	}
+	for _, row := range rows {
+		stats[row.CampaignID][row.Status] = row.Count
+	}
+	return stats, err
+}
+
+// RunCampaigns starts due campaigns and delivers them, checking every poll
+// interval while there is nothing to send. Without SMTP_HOST recipients stay
+// queued until it is set.
+func RunCampaigns(poll time.Duration) {
+	for {
+		if err := StartDue(); err != nil {
+			log.Printf("campaigns: starting failed: %v", err)
+		}
+		handled, err := SendDue()
+		if err != nil {
+			log.Printf("campaigns: sending failed: %v", err)
+		}
+		if err := FinishDone(); err != nil {
+			log.Printf("campaigns: finishing failed: %v", err)
+		}
+		if handled == 0 || err != nil {
+			time.Sleep(poll)
+		}
+	}
+}
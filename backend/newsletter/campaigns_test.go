@@ -0,0 +1,301 @@
+package newsletter
+
+import (
+	htmltemplate "html/template"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"yiaga-backend/database"
+	"yiaga-backend/mail"
+	"yiaga-backend/mail/mailtest"
+	"yiaga-backend/models"
+)
+
+var testCampaign = models.Campaign{
+	Subject:         "Election update",
+	HTML:            `<p>Hello {{.Email}}</p>`,
+	PreferencesPage: "https://yiaga.org/newsletter/preferences",
+	UnsubscribeURL:  "https://api.yiaga.org/api/subscribe/unsubscribe",
+}
+
+// smtpSink starts a fake SMTP server with pacing turned down for tests
+func smtpSink(t *testing.T) *mailtest.Server {
+	t.Setenv("CAMPAIGN_RATE", "600000")
+	t.Setenv("MAIL_FROM", "Yiaga Africa <news@yiaga.org>")
+	return mailtest.Start(t)
+}
+
+// rejecting answers RCPT TO for the given addresses with code
+func rejecting(code int, addrs ...string) func(string) (int, string) {
+	return func(rcpt string) (int, string) {
+		for _, a := range addrs {
+			if rcpt == a {
+				return code, "mailbox unavailable"
+			}
+		}
+		return 0, ""
+	}
+}
+
+func TestSendRecipient(t *testing.T) {
+	sink := smtpSink(t)
+	sink.SetReply(func(rcpt string) (int, string) {
+		switch {
+		case strings.HasPrefix(rcpt, "busy"):
+			return 451, "try again later"
+		case strings.HasPrefix(rcpt, "gone"):
+			return 550, "no such user"
+		}
+		return 0, ""
+	})
+
+	tests := []struct {
+		name      string
+		email     string
+		attempts  int
+		status    string
+		delivered bool
+	}{
+		{"accepted", "reader@example.org", 0, RecipientSent, true},
+		{"temporary failure is retried", "busy@example.org", 0, RecipientQueued, false},
+		{"temporary failure on the last attempt", "busy@example.org", mail.MaxAttempts - 1, RecipientFailed, false},
+		{"permanent failure", "gone@example.org", 0, RecipientFailed, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(sink.Messages())
+			sub := models.Subscriber{Email: tt.email, Status: StatusActive}
+			sub.ID = uint(i + 1)
+			update := sendRecipient(testCampaign, sub, models.CampaignRecipient{Email: tt.email, Attempts: tt.attempts})
+
+			if update["status"] != tt.status {
+				t.Errorf("status = %v, want %s (last_error %v)", update["status"], tt.status, update["last_error"])
+			}
+			if update["attempts"] != tt.attempts+1 {
+				t.Errorf("attempts = %v, want %d", update["attempts"], tt.attempts+1)
+			}
+			if tt.status == RecipientQueued {
+				next, _ := update["next_attempt_at"].(time.Time)
+				if !next.After(time.Now()) {
+					t.Errorf("next_attempt_at = %v, want a time in the future", update["next_attempt_at"])
+				}
+			}
+			if tt.status != RecipientSent && update["last_error"] == "" {
+				t.Error("last_error is empty")
+			}
+			if delivered := len(sink.Messages()) > before; delivered != tt.delivered {
+				t.Errorf("delivered = %v, want %v", delivered, tt.delivered)
+			}
+		})
+	}
+
+	msgs := sink.Messages()
+	if len(msgs) == 0 {
+		t.Fatal("nothing delivered")
+	}
+	h := msgs[0].Header
+	sub := models.Subscriber{Email: "reader@example.org"}
+	sub.ID = 1
+	wantUnsubscribe := "<" + PreferencesLink(testCampaign.UnsubscribeURL, sub) + ">"
+	if got := h.Get("List-Unsubscribe"); got != wantUnsubscribe {
+		t.Errorf("List-Unsubscribe = %q, want %q", got, wantUnsubscribe)
+	}
+	if got := h.Get("List-Unsubscribe-Post"); got != "List-Unsubscribe=One-Click" {
+		t.Errorf("List-Unsubscribe-Post = %q", got)
+	}
+	if got := msgs[0].To; len(got) != 1 || got[0] != "reader@example.org" {
+		t.Errorf("delivered to %v", got)
+	}
+	if msgs[0].From != "news@yiaga.org" {
+		t.Errorf("envelope from %q", msgs[0].From)
+	}
+}
+
+func TestRender(t *testing.T) {
+	sub := models.Subscriber{Email: "reader@example.org"}
+	sub.ID = 7
+	c := testCampaign
+	c.HTML = `<html><head><title>Update</title></head><body><p>Hello {{.Email}}</p></body></html>`
+	m, err := Render(c, sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != c.Subject {
+		t.Errorf("subject %q", m.Subject)
+	}
+	if strings.Count(strings.ToLower(m.HTML), "<body") != 1 {
+		t.Errorf("campaign document nested in the layout:\n%s", m.HTML)
+	}
+	unsubscribe := PreferencesLink(c.UnsubscribeURL, sub)
+	for _, want := range []string{"Hello reader@example.org", "Yiaga Africa", htmltemplate.HTMLEscapeString(unsubscribe)} {
+		if !strings.Contains(m.HTML, want) {
+			t.Errorf("html has no %q:\n%s", want, m.HTML)
+		}
+	}
+	if !strings.Contains(m.Text, "Hello reader@example.org") || !strings.Contains(m.Text, unsubscribe) {
+		t.Errorf("text alternative:\n%s", m.Text)
+	}
+}
+
+var initTestDB sync.Once
+
+// testDB connects to TEST_DATABASE_URL, a throwaway Postgres database, and
+// empties the tables campaigns touch. Tests needing it are skipped without one.
+func testDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	initTestDB.Do(func() { database.Init(dsn) })
+	err := database.DB.Exec(`TRUNCATE campaigns, campaign_recipients, subscribers, subscriber_events RESTART IDENTITY`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func recipientStatuses(t *testing.T, campaignID uint) map[string]models.CampaignRecipient {
+	t.Helper()
+	var rows []models.CampaignRecipient
+	if err := database.DB.Where("campaign_id = ?", campaignID).Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	byEmail := map[string]models.CampaignRecipient{}
+	for _, r := range rows {
+		byEmail[r.Email] = r
+	}
+	return byEmail
+}
+
+func TestSendDue(t *testing.T) {
+	testDB(t)
+	sink := smtpSink(t)
+	sink.SetReply(rejecting(451, "busy@example.org"))
+
+	var subs []models.Subscriber
+	for _, email := range []string{"one@example.org", "two@example.org", "busy@example.org", "leaving@example.org"} {
+		subs = append(subs, models.Subscriber{Email: email, Status: StatusActive, IsActive: true})
+	}
+	if err := database.DB.Create(&subs).Error; err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c := testCampaign
+	c.Status, c.ScheduledAt = CampaignScheduled, &now
+	if err := database.DB.Create(&c).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := StartDue(); err != nil {
+		t.Fatal(err)
+	}
+	// Unsubscribing after the recipients are listed still counts
+	if err := SetStatus(&subs[3], StatusUnsubscribed, nil, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	handled, err := SendDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handled != 4 {
+		t.Errorf("handled %d recipients, want 4", handled)
+	}
+	got := recipientStatuses(t, c.ID)
+	for email, want := range map[string]string{
+		"one@example.org":     RecipientSent,
+		"two@example.org":     RecipientSent,
+		"busy@example.org":    RecipientQueued,
+		"leaving@example.org": RecipientSkipped,
+	} {
+		r := got[email]
+		if r.Status != want {
+			t.Errorf("%s: status %q, want %q", email, r.Status, want)
+		}
+		if r.ClaimedUntil != nil {
+			t.Errorf("%s: still claimed until %v", email, r.ClaimedUntil)
+		}
+	}
+	if busy := got["busy@example.org"]; busy.Attempts != 1 || busy.LastError == "" || !busy.NextAttemptAt.After(time.Now()) {
+		t.Errorf("busy@example.org: attempts %d, last error %q, next attempt %v", busy.Attempts, busy.LastError, busy.NextAttemptAt)
+	}
+	if n := len(sink.Messages()); n != 2 {
+		t.Errorf("%d messages delivered, want 2", n)
+	}
+	for _, m := range sink.Messages() {
+		if !strings.Contains(m.Header.Get("List-Unsubscribe"), "?token=") {
+			t.Errorf("%v: List-Unsubscribe %q has no token", m.To, m.Header.Get("List-Unsubscribe"))
+		}
+	}
+
+	// The retry is not due yet, so the campaign is not finished
+	if err := FinishDone(); err != nil {
+		t.Fatal(err)
+	}
+	database.DB.First(&c, c.ID)
+	if c.Status != CampaignSending {
+		t.Errorf("campaign %s with a retry pending", c.Status)
+	}
+
+	// An instance that claimed the retry and died leaves it sending; once
+	// its lease has run out the recipient is sent to, and nobody else again
+	sink.SetReply(nil)
+	expired := time.Now().Add(-time.Minute)
+	database.DB.Model(&models.CampaignRecipient{}).Where("email = ?", "busy@example.org").
+		Updates(map[string]interface{}{"status": RecipientSending, "claimed_until": expired, "next_attempt_at": expired})
+	if _, err := SendDue(); err != nil {
+		t.Fatal(err)
+	}
+	if r := recipientStatuses(t, c.ID)["busy@example.org"]; r.Status != RecipientSent || r.Attempts != 2 {
+		t.Errorf("busy@example.org after reclaim: status %q, attempts %d", r.Status, r.Attempts)
+	}
+	if n := len(sink.Messages()); n != 3 {
+		t.Errorf("%d messages delivered, want 3", n)
+	}
+
+	if err := FinishDone(); err != nil {
+		t.Fatal(err)
+	}
+	database.DB.First(&c, c.ID)
+	if c.Status != CampaignSent {
+		t.Errorf("campaign %s, want sent", c.Status)
+	}
+}
+
+func TestRetryFailed(t *testing.T) {
+	testDB(t)
+	for _, tt := range []struct {
+		status  string
+		wantErr error
+		want    string // Recipient status after the retry
+	}{
+		{CampaignSent, nil, RecipientQueued},
+		{CampaignSending, nil, RecipientQueued},
+		{CampaignCancelled, ErrNotRetryable, RecipientFailed},
+		{CampaignDraft, ErrNotRetryable, RecipientFailed},
+	} {
+		c := testCampaign
+		c.Status = tt.status
+		if err := database.DB.Create(&c).Error; err != nil {
+			t.Fatal(err)
+		}
+		r := models.CampaignRecipient{CampaignID: c.ID, SubscriberID: 1, Email: "failed@example.org", Status: RecipientFailed, Attempts: mail.MaxAttempts}
+		if err := database.DB.Create(&r).Error; err != nil {
+			t.Fatal(err)
+		}
+		n, err := RetryFailed(c.ID)
+		if err != tt.wantErr {
+			t.Errorf("%s campaign: RetryFailed = %d, %v, want error %v", tt.status, n, err, tt.wantErr)
+		}
+		database.DB.First(&r, r.ID)
+		if r.Status != tt.want {
+			t.Errorf("%s campaign: recipient %s, want %s", tt.status, r.Status, tt.want)
+		}
+		database.DB.First(&c, c.ID)
+		if tt.wantErr == nil && c.Status != CampaignSending {
+			t.Errorf("%s campaign: %s after retry, want sending", tt.status, c.Status)
+		}
+	}
+}
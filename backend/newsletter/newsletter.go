@@ -0,0 +1,141 @@
+package newsletter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"yiaga-backend/database"
+	"yiaga-backend/middleware"
+	"yiaga-backend/models"
+)
+
+// Subscriber states. Only active subscribers receive newsletters; IsActive
+// mirrors Status == StatusActive for older queries.
+const (
+	StatusPending      = "pending" // Signed up, waiting for the emailed confirmation
+	StatusActive       = "active"
+	StatusPaused       = "paused"
+	StatusUnsubscribed = "unsubscribed"
+)
+
+// ResendInterval is the least time between two confirmation emails to one
+// address, so the form cannot be used to flood someone's inbox
+const ResendInterval = 10 * time.Minute
+
+func secret() []byte {
+	if s := os.Getenv("NEWSLETTER_SECRET"); s != "" {
+		return []byte(s)
+	}
+	return middleware.JwtKey
+}
+
+// ConfirmTTL is how long a confirmation link works before the pending
+// subscriber is purged: NEWSLETTER_CONFIRM_TTL (e.g. "72h"), default 7 days
+func ConfirmTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("NEWSLETTER_CONFIRM_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 7 * 24 * time.Hour
+}
+
+// sign binds a purpose and some subscriber state to a token, as "id.mac"
+func sign(purpose string, sub models.Subscriber, state string) string {
+	mac := hmac.New(sha256.New, secret())
+	fmt.Fprintf(mac, "newsletter:%s:%d:%s:%s", purpose, sub.ID, strings.ToLower(sub.Email), state)
+	return fmt.Sprintf("%d.%s", sub.ID, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18]))
+}
+
+// lookup finds the subscriber a token names and checks its signature
+func lookup(token, purpose string, state func(models.Subscriber) string) (models.Subscriber, bool) {
+	var sub models.Subscriber
+	idPart, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return sub, false
+	}
+	id, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		return sub, false
+	}
+	if err := database.DB.First(&sub, id).Error; err != nil {
+		return sub, false
+	}
+	return sub, hmac.Equal([]byte(sign(purpose, sub, state(sub))), []byte(token))
+}
+
+func confirmState(sub models.Subscriber) string {
+	if sub.ConfirmationSentAt == nil {
+		return ""
+	}
+	return strconv.FormatInt(sub.ConfirmationSentAt.Unix(), 10)
+}
+
+// ConfirmToken is the token of the confirmation link last sent to a
+// subscriber; sending a new one replaces it
+func ConfirmToken(sub models.Subscriber) string {
+	return sign("confirm", sub, confirmState(sub))
+}
+
+// Confirm activates the subscriber a confirmation token belongs to: a new,
+// pending one, or one who confirmed before and has since paused or
+// unsubscribed and signed up again. Confirming twice is harmless.
+func Confirm(token string) (models.Subscriber, error) {
+	sub, ok := lookup(token, "confirm", confirmState)
+	if !ok || sub.ConfirmationSentAt == nil {
+		return sub, fmt.Errorf("this confirmation link is not valid")
+	}
+	if sub.Status == StatusActive {
+		return sub, nil
+	}
+	if (sub.Status != StatusPending && sub.ConfirmedAt == nil) || time.Since(*sub.ConfirmationSentAt) > ConfirmTTL() {
+		return sub, fmt.Errorf("this confirmation link has expired, please subscribe again")
+	}
+	err := SetStatus(&sub, StatusActive, nil, "email")
+	return sub, err
+}
+
+// PurgeUnconfirmed deletes subscribers who never confirmed within ConfirmTTL.
+// They are removed outright: an address nobody confirmed is not kept. Anyone
+// who confirmed at some point keeps their record and its history.
+func PurgeUnconfirmed() (int64, error) {
+	result := database.DB.Unscoped().
+		Where("status = ? AND confirmed_at IS NULL AND (confirmation_sent_at IS NULL OR confirmation_sent_at < ?)", StatusPending, time.Now().Add(-ConfirmTTL())).
+		Delete(&models.Subscriber{})
+	return result.RowsAffected, result.Error
+}
+
+// ResumePaused reactivates subscribers whose pause has run out
+func ResumePaused() error {
+	var due []models.Subscriber
+	if err := database.DB.Where("status = ? AND paused_until <= ?", StatusPaused, time.Now()).Find(&due).Error; err != nil {
+		return err
+	}
+	for i := range due {
+		if err := SetStatus(&due[i], StatusActive, nil, "schedule"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunMaintenance purges unconfirmed sign ups and ends pauses, on startup and
+// then every interval
+func RunMaintenance(interval time.Duration) {
+	for {
+		if n, err := PurgeUnconfirmed(); err != nil {
+			log.Printf("newsletter purge failed: %v", err)
+		} else if n > 0 {
+			log.Printf("newsletter: purged %d unconfirmed subscribers", n)
+		}
+		if err := ResumePaused(); err != nil {
+			log.Printf("newsletter: resuming paused subscribers failed: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,216 @@
+package newsletter
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+)
+
+// Topics subscribers can choose from
+var Topics = []string{
+	"Monthly Newsletter",
+	"Weekly Election News Update (The Ballot)",
+	"GenZ Blog Series",
+	"Research, Reports, Policy Briefs & Knowledge Products",
+	"Press Releases, Stories & Democracy Updates",
+	"Opportunities: Events Webinars & Open Calls",
+}
+
+// MaxPause is the longest a subscription can be paused for with an end date
+const MaxPause = 365 * 24 * time.Hour
+
+// CheckTopics removes duplicates and rejects topics not in Topics
+func CheckTopics(topics []string) ([]string, error) {
+	known := map[string]bool{}
+	for _, t := range Topics {
+		known[t] = true
+	}
+	seen := map[string]bool{}
+	out := []string{}
+	for _, t := range topics {
+		if !known[t] {
+			return nil, fmt.Errorf("unknown topic %q", t)
+		}
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// PreferencesToken gives access to a subscriber's preference centre. It does
+// not expire, as it is in every newsletter sent, but stops working if the
+// address changes.
+func PreferencesToken(sub models.Subscriber) string {
+	return sign("preferences", sub, "")
+}
+
+// FromPreferencesToken finds the subscriber a preference centre token is for
+func FromPreferencesToken(token string) (models.Subscriber, bool) {
+	return lookup(token, "preferences", func(models.Subscriber) string { return "" })
+}
+
+// eventFor names a status change
+func eventFor(from, to string) string {
+	switch {
+	case from == to:
+		return "topics_changed"
+	case to == StatusPending:
+		return "signed_up"
+	case to == StatusActive && from == StatusPending:
+		return "confirmed"
+	case to == StatusActive && from == StatusUnsubscribed:
+		return "resubscribed"
+	case to == StatusActive:
+		return "resumed"
+	case to == StatusPaused:
+		return "paused"
+	}
+	return "unsubscribed"
+}
+
+// Record logs a change to a subscriber, already saved, that moved it from
+// the given status
+func Record(db *gorm.DB, sub models.Subscriber, from, source string) error {
+	return db.Create(&models.SubscriberEvent{
+		SubscriberID: sub.ID,
+		Event:        eventFor(from, sub.Status),
+		FromStatus:   from,
+		ToStatus:     sub.Status,
+		Topics:       sub.Subscriptions,
+		Source:       source,
+	}).Error
+}
+
+// SetStatus moves a subscriber to a new status and records the change.
+// pausedUntil only applies to StatusPaused. Setting the current status again
+// is not recorded.
+func SetStatus(sub *models.Subscriber, status string, pausedUntil *time.Time, source string) error {
+	from := sub.Status
+	if from == status && (status != StatusPaused || samePause(sub.PausedUntil, pausedUntil)) {
+		return nil
+	}
+	update := map[string]interface{}{"status": status, "is_active": status == StatusActive, "paused_until": nil}
+	if status == StatusPaused {
+		update["paused_until"] = pausedUntil
+	}
+	if status == StatusActive && from == StatusPending {
+		now := time.Now()
+		update["confirmed_at"], update["subscribed_at"] = now, now
+		sub.ConfirmedAt, sub.SubscribedAt = &now, now
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(sub).Updates(update).Error; err != nil {
+			return err
+		}
+		sub.Status, sub.IsActive = status, status == StatusActive
+		sub.PausedUntil = nil
+		if status == StatusPaused {
+			sub.PausedUntil = pausedUntil
+		}
+		if from == status {
+			return nil // A new end date for a pause
+		}
+		return Record(tx, *sub, from, source)
+	})
+}
+
+func samePause(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// SetTopics replaces a subscriber's topics and records the change
+func SetTopics(sub *models.Subscriber, topics []string, source string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		sub.Subscriptions = topics
+		if err := tx.Model(sub).Select("subscriptions").Updates(sub).Error; err != nil {
+			return err
+		}
+		return Record(tx, *sub, sub.Status, source)
+	})
+}
+
+// ChurnDay - Subscribers gained and lost on one day
+type ChurnDay struct {
+	Date   string `json:"date"`
+	Gained int    `json:"gained"`
+	Lost   int    `json:"lost"`
+}
+
+// ChurnReport - Movement in and out of the active state over a period. A
+// pause counts as lost and its end as gained, as neither receives mail.
+type ChurnReport struct {
+	Days          int        `json:"days"`
+	StartedWith   int64      `json:"started_with"` // Active subscribers when the period began
+	Gained        int        `json:"gained"`
+	Lost          int        `json:"lost"`
+	Unsubscribed  int        `json:"unsubscribed"`
+	Paused        int        `json:"paused"`
+	TopicsChanged int        `json:"topics_changed"`
+	ChurnRate     float64    `json:"churn_rate"` // Lost / StartedWith
+	ByDay         []ChurnDay `json:"by_day"`
+}
+
+// Churn reports the last days of subscriber events against the active count
+func Churn(days int) (ChurnReport, error) {
+	report := ChurnReport{Days: days, ByDay: []ChurnDay{}}
+	var rows []struct {
+		Day    string
+		Event  string
+		Gained bool
+		Lost   bool
+		Count  int
+	}
+	err := database.DB.Model(&models.SubscriberEvent{}).
+		Select(`to_char(created_at, 'YYYY-MM-DD') AS day, event,
+			to_status = ? AND from_status <> ? AS gained,
+			from_status = ? AND to_status <> ? AS lost,
+			count(*) AS count`, StatusActive, StatusActive, StatusActive, StatusActive).
+		Where("created_at >= ?", time.Now().AddDate(0, 0, -days)).
+		Group("day, event, gained, lost").Order("day").
+		Scan(&rows).Error
+	if err != nil {
+		return report, err
+	}
+
+	var active int64
+	if err := database.DB.Model(&models.Subscriber{}).Where("status = ?", StatusActive).Count(&active).Error; err != nil {
+		return report, err
+	}
+	for _, row := range rows {
+		if n := len(report.ByDay); n == 0 || report.ByDay[n-1].Date != row.Day {
+			report.ByDay = append(report.ByDay, ChurnDay{Date: row.Day})
+		}
+		day := &report.ByDay[len(report.ByDay)-1]
+		if row.Gained {
+			report.Gained += row.Count
+			day.Gained += row.Count
+		}
+		if row.Lost {
+			report.Lost += row.Count
+			day.Lost += row.Count
+		}
+		switch row.Event {
+		case "unsubscribed":
+			report.Unsubscribed += row.Count
+		case "paused":
+			report.Paused += row.Count
+		case "topics_changed":
+			report.TopicsChanged += row.Count
+		}
+	}
+	report.StartedWith = active - int64(report.Gained) + int64(report.Lost)
+	if report.StartedWith > 0 {
+		report.ChurnRate = float64(report.Lost) / float64(report.StartedWith)
+	}
+	return report, nil
+}
@@ -0,0 +1,187 @@
+package pdftext
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// MaxTextBytes caps the stored text. Postgres refuses to build a tsvector
+// from more than 1MB, and the opening pages are what matter for search.
+const MaxTextBytes = 256 << 10
+
+var (
+	ErrNotPDF     = errors.New("file is not a PDF")
+	ErrTooLarge   = errors.New("PDF is larger than PDF_MAX_BYTES")
+	ErrNoLocation = errors.New("PDF link cannot be fetched")
+)
+
+// Document is the text content of a PDF
+type Document struct {
+	Text  string
+	Pages int
+}
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// maxBytes bounds how much of a PDF is read, PDF_MAX_BYTES or 25MB
+func maxBytes() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("PDF_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 25 << 20
+}
+
+// Extract reads the text of every page. Scanned PDFs without a text layer
+// yield an empty Text but still report their page count.
+func Extract(data []byte) (doc Document, err error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\r\n "), []byte("%PDF-")) {
+		return doc, ErrNotPDF
+	}
+	// The parser panics on some malformed files rather than returning errors
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("malformed PDF: %v", p)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return doc, err
+	}
+	doc.Pages = reader.NumPage()
+
+	var b strings.Builder
+	fonts := map[string]*pdf.Font{}
+	for i := 1; i <= doc.Pages && b.Len() < MaxTextBytes; i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		for _, name := range page.Fonts() {
+			if _, ok := fonts[name]; !ok {
+				f := page.Font(name)
+				fonts[name] = &f
+			}
+		}
+		text, err := page.GetPlainText(fonts)
+		if err != nil {
+			continue
+		}
+		b.WriteString(text)
+		b.WriteString("\n")
+	}
+
+	doc.Text = normalise(b.String())
+	if len(doc.Text) > MaxTextBytes {
+		cut := MaxTextBytes
+		for cut > 0 && !isRuneStart(doc.Text[cut]) {
+			cut--
+		}
+		doc.Text = doc.Text[:cut]
+	}
+	return doc, nil
+}
+
+// FromURL extracts a PDF linked from a record. Site relative links under
+// /src/assets are read from the upload directory; absolute links are fetched.
+func FromURL(link string) (Document, error) {
+	data, err := load(link)
+	if err != nil {
+		return Document{}, err
+	}
+	return Extract(data)
+}
+
+func load(link string) ([]byte, error) {
+	if strings.HasPrefix(link, "/src/assets/") {
+		// Uploads are saved relative to the backend directory, see HandleFileUpload
+		root := filepath.Join("..", "src", "assets")
+		path := filepath.Join("..", filepath.FromSlash(filepath.Clean(link)))
+		if !strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return nil, ErrNoLocation
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readLimited(f)
+	}
+
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrNoLocation
+	}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching PDF: %s", resp.Status)
+	}
+	return readLimited(resp.Body)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	limit := maxBytes()
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// Excerpt returns roughly the first max bytes of text, ending on a sentence
+// when one finishes in the second half, otherwise on a word with an ellipsis
+func Excerpt(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= max {
+		return text
+	}
+	cut := text[:max]
+	for len(cut) > 0 && !isRuneStart(text[len(cut)]) {
+		cut = cut[:len(cut)-1]
+	}
+	if end := strings.LastIndexAny(cut, ".!?"); end > max/2 {
+		return cut[:end+1]
+	}
+	if space := strings.LastIndex(cut, " "); space > 0 {
+		cut = cut[:space]
+	}
+	return strings.TrimRight(cut, " ,;:-") + "…"
+}
+
+// normalise collapses the ragged spacing of extracted text while keeping
+// paragraph breaks, and drops control characters Postgres text rejects
+func normalise(s string) string {
+	var paragraphs []string
+	for _, para := range strings.Split(s, "\n") {
+		para = strings.Join(strings.FieldsFunc(para, func(r rune) bool {
+			return unicode.IsSpace(r) || (unicode.IsControl(r) && r != '\n')
+		}), " ")
+		if para != "" {
+			paragraphs = append(paragraphs, para)
+		}
+	}
+	return strings.ToValidUTF8(strings.Join(paragraphs, "\n"), "")
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
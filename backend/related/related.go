@@ -0,0 +1,201 @@
+package related
+
+import (
+	"html"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Document - Anything that can be recommended
+type Document struct {
+	Kind     string // "post", "initiative", "resource"
+	ID       uint
+	Title    string
+	Text     string // Body text; markup is stripped during indexing
+	Category string
+	Tags     []string
+	Date     time.Time
+}
+
+// Result - A recommended document and how well it matched
+type Result struct {
+	Kind  string  `json:"kind"`
+	ID    uint    `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// Weights of the individual signals; they sum to 1
+const (
+	tagWeight      = 0.35
+	categoryWeight = 0.15
+	textWeight     = 0.35
+	recencyWeight  = 0.15
+
+	// Recency score halves every this many days
+	recencyHalfLife = 180.0
+)
+
+type indexed struct {
+	doc    Document
+	tags   map[string]bool
+	vector map[string]float64 // L2 normalised TF-IDF weights
+}
+
+// Index - TF-IDF index over a fixed set of documents
+type Index struct {
+	docs  []indexed
+	byKey map[string]int
+	now   time.Time
+}
+
+// NewIndex tokenises every document and computes its TF-IDF vector
+func NewIndex(docs []Document) *Index {
+	idx := &Index{byKey: make(map[string]int, len(docs)), now: time.Now()}
+
+	termCounts := make([]map[string]int, len(docs))
+	docFreq := map[string]int{}
+	for i, d := range docs {
+		counts := map[string]int{}
+		for _, tok := range tokenize(d.Title + " " + d.Title + " " + d.Text) {
+			counts[tok]++
+		}
+		for term := range counts {
+			docFreq[term]++
+		}
+		termCounts[i] = counts
+	}
+
+	n := float64(len(docs))
+	for i, d := range docs {
+		vec := map[string]float64{}
+		var norm float64
+		for term, count := range termCounts[i] {
+			// Sub-linear tf and smoothed idf
+			w := (1 + math.Log(float64(count))) * math.Log(1+n/float64(docFreq[term]))
+			vec[term] = w
+			norm += w * w
+		}
+		norm = math.Sqrt(norm)
+		for term := range vec {
+			vec[term] /= norm
+		}
+
+		tags := map[string]bool{}
+		for _, t := range d.Tags {
+			tags[strings.ToLower(strings.TrimSpace(t))] = true
+		}
+		idx.byKey[key(d.Kind, d.ID)] = i
+		idx.docs = append(idx.docs, indexed{doc: d, tags: tags, vector: vec})
+	}
+	return idx
+}
+
+// Similar ranks documents of kind wantKind ("" for any) against the given
+// document, best first. Documents with no signal in common are left out.
+func (idx *Index) Similar(kind string, id uint, wantKind string, limit int) []Result {
+	pos, ok := idx.byKey[key(kind, id)]
+	if !ok {
+		return nil
+	}
+	target := idx.docs[pos]
+
+	var results []Result
+	for i, cand := range idx.docs {
+		if i == pos || (wantKind != "" && cand.doc.Kind != wantKind) {
+			continue
+		}
+		tagScore := jaccard(target.tags, cand.tags)
+		textScore := cosine(target.vector, cand.vector)
+		categoryScore := 0.0
+		if target.doc.Category != "" && strings.EqualFold(target.doc.Category, cand.doc.Category) {
+			categoryScore = 1
+		}
+		if tagScore == 0 && textScore == 0 && categoryScore == 0 {
+			continue
+		}
+
+		score := tagWeight*tagScore + categoryWeight*categoryScore + textWeight*textScore +
+			recencyWeight*idx.recency(cand.doc.Date)
+		results = append(results, Result{Kind: cand.doc.Kind, ID: cand.doc.ID, Score: math.Round(score*1000) / 1000})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func (idx *Index) recency(date time.Time) float64 {
+	if date.IsZero() {
+		return 0
+	}
+	days := idx.now.Sub(date).Hours() / 24
+	if days < 0 {
+		days = 0
+	}
+	return math.Pow(0.5, days/recencyHalfLife)
+}
+
+func key(kind string, id uint) string {
+	return kind + ":" + strconv.FormatUint(uint64(id), 10)
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range a {
+		if b[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+func cosine(a, b map[string]float64) float64 {
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	var dot float64
+	for term, w := range a {
+		dot += w * b[term]
+	}
+	return dot
+}
+
+var markup = regexp.MustCompile(`(?s)<[^>]*>`)
+
+func tokenize(text string) []string {
+	text = html.UnescapeString(markup.ReplaceAllString(text, " "))
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := words[:0]
+	for _, w := range words {
+		if len(w) > 2 && !stopWords[w] {
+			tokens = append(tokens, w)
+		}
+	}
+	return tokens
+}
+
+var stopWords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`about above after again against all and any are because been before
+		being below between both but can could did does doing down during each few for from further had has
+		have having her here hers herself him himself his how into its itself just more most not now off once
+		only other our ours ourselves out over own same she should some such than that the their theirs them
+		themselves then there these they this those through too under until very was were what when where
+		which while who whom why will with would you your yours yourself yourselves also may many must new
+		one two well yiaga africa`) {
+		stopWords[w] = true
+	}
+}
@@ -21,8 +21,8 @@ func SetupRouter() *chi.Mux {
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"}, // Be more permissive for development
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match"},
+		ExposedHeaders:   []string{"Link", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -31,19 +31,36 @@ func SetupRouter() *chi.Mux {
 	r.Route("/api", func(r chi.Router) {
 		// Announcements
 		r.Get("/announcements", handlers.GetAnnouncements)
+		r.Get("/announcements/{id}", handlers.GetAnnouncement)
 		r.Post("/announcements", handlers.CreateAnnouncement)
 
 		// Blogs & News
 		r.Get("/blogs", handlers.GetBlogs)
 		r.Get("/blogs/{slug}", handlers.GetBlogBySlug)
+		r.Get("/blogs/{slug}/related", handlers.GetRelatedForBlog)
+
+		// Syndication feeds (format is rss, atom or json)
+		r.Get("/feeds/category/{category}/{format}", handlers.GetCategoryFeed)
+		r.Get("/feeds/tag/{tag}/{format}", handlers.GetTagFeed)
+		r.Get("/feeds/{type}/{format}", handlers.GetTypeFeed)
 
 		// Initiatives
 		r.Get("/initiatives", handlers.GetInitiatives)
 		r.Get("/initiatives/{slug}", handlers.GetInitiativeBySlug)
+		r.Get("/initiatives/{slug}/related", handlers.GetRelatedForInitiative)
 
 		// Resources
 		r.Get("/resources", handlers.GetResources)
 
+		// Translations
+		r.Get("/locales", handlers.GetLocales)
+
+		// Legacy URL lookup for the SPA's not found page
+		r.Get("/redirects/resolve", handlers.ResolveRedirect)
+
+		// Curated slots (homepage featured, pinned items)
+		r.Get("/slots/{key}", handlers.GetSlot)
+
 		// Jobs
 		r.Get("/jobs", handlers.GetJobs)
 		r.Post("/jobs", handlers.CreateJob)
@@ -54,17 +71,51 @@ func SetupRouter() *chi.Mux {
 		// Public Routes
 		r.Post("/contact", handlers.SubmitContact)
 		r.Post("/subscribe", handlers.SubscribeNewsletter)
+		r.Get("/subscribe/confirm", handlers.ConfirmSubscription)
+		r.Post("/subscribe/confirm", handlers.ConfirmSubscription)
+		r.Get("/subscribe/preferences", handlers.GetPreferences)
+		r.Put("/subscribe/preferences", handlers.UpdatePreferences)
+		r.Get("/subscribe/unsubscribe", handlers.Unsubscribe)
+		r.Post("/subscribe/unsubscribe", handlers.Unsubscribe)
+		r.Post("/beacon", handlers.RecordBeacon)
 		r.Post("/login", handlers.Login)
 		r.Post("/signup", handlers.Signup)
 		r.Get("/comments", handlers.GetComments) // Public for specific posts (approved), Protected for list
+		r.Post("/comments", handlers.CreateComment)
+		r.Get("/comments/challenge", handlers.GetCommentChallenge)
+		r.Get("/comments/threads/{id}/unsubscribe", handlers.UnsubscribeCommentThread)
+		r.Post("/comments/threads/{id}/unsubscribe", handlers.UnsubscribeCommentThread)
 
 		// --- Protected Admin Routes ---
 		r.Group(func(r chi.Router) {
 			r.Use(authMiddleware.AuthMiddleware)
 
 			r.Get("/dashboard/stats", handlers.GetDashboardStats)
+			r.Get("/dashboard/content", handlers.GetContentAnalytics)
 			r.Get("/subscribers/analytics", handlers.GetSubscriberAnalytics)
 			r.Post("/upload", handlers.HandleFileUpload)
+			r.Get("/slugs/check", handlers.CheckSlug)
+			r.Post("/pdfs/reindex", handlers.ReindexPDFs)
+
+			// CMS - Translations
+			r.Get("/translations/missing", handlers.GetMissingTranslations)
+			r.Put("/translations/{type}/{id}/{locale}", handlers.SetTranslation)
+			r.Delete("/translations/{type}/{id}/{locale}", handlers.DeleteTranslation)
+
+			// CMS - Draft preview links
+			r.Get("/previews", handlers.GetPreviews)
+			r.Post("/previews", handlers.CreatePreview)
+			r.Delete("/previews/{id}", handlers.RevokePreview)
+
+			// CMS - Soft edit locks ({type} is blog or initiative)
+			r.Get("/locks/{type}/{id}", handlers.GetEditLock)
+			r.Post("/locks/{type}/{id}", handlers.TakeEditLock)
+			r.Delete("/locks/{type}/{id}", handlers.ReleaseEditLock)
+
+			// CMS - Curated slots
+			r.Get("/slots", handlers.GetSlots)
+			r.Put("/slots/{key}", handlers.SaveSlot)
+			r.Delete("/slots/{key}", handlers.DeleteSlot)
 
 			// CMS - Hero
 			r.Get("/content/hero/{page}", handlers.GetHeroContent)
@@ -100,7 +151,12 @@ func SetupRouter() *chi.Mux {
 			r.Delete("/partners/{id}", handlers.DeletePartner)
 
 			// Comments Admin
+			r.Post("/comments/bulk", handlers.BulkModerateComments)
 			r.Put("/comments/{id}/status", handlers.UpdateCommentStatus)
+			r.Post("/comments/{id}/replies", handlers.ReplyToComment)
+			r.Get("/comments/{id}/spam", handlers.GetCommentSpamScore)
+			r.Get("/comments/spam", handlers.GetSpamModel)
+			r.Post("/comments/spam/retrain", handlers.RetrainSpamModel)
 			r.Delete("/comments/{id}", handlers.DeleteComment)
 
 			// Users
@@ -109,6 +165,13 @@ func SetupRouter() *chi.Mux {
 			r.Put("/users/{id}", handlers.UpdateUser)
 			r.Delete("/users/{id}", handlers.DeleteUser)
 
+			// CMS - Moderation rules
+			r.Get("/moderation-rules", handlers.GetModerationRules)
+			r.Post("/moderation-rules", handlers.CreateModerationRule)
+			r.Post("/moderation-rules/test", handlers.TestModerationRules)
+			r.Put("/moderation-rules/{id}", handlers.UpdateModerationRule)
+			r.Delete("/moderation-rules/{id}", handlers.DeleteModerationRule)
+
 			// Audit Logs
 			r.Get("/audit-logs", handlers.GetAuditLogs)
 			r.Post("/audit-logs", handlers.CreateAuditLog) // Technically system calls this, but fine for now
@@ -118,6 +181,44 @@ func SetupRouter() *chi.Mux {
 			r.Delete("/badges/{id}", handlers.DeleteBadge)
 		})
 
+		// Admin only: bulk email and the addresses it goes to, and imports
+		r.Group(func(r chi.Router) {
+			r.Use(authMiddleware.AuthMiddleware)
+			r.Use(authMiddleware.RequireRole("admin"))
+
+			// CMS - Mail queue
+			r.Get("/emails", handlers.GetOutgoingEmails)
+			r.Post("/emails/{id}/retry", handlers.RetryOutgoingEmail)
+
+			// CMS - Newsletter campaigns
+			r.Get("/campaigns", handlers.GetCampaigns)
+			r.Post("/campaigns", handlers.CreateCampaign)
+			r.Get("/campaigns/{id}", handlers.GetCampaign)
+			r.Put("/campaigns/{id}", handlers.UpdateCampaign)
+			r.Delete("/campaigns/{id}", handlers.DeleteCampaign)
+			r.Post("/campaigns/{id}/test", handlers.TestCampaign)
+			r.Post("/campaigns/{id}/schedule", handlers.ScheduleCampaign)
+			r.Post("/campaigns/{id}/cancel", handlers.CancelCampaign)
+			r.Post("/campaigns/{id}/retry", handlers.RetryCampaign)
+			r.Get("/campaigns/{id}/recipients", handlers.GetCampaignRecipients)
+
+			// CMS - WordPress import
+			r.Post("/import/wordpress", handlers.ImportWordPress)
+			r.Get("/import/wordpress/{id}", handlers.GetImportRun)
+
+			// CMS - Email templates
+			r.Get("/email-templates", handlers.GetEmailTemplates)
+			r.Post("/email-templates", handlers.CreateEmailTemplate)
+			r.Get("/email-templates/types", handlers.GetEmailTemplateTypes)
+			r.Post("/email-templates/preview", handlers.PreviewEmailTemplate)
+			r.Get("/email-templates/{id}", handlers.GetEmailTemplate)
+			r.Put("/email-templates/{id}", handlers.UpdateEmailTemplate)
+			r.Delete("/email-templates/{id}", handlers.DeleteEmailTemplate)
+			r.Get("/email-templates/{id}/preview", handlers.PreviewSavedEmailTemplate)
+			r.Get("/email-templates/{id}/versions", handlers.GetEmailTemplateVersions)
+			r.Post("/email-templates/{id}/versions/{version}/restore", handlers.RestoreEmailTemplateVersion)
+		})
+
 		// Public GETs for shared resources that might be used on frontend
 		r.Get("/partners", handlers.GetPartners)
 		r.Get("/badges", handlers.GetBadges)
@@ -127,6 +228,19 @@ func SetupRouter() *chi.Mux {
 		})
 	})
 
+	// Link previews for crawlers; everyone else is redirected to the SPA
+	r.Get("/blog/{slug}", handlers.GetPostPreview)
+	r.Get("/news/{slug}", handlers.GetPostPreview)
+	r.Get("/initiatives/{slug}", handlers.GetInitiativePreview)
+
+	// Search engine discovery
+	r.Get("/sitemap.xml", handlers.GetSitemapIndex)
+	r.Get("/sitemaps/{name}", handlers.GetSitemap)
+	r.Get("/robots.txt", handlers.GetRobotsTxt)
+
+	// Old WordPress permalinks
+	r.NotFound(handlers.FollowRedirect)
+
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// This ensures the root path returns a 200 OK instead of a 404
 		w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,188 @@
+package sanitize
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"golang.org/x/net/html"
+)
+
+// Embeds are only allowed from these players, after normalisation to their
+// privacy friendly embed URLs (see normaliseMedia).
+var allowedEmbed = regexp.MustCompile(`^https://(www\.youtube-nocookie\.com/embed/|player\.vimeo\.com/video/|www\.facebook\.com/plugins/video\.php\?)`)
+
+// Quill marks alignment, indentation and video embeds with ql-* classes
+var quillClass = regexp.MustCompile(`^(ql-[a-z0-9-]+)(\s+ql-[a-z0-9-]+)*$`)
+
+var (
+	richPolicy    = newRichPolicy()
+	commentPolicy = newCommentPolicy()
+	plainPolicy   = bluemonday.StrictPolicy().AddSpaceWhenStrippingTag(true)
+)
+
+// newRichPolicy covers the RichTextEditor output used for post and initiative
+// bodies: headings, lists, links, images (including pasted data URIs), tables
+// and allowlisted video embeds.
+func newRichPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowElements("u", "s", "figure", "figcaption")
+	p.AllowAttrs("class").Matching(quillClass).Globally()
+	p.AllowDataURIImages()
+	p.AllowAttrs("loading").Matching(regexp.MustCompile(`^(lazy|eager)$`)).OnElements("img", "iframe")
+
+	p.AllowElements("iframe")
+	p.AllowAttrs("src").Matching(allowedEmbed).OnElements("iframe")
+	p.AllowAttrs("width", "height").Matching(bluemonday.Number).OnElements("iframe")
+	p.AllowAttrs("allowfullscreen", "frameborder").OnElements("iframe")
+	p.AllowAttrs("title").OnElements("iframe")
+
+	p.RequireNoFollowOnFullyQualifiedLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	p.RequireNoReferrerOnFullyQualifiedLinks(true)
+	return p
+}
+
+// newCommentPolicy allows light inline formatting only. Links are kept but
+// marked nofollow so spam gains nothing from them.
+func newCommentPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowStandardURLs()
+	p.AllowElements("p", "br", "b", "strong", "i", "em", "u", "s", "code", "pre", "blockquote", "ul", "ol", "li")
+	p.AllowAttrs("href").OnElements("a")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	p.RequireNoReferrerOnLinks(true)
+	return p
+}
+
+// RichText cleans HTML bodies written in the CMS (BlogPost and Initiative content)
+func RichText(s string) string {
+	if s == "" {
+		return s
+	}
+	return richPolicy.Sanitize(normaliseMedia(s))
+}
+
+// Comment cleans reader submitted comment text
+func Comment(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.TrimSpace(commentPolicy.Sanitize(s))
+}
+
+// PlainText reduces HTML to its visible text on a single line
+func PlainText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(plainPolicy.Sanitize(s))), " ")
+}
+
+var (
+	youtubeID = regexp.MustCompile(`^[A-Za-z0-9_-]{6,20}$`)
+	vimeoID   = regexp.MustCompile(`^[0-9]{4,12}$`)
+)
+
+// normaliseMedia rewrites embedded media before sanitising: video links in
+// iframes become canonical https embed URLs, protocol relative and plain http
+// image sources are upgraded to https, and images/iframes load lazily.
+func normaliseMedia(s string) string {
+	z := html.NewTokenizer(strings.NewReader(s))
+	var out bytes.Buffer
+	droppingIframe := false
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() == io.EOF {
+				return out.String()
+			}
+			// Let the sanitizer deal with whatever it can't parse
+			return s
+		}
+		// Token() unescapes in place, so take the raw bytes first
+		raw := append([]byte(nil), z.Raw()...)
+		tok := z.Token()
+
+		if droppingIframe {
+			droppingIframe = !(tt == html.EndTagToken && tok.Data == "iframe")
+			continue
+		}
+		if (tt == html.StartTagToken || tt == html.SelfClosingTagToken) && (tok.Data == "img" || tok.Data == "iframe") {
+			hasLoading, allowed := false, tok.Data == "img"
+			for i, a := range tok.Attr {
+				switch a.Key {
+				case "src":
+					if tok.Data == "iframe" {
+						tok.Attr[i].Val = embedURL(a.Val)
+						allowed = allowedEmbed.MatchString(tok.Attr[i].Val)
+					} else {
+						tok.Attr[i].Val = upgradeURL(a.Val)
+					}
+				case "loading":
+					hasLoading = true
+				}
+			}
+			if !allowed {
+				// An iframe from anywhere else is dropped entirely
+				droppingIframe = tt == html.StartTagToken
+				continue
+			}
+			if !hasLoading {
+				tok.Attr = append(tok.Attr, html.Attribute{Key: "loading", Val: "lazy"})
+			}
+			out.WriteString(tok.String())
+			continue
+		}
+		out.Write(raw)
+	}
+}
+
+func upgradeURL(src string) string {
+	src = strings.TrimSpace(src)
+	switch {
+	case strings.HasPrefix(src, "//"):
+		return "https:" + src
+	case strings.HasPrefix(strings.ToLower(src), "http://"):
+		return "https://" + src[len("http://"):]
+	}
+	return src
+}
+
+// embedURL maps the many forms of a YouTube or Vimeo link onto their embed
+// player. Anything unrecognised is returned as-is for the policy to reject.
+func embedURL(src string) string {
+	u, err := url.Parse(upgradeURL(src))
+	if err != nil {
+		return src
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	host = strings.TrimPrefix(host, "m.")
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	var id string
+	switch host {
+	case "youtube.com", "youtube-nocookie.com":
+		switch {
+		case u.Path == "/watch":
+			id = u.Query().Get("v")
+		case len(segments) == 2 && (segments[0] == "embed" || segments[0] == "shorts" || segments[0] == "live"):
+			id = segments[1]
+		}
+		if youtubeID.MatchString(id) {
+			return "https://www.youtube-nocookie.com/embed/" + id
+		}
+	case "youtu.be":
+		if len(segments) == 1 && youtubeID.MatchString(segments[0]) {
+			return "https://www.youtube-nocookie.com/embed/" + segments[0]
+		}
+	case "vimeo.com", "player.vimeo.com":
+		id = segments[len(segments)-1]
+		if vimeoID.MatchString(id) {
+			return "https://player.vimeo.com/video/" + id
+		}
+	}
+	return src
+}
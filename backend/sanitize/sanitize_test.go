@@ -0,0 +1,63 @@
+package sanitize
+
+import "testing"
+
+func TestRichTextXSS(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"script tag", `<p>hi</p><script>alert(1)</script>`, `<p>hi</p>`},
+		{"onerror", `<img src="https://example.org/a.png" onerror="alert(1)">`, `<img src="https://example.org/a.png" loading="lazy">`},
+		{"javascript href", `<a href="javascript:alert(1)">x</a>`, `x`},
+		{"mixed case scheme", `<a href="JaVaScRiPt:alert(1)">x</a>`, `x`},
+		{"entity encoded scheme", `<a href="&#106;avascript:alert(1)">x</a>`, `x`},
+		{"whitespace in scheme", `<a href="java&#x09;script:alert(1)">x</a>`, `x`},
+		{"data href", `<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`, `x`},
+		{"svg with script", `<svg><script>alert(1)</script><circle r="1"/></svg>`, ``},
+		{"svg onload", `<svg onload="alert(1)"></svg>`, ``},
+		{"iframe off allowlist", `<iframe src="https://evil.example/embed"></iframe><p>after</p>`, `<p>after</p>`},
+		{"iframe javascript src", `<iframe src="javascript:alert(1)"></iframe>`, ``},
+		{"style expression", `<p style="width: expression(alert(1))">x</p>`, `<p>x</p>`},
+		{"style tag", `<style>body{background:url(javascript:alert(1))}</style><p>x</p>`, `<p>x</p>`},
+		{"data uri image", `<img src="data:image/png;base64,iVBORw0KGgo=">`, `<img src="data:image/png;base64,iVBORw0KGgo=" loading="lazy">`},
+		{"data uri html image", `<img src="data:text/html;base64,PHNjcmlwdD4=">`, `<img loading="lazy">`},
+		{"object embed", `<object data="https://evil.example/x.swf"></object><embed src="https://evil.example/x.swf">`, ``},
+		{"form action", `<form action="https://evil.example"><input name="q"></form>`, ``},
+		{"meta refresh", `<meta http-equiv="refresh" content="0;url=https://evil.example">`, ``},
+		{"youtube embed kept", `<iframe src="https://www.youtube.com/watch?v=dQw4w9WgXcQ"></iframe>`, `<iframe src="https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ" loading="lazy"></iframe>`},
+		{"external link", `<a href="https://example.org">x</a>`, `<a href="https://example.org" rel="nofollow noreferrer noopener" target="_blank">x</a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RichText(tt.in); got != tt.want {
+				t.Errorf("RichText(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommentXSS(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"script tag", `hi<script>alert(1)</script>`, `hi`},
+		{"onerror", `<img src=x onerror=alert(1)>nice`, `nice`},
+		{"javascript href", `<a href="javascript:alert(1)">x</a>`, `x`},
+		{"mixed case scheme", `<a href="JAVASCRIPT:alert(1)">x</a>`, `x`},
+		{"entity encoded scheme", `<a href="&#x6A;&#x61;vascript:alert(1)">x</a>`, `x`},
+		{"data href", `<a href="data:text/html,<script>alert(1)</script>">x</a>`, `x`},
+		{"vbscript href", `<a href="vbscript:msgbox(1)">x</a>`, `x`},
+		{"svg with script", `<svg><script>alert(1)</script></svg>ok`, `ok`},
+		{"iframe", `<iframe src="https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ"></iframe>ok`, `ok`},
+		{"style expression", `<p style="background: expression(alert(1))">x</p>`, `<p>x</p>`},
+		{"link kept nofollow", `<a href="https://example.org">x</a>`, `<a href="https://example.org" rel="nofollow noreferrer noopener" target="_blank">x</a>`},
+		{"formatting kept", `<p><strong>bold</strong> <em>it</em></p>`, `<p><strong>bold</strong> <em>it</em></p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Comment(tt.in); got != tt.want {
+				t.Errorf("Comment(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
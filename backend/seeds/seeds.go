@@ -7,6 +7,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"yiaga-backend/database"
+	"yiaga-backend/mailtmpl"
 	"yiaga-backend/models"
 )
 
@@ -175,6 +176,7 @@ func SeedData() {
 		resources := []models.Resource{
 			{
 				Title:       "Election Observation Report 2023",
+				Slug:        "election-observation-report-2023",
 				Description: "Comprehensive analysis of the 2023 general elections in Nigeria.",
 				Type:        "PDF Report",
 				Category:    "Reports",
@@ -186,6 +188,7 @@ func SeedData() {
 			},
 			{
 				Title:       "Citizen's Guide to Voting",
+				Slug:        "citizens-guide-to-voting",
 				Description: "Everything you need to know about your voting rights and the electoral process.",
 				Type:        "E-Book",
 				Category:    "E-Books",
@@ -209,6 +212,7 @@ func SeedData() {
 		// User asked "dummy data for all tables". Let's add one dummy job.
 		job := models.Job{
 			Title:        "Program Officer, Elections",
+			Slug:         "program-officer-elections",
 			Department:   "Elections",
 			Location:     "Abuja",
 			Type:         "Full-time",
@@ -220,6 +224,23 @@ func SeedData() {
 		database.DB.Create(&job)
 		log.Println("Database seeded with jobs")
 	}
+
+	// Seed Curated Slots, filled automatically until editors pin items
+	database.DB.Model(&models.CuratedSlot{}).Count(&count)
+	if count == 0 {
+		slots := []models.CuratedSlot{
+			{Key: "home.featured", Label: "Homepage featured stories", MaxItems: 3, Fill: "featured_posts", Items: []models.SlotItem{}},
+			{Key: "home.news", Label: "Homepage latest news", MaxItems: 4, Fill: "latest_news", Items: []models.SlotItem{}},
+			{Key: "home.initiatives", Label: "Homepage initiatives", MaxItems: 3, Fill: "latest_initiatives", Items: []models.SlotItem{}},
+		}
+		for _, s := range slots {
+			database.DB.Create(&s)
+		}
+		log.Println("Database seeded with curated slots")
+	}
+	// Seed Email Templates that admins have not created yet
+	mailtmpl.Seed()
+
 	// Seed Users (Admin)
 	database.DB.Model(&models.User{}).Count(&count)
 	// Check if specific admin exists to be safe
@@ -0,0 +1,102 @@
+package sitemap
+
+import (
+	"encoding/xml"
+	"strconv"
+	"time"
+)
+
+// MaxURLs is the protocol limit on entries in a single sitemap file
+const MaxURLs = 50000
+
+const xmlns = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// URL - A single <url> entry
+type URL struct {
+	Loc        string
+	LastMod    time.Time
+	ChangeFreq string
+	Priority   float64
+}
+
+// Ref - A single <sitemap> entry in an index
+type Ref struct {
+	Loc     string
+	LastMod time.Time
+}
+
+type urlSet struct {
+	XMLName xml.Name `xml:"urlset"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	URLs    []xmlURL `xml:"url"`
+}
+
+type xmlURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name `xml:"sitemapindex"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Sitemaps []xmlRef `xml:"sitemap"`
+}
+
+type xmlRef struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Chunks reports how many sitemap files are needed for total URLs
+func Chunks(total int64) int {
+	if total <= 0 {
+		return 1
+	}
+	return int((total + MaxURLs - 1) / MaxURLs)
+}
+
+// URLSet renders a <urlset> document
+func URLSet(urls []URL) ([]byte, error) {
+	doc := urlSet{Xmlns: xmlns, URLs: []xmlURL{}}
+	for _, u := range urls {
+		entry := xmlURL{Loc: u.Loc, ChangeFreq: u.ChangeFreq}
+		if !u.LastMod.IsZero() {
+			entry.LastMod = u.LastMod.UTC().Format(time.RFC3339)
+		}
+		if u.Priority > 0 {
+			entry.Priority = formatPriority(u.Priority)
+		}
+		doc.URLs = append(doc.URLs, entry)
+	}
+	return marshal(doc)
+}
+
+// Index renders a <sitemapindex> document
+func Index(refs []Ref) ([]byte, error) {
+	doc := sitemapIndex{Xmlns: xmlns, Sitemaps: []xmlRef{}}
+	for _, ref := range refs {
+		entry := xmlRef{Loc: ref.Loc}
+		if !ref.LastMod.IsZero() {
+			entry.LastMod = ref.LastMod.UTC().Format(time.RFC3339)
+		}
+		doc.Sitemaps = append(doc.Sitemaps, entry)
+	}
+	return marshal(doc)
+}
+
+func formatPriority(p float64) string {
+	if p > 1 {
+		p = 1
+	}
+	return strconv.FormatFloat(p, 'f', 1, 64)
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	out, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
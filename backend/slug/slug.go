@@ -0,0 +1,79 @@
+package slug
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxLength keeps generated slugs readable in URLs and share previews
+const MaxLength = 80
+
+var (
+	ErrEmpty    = errors.New("slug cannot be empty")
+	ErrTooLong  = errors.New("slug is longer than 80 characters")
+	ErrInvalid  = errors.New("slug may only contain lowercase letters, digits and single hyphens")
+	validFormat = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+)
+
+// Letters that Unicode decomposition does not reduce to ASCII. Includes the
+// hooked consonants used in Hausa and the dotted vowels of Yoruba and Igbo
+// that some keyboards emit as precomposed characters.
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "ae", 'œ': "oe", 'Œ': "oe",
+	'ø': "o", 'Ø': "o", 'đ': "d", 'Đ': "d", 'ð': "d", 'Ð': "d",
+	'ł': "l", 'Ł': "l", 'þ': "th", 'Þ': "th", 'ı': "i",
+	'ɓ': "b", 'Ɓ': "b", 'ɗ': "d", 'Ɗ': "d", 'ƙ': "k", 'Ƙ': "k",
+	'ƴ': "y", 'Ƴ': "y", 'ŋ': "n", 'Ŋ': "n", 'ə': "e", 'Ə': "e",
+	'&': " and ", '@': " at ",
+	'’': "", '\'': "",
+}
+
+// Make turns a title into a URL slug: "Côte d'Ivoire’s Élections: 2027!"
+// becomes "cote-divoires-elections-2027".
+func Make(title string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(title) {
+		if t, ok := transliterations[r]; ok {
+			b.WriteString(t)
+			continue
+		}
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Combining accent left over from decomposition
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteByte('-')
+		}
+	}
+
+	// Collapse runs of separators
+	parts := strings.FieldsFunc(b.String(), func(r rune) bool { return r == '-' || r == ' ' })
+	s := strings.Join(parts, "-")
+
+	if len(s) > MaxLength {
+		s = s[:MaxLength]
+		if cut := strings.LastIndex(s, "-"); cut > MaxLength/2 {
+			s = s[:cut]
+		}
+		s = strings.Trim(s, "-")
+	}
+	return s
+}
+
+// Validate checks an editor supplied slug without modifying it
+func Validate(s string) error {
+	switch {
+	case s == "":
+		return ErrEmpty
+	case len(s) > MaxLength:
+		return ErrTooLong
+	case !validFormat.MatchString(s):
+		return ErrInvalid
+	}
+	return nil
+}
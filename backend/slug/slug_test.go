@@ -0,0 +1,65 @@
+package slug
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	long := strings.Repeat("election ", 12) // 108 characters
+	tests := []struct {
+		name, title, want string
+	}{
+		{"plain", "Youth Voter Turnout 2027", "youth-voter-turnout-2027"},
+		{"accents and apostrophes", "Côte d'Ivoire’s Élections: 2027!", "cote-divoires-elections-2027"},
+		{"hausa hooked letters", "Ƙungiyar Ɗalibai a Ɓauchi", "kungiyar-dalibai-a-bauchi"},
+		{"yoruba dotted vowels", "Ìdìbò Ọdún Tuntun ní Ẹ̀kọ́", "idibo-odun-tuntun-ni-eko"},
+		{"ligatures and special letters", "Straße Œuvre Łódź", "strasse-oeuvre-lodz"},
+		{"ampersand and at", "Peace & Security @ Abuja", "peace-and-security-at-abuja"},
+		{"runs of punctuation", "--Hello,,,  World!!--", "hello-world"},
+		{"punctuation only", "?!… — ###", ""},
+		{"non latin script only", "选举", ""},
+		{"fullwidth digits", "Ｒｅｐｏｒｔ ２０２７", "report-2027"},
+		{"cut at a word", long, strings.TrimSuffix(strings.Repeat("election-", 8), "-")},
+		{"cut inside one long word", strings.Repeat("a", 100), strings.Repeat("a", MaxLength)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Make(tt.title)
+			if got != tt.want {
+				t.Errorf("Make(%q) = %q, want %q", tt.title, got, tt.want)
+			}
+			if got != "" {
+				if err := Validate(got); err != nil {
+					t.Errorf("Make(%q) = %q, which Validate rejects: %v", tt.title, got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		slug string
+		want error
+	}{
+		{"youth-voter-turnout-2027", nil},
+		{"a", nil},
+		{strings.Repeat("a", MaxLength), nil},
+		{"", ErrEmpty},
+		{strings.Repeat("a", MaxLength+1), ErrTooLong},
+		{"Upper-Case", ErrInvalid},
+		{"double--hyphen", ErrInvalid},
+		{"-leading", ErrInvalid},
+		{"trailing-", ErrInvalid},
+		{"with space", ErrInvalid},
+		{"under_score", ErrInvalid},
+		{"élection", ErrInvalid},
+		{"../admin", ErrInvalid},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.slug); err != tt.want {
+			t.Errorf("Validate(%q) = %v, want %v", tt.slug, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package spam
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Classifier is a naive Bayes model over the distinct features of a comment.
+// Counting each feature once per comment keeps a word repeated fifty times
+// from outweighing everything else.
+type Classifier struct {
+	SpamDocs int            `json:"spam_docs"`
+	HamDocs  int            `json:"ham_docs"`
+	Spam     map[string]int `json:"spam"` // Comments each feature appeared in
+	Ham      map[string]int `json:"ham"`
+}
+
+// Example is one moderated comment
+type Example struct {
+	Comment Comment
+	Spam    bool
+}
+
+// Comment holds the parts of a comment the classifier looks at
+type Comment struct {
+	Author  string
+	Email   string
+	Content string
+}
+
+// Reason is one feature's pull towards spam (positive) or ham (negative),
+// in log odds
+type Reason struct {
+	Feature string  `json:"feature"`
+	Weight  float64 `json:"weight"`
+}
+
+func (r Reason) String() string {
+	return fmt.Sprintf("%s (%+.1f)", r.Feature, r.Weight)
+}
+
+// Train builds a classifier from moderated comments
+func Train(examples []Example) *Classifier {
+	c := &Classifier{Spam: map[string]int{}, Ham: map[string]int{}}
+	for _, ex := range examples {
+		counts := c.Ham
+		if ex.Spam {
+			counts = c.Spam
+			c.SpamDocs++
+		} else {
+			c.HamDocs++
+		}
+		for f := range Features(ex.Comment) {
+			counts[f]++
+		}
+	}
+	return c
+}
+
+// Score returns the probability that a comment is spam and the features that
+// moved it most, strongest first
+func (c *Classifier) Score(comment Comment) (float64, []Reason) {
+	// Laplace smoothed estimates of P(feature | class), plus the class prior
+	logOdds := math.Log(float64(c.SpamDocs+1) / float64(c.HamDocs+1))
+	var reasons []Reason
+	for f := range Features(comment) {
+		spam, ham := c.Spam[f], c.Ham[f]
+		if spam+ham == 0 {
+			continue // Never seen, says nothing
+		}
+		pSpam := float64(spam+1) / float64(c.SpamDocs+2)
+		pHam := float64(ham+1) / float64(c.HamDocs+2)
+		weight := math.Log(pSpam / pHam)
+		logOdds += weight
+		reasons = append(reasons, Reason{Feature: f, Weight: math.Round(weight*10) / 10})
+	}
+
+	sort.Slice(reasons, func(i, j int) bool {
+		return math.Abs(reasons[i].Weight) > math.Abs(reasons[j].Weight)
+	})
+	if len(reasons) > 8 {
+		reasons = reasons[:8]
+	}
+	return 1 / (1 + math.Exp(-logOdds)), reasons
+}
+
+var (
+	linkPattern  = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+	emailPattern = regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.]+`)
+	tagPattern   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// Features extracts the distinct words and signals of a comment. Words are
+// lowercased; signals are prefixed ("link:", "host:", "from:", "links:",
+// "shouting", "length:") so explanations read clearly.
+func Features(comment Comment) map[string]bool {
+	features := map[string]bool{}
+	text := tagPattern.ReplaceAllString(comment.Content, " ")
+
+	links := linkPattern.FindAllString(comment.Content, -1)
+	for _, link := range links {
+		if !strings.Contains(link, "://") {
+			link = "http://" + link
+		}
+		if u, err := url.Parse(link); err == nil && u.Host != "" {
+			features["host:"+strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")] = true
+		}
+	}
+	switch n := len(links); {
+	case n == 0:
+		features["links:0"] = true
+	case n == 1:
+		features["links:1"] = true
+	default:
+		features["links:2+"] = true
+	}
+	text = linkPattern.ReplaceAllString(text, " ")
+	if emailPattern.MatchString(text) {
+		features["contains-email"] = true
+	}
+
+	if at := strings.LastIndex(comment.Email, "@"); at >= 0 {
+		features["from:"+strings.ToLower(comment.Email[at+1:])] = true
+	}
+	for _, word := range words(comment.Author) {
+		features["author:"+word] = true
+	}
+
+	var letters, upper int
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters >= 20 && upper*2 > letters {
+		features["shouting"] = true
+	}
+	switch n := len([]rune(strings.TrimSpace(text))); {
+	case n < 20:
+		features["length:short"] = true
+	case n > 1500:
+		features["length:long"] = true
+	}
+
+	for _, word := range words(text) {
+		features[word] = true
+	}
+	return features
+}
+
+// words splits text into lowercase words of 2 to 30 letters or digits
+func words(text string) []string {
+	var out []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}) {
+		w = strings.Trim(w, "'")
+		if n := len([]rune(w)); n >= 2 && n <= 30 {
+			out = append(out, w)
+		}
+	}
+	return out
+}
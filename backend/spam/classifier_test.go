@@ -0,0 +1,158 @@
+package spam
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFeatures(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment Comment
+		want    []string // Must be present
+		wantNot []string // Must be absent
+	}{
+		{
+			name:    "words and signals",
+			comment: Comment{Author: "Amina Bello", Email: "amina@Example.org", Content: "Thank you for this report on youth turnout."},
+			want:    []string{"thank", "report", "youth", "turnout", "author:amina", "author:bello", "from:example.org", "links:0"},
+			wantNot: []string{"a", "shouting", "length:short", "contains-email"},
+		},
+		{
+			name:    "links and hosts",
+			comment: Comment{Content: `Buy now at https://www.Cheap-Pills.example/buy and www.other.example <a href="http://third.example">here</a>`},
+			want:    []string{"host:cheap-pills.example", "host:other.example", "host:third.example", "links:2+", "buy", "now", "here"},
+			wantNot: []string{"https", "www", "href"},
+		},
+		{
+			name:    "one link",
+			comment: Comment{Content: "See https://yiaga.org/report for the full findings"},
+			want:    []string{"host:yiaga.org", "links:1"},
+		},
+		{
+			name:    "email in text",
+			comment: Comment{Content: "Contact me on winner@lottery.example for your prize money"},
+			want:    []string{"contains-email"},
+		},
+		{
+			name:    "shouting",
+			comment: Comment{Content: "CLICK HERE TO CLAIM YOUR FREE PRIZE TODAY"},
+			want:    []string{"shouting", "click", "prize"},
+		},
+		{
+			name:    "short",
+			comment: Comment{Content: "Nice!"},
+			want:    []string{"length:short"},
+		},
+		{
+			name:    "long",
+			comment: Comment{Content: strings.Repeat("observers ", 200)},
+			want:    []string{"length:long", "observers"},
+			wantNot: []string{"shouting"},
+		},
+		{
+			name:    "apostrophes and word length",
+			comment: Comment{Content: "'quoted' Nigeria's " + strings.Repeat("x", 31)},
+			want:    []string{"quoted", "nigeria's"},
+			wantNot: []string{"'quoted'", strings.Repeat("x", 31)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Features(tt.comment)
+			for _, f := range tt.want {
+				if !got[f] {
+					t.Errorf("missing %q in %v", f, sortedKeys(got))
+				}
+			}
+			for _, f := range tt.wantNot {
+				if got[f] {
+					t.Errorf("unexpected %q in %v", f, sortedKeys(got))
+				}
+			}
+		})
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func trainingSet() []Example {
+	var examples []Example
+	for i := 0; i < 20; i++ {
+		examples = append(examples,
+			Example{Comment: Comment{Author: "Promo", Email: "deals@spam.example", Content: "Cheap pills, buy now at https://pills.example"}, Spam: true},
+			Example{Comment: Comment{Author: "Amina", Email: "amina@gmail.com", Content: "Thank you for the report on voter turnout in Kano."}},
+		)
+	}
+	return examples
+}
+
+func TestTrain(t *testing.T) {
+	c := Train(trainingSet())
+	if c.SpamDocs != 20 || c.HamDocs != 20 {
+		t.Fatalf("trained on %d spam and %d ham, want 20 and 20", c.SpamDocs, c.HamDocs)
+	}
+	// Features count once per comment however often they repeat
+	if c.Spam["host:pills.example"] != 20 || c.Ham["host:pills.example"] != 0 {
+		t.Errorf("host:pills.example counted %d spam, %d ham", c.Spam["host:pills.example"], c.Ham["host:pills.example"])
+	}
+	if c.Ham["turnout"] != 20 {
+		t.Errorf("turnout counted %d times in ham, want 20", c.Ham["turnout"])
+	}
+}
+
+func TestScore(t *testing.T) {
+	c := Train(trainingSet())
+	tests := []struct {
+		name     string
+		comment  Comment
+		min, max float64
+	}{
+		{"spam like", Comment{Author: "Promo", Email: "x@spam.example", Content: "Buy cheap pills now https://pills.example"}, 0.99, 1},
+		{"ham like", Comment{Author: "Tunde", Email: "t@gmail.com", Content: "Thank you for the report on turnout"}, 0, 0.01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, reasons := c.Score(tt.comment)
+			if score < tt.min || score > tt.max {
+				t.Errorf("score %.4f, want between %.2f and %.2f (reasons %v)", score, tt.min, tt.max, reasons)
+			}
+			if len(reasons) > 8 {
+				t.Errorf("%d reasons, want at most 8", len(reasons))
+			}
+			for i := 1; i < len(reasons); i++ {
+				if math.Abs(reasons[i].Weight) > math.Abs(reasons[i-1].Weight) {
+					t.Errorf("reasons not strongest first: %v", reasons)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestScoreIgnoresUnseenFeatures(t *testing.T) {
+	c := Train(trainingSet())
+	score, reasons := c.Score(Comment{Content: "zebra quokka pills", Email: "x@new.example"})
+	weights := map[string]float64{}
+	for _, r := range reasons {
+		weights[r.Feature] = r.Weight
+	}
+	// links:0 is ham-only and pills spam-only, each seen in 20 comments, so
+	// they cancel out and the unseen words leave the even prior
+	want := map[string]float64{"links:0": -3.0, "pills": 3.0}
+	if len(weights) != len(want) || weights["links:0"] != want["links:0"] || weights["pills"] != want["pills"] {
+		t.Errorf("reasons %v, want %v", reasons, want)
+	}
+	if math.Abs(score-0.5) > 1e-9 {
+		t.Errorf("score %v, want 0.5", score)
+	}
+}
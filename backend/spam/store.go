@@ -0,0 +1,132 @@
+package spam
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+)
+
+// minExamples of each class are needed before scores are trusted
+const minExamples = 10
+
+// trainingLimit caps how many recent moderated comments of each class are used
+const trainingLimit = 5000
+
+var current struct {
+	sync.RWMutex
+	classifier *Classifier
+	info       models.SpamModel
+}
+
+// Threshold is the score at or above which comments are rejected
+// automatically, SPAM_REJECT_THRESHOLD or 0.99
+func Threshold() float64 {
+	if t, err := strconv.ParseFloat(os.Getenv("SPAM_REJECT_THRESHOLD"), 64); err == nil && t > 0 && t <= 1 {
+		return t
+	}
+	return 0.99
+}
+
+// Evaluate scores a comment with the current model. ok is false until the
+// model has seen enough approved and rejected comments.
+func Evaluate(comment Comment) (score float64, reasons []Reason, ok bool) {
+	current.RLock()
+	c := current.classifier
+	current.RUnlock()
+	if c == nil || c.SpamDocs < minExamples || c.HamDocs < minExamples {
+		return 0, nil, false
+	}
+	score, reasons = c.Score(comment)
+	return score, reasons, true
+}
+
+// Info describes the model in use
+func Info() models.SpamModel {
+	current.RLock()
+	defer current.RUnlock()
+	return current.info
+}
+
+// Load restores the last trained model from the database
+func Load() error {
+	var stored models.SpamModel
+	if err := database.DB.Order("created_at desc").First(&stored).Error; err != nil {
+		return err
+	}
+	var c Classifier
+	if err := json.Unmarshal([]byte(stored.Data), &c); err != nil {
+		return err
+	}
+	current.Lock()
+	current.classifier, current.info = &c, stored
+	current.Unlock()
+	return nil
+}
+
+// Retrain learns from every comment a moderator has approved or rejected.
+// Automatic rejections nobody has reviewed are left out, so the model does
+// not teach itself its own mistakes, and so are staff replies, which are
+// approved without moderation. Deleted rejections still count as spam, as
+// deleting is how the queue is cleared; a deleted approved comment was taken
+// down, so it no longer counts as ham.
+func Retrain() (models.SpamModel, error) {
+	var examples []Example
+	for _, class := range []struct {
+		status  string
+		spam    bool
+		deleted bool
+	}{{"approved", false, false}, {"rejected", true, true}} {
+		query := database.DB
+		if class.deleted {
+			query = query.Unscoped()
+		}
+		var comments []models.Comment
+		err := query.
+			Select("author", "email", "content").
+			Where("status = ? AND auto_rejected = ? AND is_staff = ?", class.status, false, false).
+			Order("created_at desc").Limit(trainingLimit).
+			Find(&comments).Error
+		if err != nil {
+			return models.SpamModel{}, err
+		}
+		for _, c := range comments {
+			examples = append(examples, Example{Comment: Comment{Author: c.Author, Email: c.Email, Content: c.Content}, Spam: class.spam})
+		}
+	}
+
+	c := Train(examples)
+	data, err := json.Marshal(c)
+	if err != nil {
+		return models.SpamModel{}, err
+	}
+	stored := models.SpamModel{SpamDocs: c.SpamDocs, HamDocs: c.HamDocs, Features: len(c.Spam) + len(c.Ham), Data: string(data)}
+	if err := database.DB.Create(&stored).Error; err != nil {
+		return models.SpamModel{}, err
+	}
+	// Only the newest model is kept
+	database.DB.Unscoped().Where("id <> ?", stored.ID).Delete(&models.SpamModel{})
+
+	current.Lock()
+	current.classifier, current.info = c, stored
+	current.Unlock()
+	return stored, nil
+}
+
+// RunTraining loads the stored model, then retrains every interval
+func RunTraining(interval time.Duration) {
+	if err := Load(); err != nil {
+		log.Printf("spam: no stored model yet: %v", err)
+	}
+	for {
+		if _, err := Retrain(); err != nil {
+			log.Printf("spam retraining failed: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
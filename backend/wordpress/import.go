@@ -0,0 +1,408 @@
+package wordpress
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"yiaga-backend/database"
+	"yiaga-backend/models"
+	"yiaga-backend/pdftext"
+	"yiaga-backend/sanitize"
+	"yiaga-backend/slug"
+)
+
+// Options controls how an export is imported
+type Options struct {
+	// UploadsDir is a local copy of wp-content/uploads. Media found there is
+	// copied into MediaDir; anything else is left linked to the old site
+	// unless Download is set, which only fetches from the export's own site.
+	UploadsDir string
+	Download   bool
+	// MediaDir is where media is copied, served under /src/assets/wp/
+	MediaDir string
+	// Update overwrites posts imported by an earlier run. Without it they are
+	// left alone, so editors' changes since the first import survive.
+	Update bool
+	// NewsCategories are category nicenames that make a post "news" rather
+	// than "blog"
+	NewsCategories []string
+	// Location is the site's timezone, for exports without GMT dates
+	Location *time.Location
+}
+
+// DefaultOptions reads WP_UPLOADS_DIR and WP_DOWNLOAD_MEDIA
+func DefaultOptions() Options {
+	location, err := time.LoadLocation("Africa/Lagos")
+	if err != nil {
+		location = time.UTC
+	}
+	return Options{
+		UploadsDir:     os.Getenv("WP_UPLOADS_DIR"),
+		Download:       os.Getenv("WP_DOWNLOAD_MEDIA") == "true",
+		MediaDir:       filepath.Join("..", "src", "assets", "wp"),
+		NewsCategories: []string{"news", "press-release", "press-releases"},
+		Location:       location,
+	}
+}
+
+// Report summarises an import run
+type Report struct {
+	Created   int      `json:"created"`
+	Updated   int      `json:"updated"`
+	Unchanged int      `json:"unchanged"` // Imported before and not updated
+	Skipped   int      `json:"skipped"`   // Drafts, private posts, pages, attachments
+	Media     int      `json:"media"`     // Files copied or downloaded
+	Redirects int      `json:"redirects"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+func (r *Report) warn(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Import ingests a WXR export into blog posts. Posts are matched to earlier
+// runs by their WordPress ID, so an export can be imported repeatedly.
+func Import(r io.Reader, opts Options) (*Report, error) {
+	doc, err := parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading WXR: %w", err)
+	}
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+
+	authors := map[string]string{}
+	for _, a := range doc.Channel.Authors {
+		name := strings.TrimSpace(a.DisplayName)
+		if name == "" {
+			name = strings.TrimSpace(a.FirstName + " " + a.LastName)
+		}
+		if name == "" {
+			name = a.Login
+		}
+		authors[a.Login] = name
+	}
+
+	attachments := map[string]string{}
+	for _, it := range doc.Channel.Items {
+		if it.PostType == "attachment" && it.AttachmentURL != "" {
+			attachments[strconv.FormatUint(uint64(it.PostID), 10)] = it.AttachmentURL
+		}
+	}
+
+	report := &Report{}
+	m := &media{opts: opts, report: report, done: map[string]string{}, hosts: map[string]bool{}}
+	for _, base := range []string{doc.Channel.BaseSiteURL, doc.Channel.BaseBlogURL} {
+		if u, err := url.Parse(strings.TrimSpace(base)); err == nil && u.Hostname() != "" {
+			m.hosts[siteHost(u.Hostname())] = true
+		}
+	}
+	for _, it := range doc.Channel.Items {
+		if it.PostType != "post" || (it.Status != "publish" && it.Status != "future") {
+			report.Skipped++
+			continue
+		}
+		if err := importPost(it, authors, attachments, m, opts, report); err != nil {
+			report.warn("post %d %q: %v", it.PostID, it.Title, err)
+		}
+	}
+	return report, nil
+}
+
+func importPost(it item, authors, attachments map[string]string, m *media, opts Options, report *Report) error {
+	var existing models.BlogPost
+	err := database.DB.Unscoped().Where("legacy_id = ?", it.PostID).First(&existing).Error
+	found := err == nil
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	if found && existing.DeletedAt.Valid {
+		// Deleted here since the last run; stays deleted, even with Update
+		report.Unchanged++
+		return nil
+	}
+	if found && !opts.Update {
+		report.Unchanged++
+		return recordRedirects(it, existing, report)
+	}
+
+	post := existing
+	post.LegacyID = it.PostID
+	post.Title = strings.TrimSpace(it.Title)
+	post.Content = sanitize.RichText(m.relinkAll(autop(it.content())))
+	post.Excerpt = sanitize.PlainText(it.excerpt())
+	if post.Excerpt == "" {
+		post.Excerpt = pdftext.Excerpt(sanitize.PlainText(post.Content), 280)
+	}
+	post.Author = authors[it.Creator]
+	if post.Author == "" {
+		post.Author = it.Creator
+	}
+	post.PublishedAt = postDate(it, opts.Location)
+	post.Date = post.PublishedAt.Format("Jan 2, 2006")
+
+	post.Type = "blog"
+	post.Category = ""
+	post.Tags = nil
+	for _, c := range it.Categories {
+		switch c.Domain {
+		case "category":
+			for _, news := range opts.NewsCategories {
+				if c.Nicename == news {
+					post.Type = "news"
+				}
+			}
+			if post.Category == "" && c.Nicename != "uncategorized" {
+				post.Category = strings.TrimSpace(c.Name)
+			}
+		case "post_tag":
+			post.Tags = append(post.Tags, strings.TrimSpace(c.Name))
+		}
+	}
+
+	if thumb := attachments[it.meta("_thumbnail_id")]; thumb != "" {
+		post.Image = m.relink(thumb)
+	}
+
+	if !found {
+		post.Slug, err = freeSlug(it)
+		if err != nil {
+			return err
+		}
+		if err := database.DB.Create(&post).Error; err != nil {
+			return err
+		}
+		report.Created++
+	} else {
+		post.Version++
+		if err := database.DB.Save(&post).Error; err != nil {
+			return err
+		}
+		report.Updated++
+	}
+	return recordRedirects(it, post, report)
+}
+
+// freeSlug keeps the WordPress slug unless a post written here already uses it
+func freeSlug(it item) (string, error) {
+	base, err := url.PathUnescape(it.PostName)
+	if err != nil || slug.Validate(base) != nil {
+		// Non-ASCII or empty slugs are regenerated the way new posts get theirs
+		base = slug.Make(base)
+		if base == "" {
+			base = slug.Make(it.Title)
+		}
+	}
+	for n := 1; ; n++ {
+		candidate := base
+		if n > 1 {
+			candidate = fmt.Sprintf("%s-%d", base, n)
+		}
+		var count int64
+		if err := database.DB.Unscoped().Model(&models.BlogPost{}).Where("slug = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+}
+
+// postDate prefers the GMT timestamp, then the local one, then pubDate
+func postDate(it item, location *time.Location) time.Time {
+	const layout = "2006-01-02 15:04:05"
+	if t, err := time.Parse(layout, it.PostDateGMT); err == nil && t.Year() > 1 {
+		return t
+	}
+	if t, err := time.ParseInLocation(layout, it.PostDate, location); err == nil && t.Year() > 1 {
+		return t.UTC()
+	}
+	if t, err := time.Parse(time.RFC1123Z, it.PubDate); err == nil {
+		return t.UTC()
+	}
+	return time.Now()
+}
+
+// recordRedirects points the old permalink and the ?p= shortlink at the post
+func recordRedirects(it item, post models.BlogPost, report *Report) error {
+	target := "/blog/" + post.Slug
+	if post.Type == "news" {
+		target = "/news/" + post.Slug
+	}
+
+	var from []string
+	if u, err := url.Parse(it.Link); err == nil && u.Path != "" && u.Path != "/" {
+		p := strings.TrimSuffix(u.Path, "/")
+		if u.RawQuery != "" {
+			p += "?" + u.RawQuery
+		}
+		from = append(from, p)
+	}
+	from = append(from, fmt.Sprintf("/?p=%d", it.PostID))
+
+	for _, old := range from {
+		if old == target {
+			continue
+		}
+		redirect := models.Redirect{FromPath: old, ToPath: target, StatusCode: http.StatusMovedPermanently}
+		err := database.DB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "from_path"}},
+			DoUpdates: clause.AssignmentColumns([]string{"to_path", "updated_at", "deleted_at"}),
+		}).Create(&redirect).Error
+		if err != nil {
+			return err
+		}
+		report.Redirects++
+	}
+	return nil
+}
+
+var (
+	uploadURL    = regexp.MustCompile(`(?:https?:)?//[^\s"'()<>]+/wp-content/uploads/[^\s"'()<>?#,]+`)
+	captionCode  = regexp.MustCompile(`(?s)\[caption[^\]]*\](.*?)\[/caption\]`)
+	captionImage = regexp.MustCompile(`(?s)^\s*((?:<a[^>]*>)?\s*<img[^>]*>\s*(?:</a>)?)(.*)$`)
+	shortcode    = regexp.MustCompile(`\[/?[a-z_-]+(?:\s[^\]]*)?\]`)
+	blankLine    = regexp.MustCompile(`\n\s*\n`)
+	blockStart   = regexp.MustCompile(`(?i)^<(p|div|h[1-6]|ul|ol|li|blockquote|table|figure|pre|hr|iframe)[\s>/]`)
+)
+
+// autop turns the classic editor's blank-line paragraphs into HTML the way
+// WordPress does when rendering, and unwraps shortcodes. Block editor
+// content already carries its own <p> tags and is left alone.
+func autop(content string) string {
+	content = captionCode.ReplaceAllStringFunc(content, func(s string) string {
+		inner := captionCode.FindStringSubmatch(s)[1]
+		parts := captionImage.FindStringSubmatch(inner)
+		if parts == nil {
+			return inner
+		}
+		return "<figure>" + parts[1] + "<figcaption>" + strings.TrimSpace(parts[2]) + "</figcaption></figure>"
+	})
+	content = shortcode.ReplaceAllString(content, "")
+
+	if strings.Contains(content, "<p>") || strings.Contains(content, "<p ") {
+		return content
+	}
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	var out strings.Builder
+	for _, para := range blankLine.Split(content, -1) {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		if blockStart.MatchString(para) {
+			out.WriteString(para)
+		} else {
+			out.WriteString("<p>" + strings.ReplaceAll(para, "\n", "<br>\n") + "</p>")
+		}
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// media copies or downloads files from the old uploads directory, once each
+type media struct {
+	opts   Options
+	report *Report
+	done   map[string]string
+	hosts  map[string]bool // The export's site, the only host downloaded from
+}
+
+// siteHost compares hosts with and without a leading www.
+func siteHost(host string) string {
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+func (m *media) relinkAll(content string) string {
+	return uploadURL.ReplaceAllStringFunc(content, m.relink)
+}
+
+// relink returns the new URL for a WordPress upload, or the original URL when
+// the file is neither available locally nor downloadable
+func (m *media) relink(src string) string {
+	if to, ok := m.done[src]; ok {
+		return to
+	}
+	to := src
+	if i := strings.Index(src, "/wp-content/uploads/"); i >= 0 {
+		rel := path.Clean(strings.TrimPrefix(src[i:], "/wp-content/uploads/"))
+		if rel != "." && !strings.HasPrefix(rel, "..") {
+			if err := m.fetch(src, rel); err != nil {
+				m.report.warn("media %s: %v", src, err)
+			} else {
+				to = "/src/assets/wp/" + rel
+			}
+		}
+	}
+	m.done[src] = to
+	return to
+}
+
+func (m *media) fetch(src, rel string) error {
+	dst := filepath.Join(m.opts.MediaDir, filepath.FromSlash(rel))
+	if _, err := os.Stat(dst); err == nil {
+		return nil // Copied on an earlier run
+	}
+
+	var body io.ReadCloser
+	if local := filepath.Join(m.opts.UploadsDir, filepath.FromSlash(rel)); m.opts.UploadsDir != "" {
+		if f, err := os.Open(local); err == nil {
+			body = f
+		}
+	}
+	if body == nil {
+		if !m.opts.Download {
+			return fmt.Errorf("not in uploads directory")
+		}
+		if strings.HasPrefix(src, "//") {
+			src = "https:" + src
+		}
+		u, err := url.Parse(src)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !m.hosts[siteHost(u.Hostname())] {
+			return fmt.Errorf("not on the exported site, not downloading")
+		}
+		resp, err := mediaClient.Get(src)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("download: %s", resp.Status)
+		}
+		body = resp.Body
+	}
+	defer body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp := dst + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	m.report.Media++
+	return os.Rename(tmp, dst)
+}
+
+var mediaClient = &http.Client{Timeout: time.Minute}
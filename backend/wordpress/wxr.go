@@ -0,0 +1,105 @@
+package wordpress
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// The WXR format is RSS 2.0 extended with the wp:, content:, excerpt: and dc:
+// namespaces. The wp: namespace URI changes with the export version (1.0 to
+// 1.2), so fields are matched on local names only, except where two
+// namespaces share one (content:encoded and excerpt:encoded).
+
+type export struct {
+	Channel struct {
+		BaseSiteURL string   `xml:"base_site_url"`
+		BaseBlogURL string   `xml:"base_blog_url"`
+		Authors     []author `xml:"author"`
+		Items       []item   `xml:"item"`
+	} `xml:"channel"`
+}
+
+type author struct {
+	Login       string `xml:"author_login"`
+	DisplayName string `xml:"author_display_name"`
+	FirstName   string `xml:"author_first_name"`
+	LastName    string `xml:"author_last_name"`
+}
+
+type item struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	PubDate       string     `xml:"pubDate"`
+	Creator       string     `xml:"creator"`
+	GUID          string     `xml:"guid"`
+	Encoded       []encoded  `xml:"encoded"`
+	PostID        uint       `xml:"post_id"`
+	PostDate      string     `xml:"post_date"`
+	PostDateGMT   string     `xml:"post_date_gmt"`
+	PostName      string     `xml:"post_name"`
+	Status        string     `xml:"status"`
+	PostType      string     `xml:"post_type"`
+	AttachmentURL string     `xml:"attachment_url"`
+	Categories    []category `xml:"category"`
+	Meta          []postmeta `xml:"postmeta"`
+}
+
+type encoded struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+type category struct {
+	Domain   string `xml:"domain,attr"` // "category" or "post_tag"
+	Nicename string `xml:"nicename,attr"`
+	Name     string `xml:",chardata"`
+}
+
+type postmeta struct {
+	Key   string `xml:"meta_key"`
+	Value string `xml:"meta_value"`
+}
+
+// content returns the post body from content:encoded
+func (it item) content() string {
+	for _, e := range it.Encoded {
+		if strings.Contains(e.XMLName.Space, "/content/") {
+			return e.Value
+		}
+	}
+	return ""
+}
+
+// excerpt returns the hand written excerpt from excerpt:encoded, if any
+func (it item) excerpt() string {
+	for _, e := range it.Encoded {
+		if strings.Contains(e.XMLName.Space, "/excerpt/") {
+			return strings.TrimSpace(e.Value)
+		}
+	}
+	return ""
+}
+
+func (it item) meta(key string) string {
+	for _, m := range it.Meta {
+		if m.Key == key {
+			return m.Value
+		}
+	}
+	return ""
+}
+
+// parse decodes a WXR document. Older exports are not always UTF-8.
+func parse(r io.Reader) (*export, error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = charset.NewReaderLabel
+	d.Strict = false
+	var doc export
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
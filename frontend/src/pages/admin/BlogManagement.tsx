@@ -46,7 +46,7 @@ const BlogManagement = () => {
   });
 
   const updateMutation = useMutation({
-    mutationFn: ({ id, data }: { id: number; data: any }) => api.updateBlogPost(id, data),
+    mutationFn: ({ id, data, version }: { id: number; data: any; version?: number }) => api.updateBlogPost(id, data, version),
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: ['blogs'] });
       addAuditLog('UPDATE_BLOG', `Updated blog post`);
@@ -103,7 +103,7 @@ const BlogManagement = () => {
 
   const handleUpdate = () => {
     if (!editingPost) return;
-    updateMutation.mutate({ id: editingPost.id, data: formData });
+    updateMutation.mutate({ id: editingPost.id, data: formData, version: editingPost.version });
   };
 
   const handleDelete = (post: BlogPost) => {
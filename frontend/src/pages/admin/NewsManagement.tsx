@@ -52,7 +52,7 @@ const NewsManagement = () => {
   });
 
   const updateMutation = useMutation({
-    mutationFn: (data: any) => api.updateBlogPost(data.id, data),
+    mutationFn: (data: any) => api.updateBlogPost(data.id, data, data.version),
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: ['blogs'] });
       addAuditLog('UPDATE_NEWS', 'Updated news article');
@@ -87,7 +87,7 @@ const NewsManagement = () => {
 
   const handleUpdate = () => {
     if (editingNews) {
-      updateMutation.mutate({ ...formData, id: editingNews.id });
+      updateMutation.mutate({ ...formData, id: editingNews.id, version: editingNews.version });
     }
   };
 
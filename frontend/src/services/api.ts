@@ -23,6 +23,7 @@ export interface BlogPost {
     type: string;
     tags: string[];
     pdf_url?: string;
+    version?: number;
 }
 
 export interface NewsItem extends BlogPost { }
@@ -41,6 +42,7 @@ export interface Initiative {
     activities: string[];
     stats: { label: string; value: string; }[];
     color: string;
+    version?: number;
 }
 
 export interface Resource {
@@ -71,6 +73,22 @@ export interface Job {
 
 const API_URL = import.meta.env.VITE_API_BASE_URL
 
+// Posts and initiatives are saved against the version the editor loaded,
+// which the API's ETag encodes. A save made after someone else's is
+// refused with 412 rather than overwriting it.
+const saveVersioned = async (url: string, data: any, version: number | undefined, what: string) => {
+    const response = await fetch(url, {
+        method: 'PUT',
+        headers: { ...getAuthHeaders(), ...(version ? { 'If-Match': `"v${version}"` } : {}) },
+        body: JSON.stringify(data)
+    });
+    if (response.status === 412) {
+        throw new Error(`This ${what} was changed by someone else since you opened it. Reload it and make your changes again.`);
+    }
+    if (!response.ok) throw new Error(`Failed to update ${what}`);
+    return await response.json();
+};
+
 const getAuthHeaders = () => {
     // In a real app, use a more secure storage or httpOnly cookies
     const token = localStorage.getItem('token');
@@ -276,14 +294,8 @@ export const api = {
         return await response.json();
     },
 
-    updateBlogPost: async (id: number, data: any): Promise<BlogPost> => {
-        const response = await fetch(`${API_URL}/blogs/${id}`, {
-            method: 'PUT',
-            headers: getAuthHeaders(),
-            body: JSON.stringify(data)
-        });
-        if (!response.ok) throw new Error("Failed to update blog post");
-        return await response.json();
+    updateBlogPost: async (id: number, data: any, version?: number): Promise<BlogPost> => {
+        return saveVersioned(`${API_URL}/blogs/${id}`, data, version, "blog post");
     },
 
     deleteBlogPost: async (id: number): Promise<void> => {
@@ -338,14 +350,8 @@ export const api = {
         if (!response.ok) throw new Error("Failed");
         return await response.json();
     },
-    updateInitiative: async (id: number, data: any): Promise<Initiative> => {
-        const response = await fetch(`${API_URL}/initiatives/${id}`, {
-            method: 'PUT',
-            headers: getAuthHeaders(),
-            body: JSON.stringify(data)
-        });
-        if (!response.ok) throw new Error("Failed");
-        return await response.json();
+    updateInitiative: async (id: number, data: any, version?: number): Promise<Initiative> => {
+        return saveVersioned(`${API_URL}/initiatives/${id}`, data, version, "initiative");
     },
     deleteInitiative: async (id: number): Promise<void> => {
         const response = await fetch(`${API_URL}/initiatives/${id}`, {